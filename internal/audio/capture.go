@@ -1,6 +1,7 @@
 package audio
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"sync"
@@ -279,7 +280,8 @@ func (ac *AudioCapture) doSummarize() {
 	text := strings.Join(ac.rawChunks, " ")
 	ac.mu.Unlock()
 
-	summary, err := ac.summarize(text)
+	ctx := model.WithRoute(model.WithPriority(context.Background(), model.PriorityBackground), model.RouteSummary)
+	summary, err := ac.summarize(ctx, text)
 	if err != nil {
 		fmt.Printf("[audio-capture] summarize error: %v\n", err)
 		ac.mu.Lock()
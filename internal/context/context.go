@@ -10,44 +10,96 @@ import (
 
 var CtxFileSelection = NewContextFileSelection()
 
+// ContextFileSelection holds what the user chose in the Context tab, per
+// context root.
 type ContextFileSelection struct {
-	mu       sync.Mutex
+	mu     sync.Mutex
+	byRoot map[string]selection // absolute root → its choices
+}
+
+// selection is one root's choices, or a copy of them.
+type selection struct {
 	excluded map[string]bool
+	modes    map[string]string // rel → ModeFull or ModeOutline; ModeAuto is absent
+}
+
+func newSelection() selection {
+	return selection{excluded: make(map[string]bool), modes: make(map[string]string)}
 }
 
 func NewContextFileSelection() *ContextFileSelection {
-	return &ContextFileSelection{excluded: make(map[string]bool)}
+	return &ContextFileSelection{byRoot: make(map[string]selection)}
+}
+
+// root returns root's choices, creating them; called with c.mu held.
+func (c *ContextFileSelection) root(root string) selection {
+	key := rootKey(root)
+	sel, ok := c.byRoot[key]
+	if !ok {
+		sel = newSelection()
+		c.byRoot[key] = sel
+	}
+	return sel
 }
 
-func (c *ContextFileSelection) SetExcluded(rel string, excluded bool) {
+func (c *ContextFileSelection) SetExcluded(root, rel string, excluded bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	sel := c.root(root)
 	if excluded {
-		c.excluded[rel] = true
+		sel.excluded[rel] = true
+		return
+	}
+	delete(sel.excluded, rel)
+}
+
+func (c *ContextFileSelection) IsExcluded(root, rel string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.byRoot[rootKey(root)].excluded[rel]
+}
+
+// SetMode chooses how rel is sent: ModeAuto, ModeFull or ModeOutline.
+func (c *ContextFileSelection) SetMode(root, rel, mode string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sel := c.root(root)
+	if mode == ModeAuto {
+		delete(sel.modes, rel)
 		return
 	}
-	delete(c.excluded, rel)
+	sel.modes[rel] = mode
 }
 
-func (c *ContextFileSelection) IsExcluded(rel string) bool {
+func (c *ContextFileSelection) Mode(root, rel string) string {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return c.excluded[rel]
+	return c.byRoot[rootKey(root)].modes[rel]
 }
 
-func (c *ContextFileSelection) ExcludedSet() map[string]bool {
+func (c *ContextFileSelection) selection(root string) selection {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	cp := make(map[string]bool, len(c.excluded))
-	for k, v := range c.excluded {
-		cp[k] = v
+	cur, cp := c.byRoot[rootKey(root)], newSelection()
+	for k, v := range cur.excluded {
+		cp.excluded[k] = v
+	}
+	for k, v := range cur.modes {
+		cp.modes[k] = v
 	}
 	return cp
 }
 
+// Forget drops the choices for root, when it is removed or picked again.
+func (c *ContextFileSelection) Forget(root string) {
+	c.mu.Lock()
+	delete(c.byRoot, rootKey(root))
+	c.mu.Unlock()
+}
+
 func (c *ContextFileSelection) Clear() {
 	c.mu.Lock()
-	c.excluded = make(map[string]bool)
+	c.byRoot = make(map[string]selection)
 	c.mu.Unlock()
 }
 
@@ -79,7 +131,7 @@ func readContextFile(path string, maxSize int64) (string, bool) {
 		fmt.Fprintf(os.Stderr, "context: skipping %s (too large: %d bytes)\n", path, info.Size())
 		return "", false
 	}
-	data, err := os.ReadFile(path)
+	data, err := CtxWatcher.read(path)
 	if err != nil {
 		return "", false
 	}
@@ -90,107 +142,150 @@ func readContextFile(path string, maxSize int64) (string, bool) {
 	return string(data), true
 }
 
-func ListContextFiles(path string) []string {
-	if path == "" {
-		return nil
+// ListContextFiles returns the files under the roots that their ignore
+// files and rules let through and that are not excluded in the Context tab,
+// in walk order, whatever their size or the budget. With several roots the
+// names carry the root's label.
+func ListContextFiles(paths []string) []string {
+	var files []string
+	for _, rt := range namedRoots(paths) {
+		files = listRoot(rt, files)
 	}
-	info, err := os.Stat(path)
+	return files
+}
+
+func listRoot(rt root, files []string) []string {
+	info, err := rt.stat()
 	if err != nil {
-		return nil
+		return files
 	}
-	if !info.IsDir() {
-		return []string{filepath.Base(path)}
+	sel := CtxFileSelection.selection(rt.path)
+	if !info.IsDir() && !sel.excluded[filepath.Base(rt.path)] {
+		return append(files, rt.name(filepath.Base(rt.path)))
 	}
-	var files []string
-	filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
-		if err != nil {
-			return nil
-		}
-		if d.IsDir() && strings.HasPrefix(d.Name(), ".") {
-			return filepath.SkipDir
-		}
-		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
-			return nil
-		}
-		if len(files) >= contextMaxFiles {
+	walk(rt.path, func(rel string, d os.DirEntry, skip string) error {
+		if len(files) >= contextMaxListed {
 			return filepath.SkipAll
 		}
-		rel, _ := filepath.Rel(path, p)
-		files = append(files, rel)
+		if skip == "" && !sel.excluded[rel] {
+			files = append(files, rt.name(rel))
+		}
 		return nil
 	})
 	return files
 }
 
-func ReadContextPath(path string) string {
-	if path == "" {
-		return ""
+// ReadContextFile reads one file for the model's read_file tool. name is
+// as ListContextFiles returns it, resolved against its root (a directory,
+// or the single context file itself); it must not escape the root or be
+// excluded in the Context tab.
+func ReadContextFile(paths []string, name string) (string, error) {
+	if len(paths) == 0 {
+		return "", fmt.Errorf("no context directory selected")
 	}
-	info, err := os.Stat(path)
+	root, rel, err := resolve(paths, name)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "context: cannot stat %s: %v\n", path, err)
-		return ""
+		return "", err
 	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return "", fmt.Errorf("no context directory: %w", err)
+	}
+	clean := strings.TrimPrefix(filepath.Clean("/"+rel), "/")
+	path := filepath.Join(root, clean)
 	if !info.IsDir() {
-		return readSingleFileContext(path)
+		path = root
+	}
+	if !info.IsDir() && clean != filepath.Base(root) {
+		return "", fmt.Errorf("%s: not in context", name)
+	}
+	if CtxFileSelection.IsExcluded(root, clean) {
+		return "", fmt.Errorf("%s: excluded from context", name)
+	}
+	if skip := skipReason(root, clean); info.IsDir() && skip != "" {
+		return "", fmt.Errorf("%s: not in context (%s)", name, skip)
 	}
-	return readDirContextFiltered(path, CtxFileSelection.ExcludedSet())
-}
-
-func readSingleFileContext(path string) string {
 	content, ok := readContextFile(path, contextMaxPerFile)
 	if !ok {
-		return ""
+		return "", fmt.Errorf("%s: missing, binary or larger than %d KB", name, contextMaxPerFile/1024)
 	}
-	var buf strings.Builder
-	buf.WriteString("--- File: ")
-	buf.WriteString(filepath.Base(path))
-	buf.WriteString(" ---\n")
-	buf.WriteString(content)
-	buf.WriteString("\n\n")
-	return buf.String()
+	return content, nil
 }
 
-func readDirContextFiltered(dir string, excluded map[string]bool) string {
-	var buf strings.Builder
-	totalSize := 0
-	fileCount := 0
+// ReadContextPath returns the files of the context roots as one text
+// block. In each directory, the files most relevant to sig are chosen
+// within the root's share of the budget.
+func ReadContextPath(paths []string, sig Signals) string {
+	return ReadSnapshot(paths, sig).Text()
+}
 
-	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return nil
-		}
-		if d.IsDir() && strings.HasPrefix(d.Name(), ".") {
-			return filepath.SkipDir
-		}
-		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
-			return nil
-		}
-		if fileCount >= contextMaxFiles {
-			fmt.Fprintf(os.Stderr, "context: file limit reached (%d files)\n", contextMaxFiles)
-			return filepath.SkipAll
-		}
-		rel, _ := filepath.Rel(dir, path)
-		if excluded[rel] {
-			return nil
-		}
-		content, ok := readContextFile(path, contextMaxPerFile)
-		if !ok {
-			return nil
-		}
-		if totalSize+len(content) > contextMaxTotal {
-			fmt.Fprintf(os.Stderr, "context: total size limit reached (%d bytes)\n", contextMaxTotal)
-			return filepath.SkipAll
+// ReadSnapshot reads the files ReadContextPath sends and starts watching
+// the roots for changes.
+func ReadSnapshot(paths []string, sig Signals) Snapshot {
+	snap := newSnapshot(paths)
+	if len(paths) == 0 {
+		return snap
+	}
+	CtxWatcher.Watch(paths...)
+	r := newRanker(sig)
+	for _, rt := range namedRoots(paths) {
+		readRoot(&snap, rt, r, share(len(paths)))
+	}
+	return snap
+}
+
+func readRoot(snap *Snapshot, rt root, r ranker, b budget) {
+	info, err := rt.stat()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "context: cannot stat %s: %v\n", rt.path, err)
+		return
+	}
+	sel := CtxFileSelection.selection(rt.path)
+	if !info.IsDir() {
+		readSingleFileContext(snap, rt, info, sel, r, &b)
+		return
+	}
+	readDirContextFiltered(snap, rt, sel, r, b)
+}
+
+func readSingleFileContext(snap *Snapshot, rt root, info os.FileInfo, sel selection, r ranker, b *budget) {
+	f := singleFile(rt.path, info, sel, r, b)
+	if f.Skip != "" {
+		fmt.Fprintf(os.Stderr, "context: skipping %s (%s)\n", rt.path, f.Skip)
+	}
+	addContextFile(snap, rt, filepath.Dir(rt.path), f)
+}
+
+// readDirContextFiltered collects the files scan would send.
+func readDirContextFiltered(snap *Snapshot, rt root, sel selection, r ranker, b budget) {
+	over := 0
+	for _, f := range scan(rt.path, sel, r, b) {
+		if f.Skip == SkipOverBudget {
+			over++
 		}
-		buf.WriteString("--- File: ")
-		buf.WriteString(rel)
-		buf.WriteString(" ---\n")
-		buf.WriteString(content)
-		buf.WriteString("\n\n")
-		totalSize += len(content)
-		fileCount++
-		return nil
-	})
+		addContextFile(snap, rt, rt.path, f)
+	}
+	if over > 0 {
+		fmt.Fprintf(os.Stderr, "context: %s: %d file(s) over budget (%d files, %d bytes)\n", rt.path, over, b.maxFiles, b.maxTotal)
+	}
+}
 
-	return buf.String()
+func addContextFile(snap *Snapshot, rt root, dir string, f File) {
+	if strings.HasSuffix(f.Rel, "/") {
+		return
+	}
+	snap.see(rt.path, f.Rel)
+	if f.Skip != "" || f.Excluded {
+		return
+	}
+	if f.Outline {
+		snap.addOutline(rt.name(f.Rel), f.text)
+		snap.pick(rt.path, f.Rel)
+		return
+	}
+	content, ok := readContextFile(filepath.Join(dir, filepath.FromSlash(f.Rel)), contextMaxPerFile)
+	if ok {
+		snap.add(rt.name(f.Rel), content)
+		snap.pick(rt.path, f.Rel)
+	}
 }
@@ -0,0 +1,216 @@
+package context
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines around each change.
+const diffContext = 3
+
+// maxDiffEdits bounds the edit distance searched per file. Past it a file
+// is shown as replaced wholesale, which is what such a diff amounts to.
+const maxDiffEdits = 1000
+
+// diffOp is one line of an edit script: ' ' kept, '-' removed, '+' added.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// Diff returns the changes from old to cur as unified diffs, in cur's file
+// order followed by deleted files, or "" when nothing changed.
+func Diff(old, cur Snapshot) string {
+	var b strings.Builder
+	for _, rel := range cur.Rels {
+		prev, ok := old.Files[rel]
+		b.WriteString(fileDiff(rel, prev, cur.Files[rel], ok, true))
+	}
+	for _, rel := range old.Rels {
+		if _, ok := cur.Files[rel]; !ok {
+			b.WriteString(fileDiff(rel, old.Files[rel], "", true, false))
+		}
+	}
+	return b.String()
+}
+
+// fileDiff diffs one file; a missing side is /dev/null.
+func fileDiff(rel, a, b string, hasA, hasB bool) string {
+	if hasA && hasB && a == b {
+		return ""
+	}
+	from, to := "a/"+rel, "b/"+rel
+	if !hasA {
+		from = "/dev/null"
+	}
+	if !hasB {
+		to = "/dev/null"
+	}
+	return fmt.Sprintf("--- %s\n+++ %s\n", from, to) + hunks(lineDiff(splitLines(a), splitLines(b)))
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// lineDiff returns an edit script turning a into b. Common leading and
+// trailing lines are matched first; Myers' algorithm handles the middle.
+func lineDiff(a, b []string) []diffOp {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	ops := keep(a[:pre])
+	ops = append(ops, myers(a[pre:len(a)-suf], b[pre:len(b)-suf])...)
+	return append(ops, keep(a[len(a)-suf:])...)
+}
+
+func keep(lines []string) []diffOp {
+	ops := make([]diffOp, 0, len(lines))
+	for _, l := range lines {
+		ops = append(ops, diffOp{' ', l})
+	}
+	return ops
+}
+
+// myers finds a shortest edit script. trace[d] holds the furthest x on
+// each diagonal k in [-d, d] before step d, stored at k+d.
+func myers(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	v := map[int]int{1: 0}
+	var trace [][]int
+	for d := 0; d <= n+m && d <= maxDiffEdits; d++ {
+		trace = append(trace, window(v, d))
+		for k := -d; k <= d; k += 2 {
+			x := v[k-1] + 1
+			if k == -d || (k != d && v[k-1] < v[k+1]) {
+				x = v[k+1]
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b)
+			}
+		}
+	}
+	return replaced(a, b)
+}
+
+func window(v map[int]int, d int) []int {
+	w := make([]int, 2*d+3)
+	for k := -d - 1; k <= d+1; k++ {
+		w[k+d+1] = v[k]
+	}
+	return w
+}
+
+// backtrack walks trace from the end back to the start, emitting the edit
+// script in reverse.
+func backtrack(trace [][]int, a, b []string) []diffOp {
+	x, y := len(a), len(b)
+	var ops []diffOp
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v(k-1) < v(k+1)) {
+			prevK = k + 1
+		}
+		prevX := v(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x, y = x-1, y-1
+		}
+		if d > 0 && x == prevX {
+			ops = append(ops, diffOp{'+', b[y-1]})
+		}
+		if d > 0 && x != prevX {
+			ops = append(ops, diffOp{'-', a[x-1]})
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// replaced is the edit script for a file rewritten past maxDiffEdits.
+func replaced(a, b []string) []diffOp {
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, l := range a {
+		ops = append(ops, diffOp{'-', l})
+	}
+	for _, l := range b {
+		ops = append(ops, diffOp{'+', l})
+	}
+	return ops
+}
+
+// hunks formats an edit script as unified-diff hunks. Changes separated by
+// at most twice diffContext unchanged lines share a hunk.
+func hunks(ops []diffOp) string {
+	aLine, bLine := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for i, op := range ops {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if op.kind != '+' {
+			aLine[i+1]++
+		}
+		if op.kind != '-' {
+			bLine[i+1]++
+		}
+	}
+	var b strings.Builder
+	start := nextChange(ops, 0)
+	for start < len(ops) {
+		end := hunkEnd(ops, start)
+		lo, hi := max(start-diffContext, 0), min(end+diffContext, len(ops))
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(aLine[lo], aLine[hi]), hunkRange(bLine[lo], bLine[hi]))
+		for _, op := range ops[lo:hi] {
+			b.WriteByte(op.kind)
+			b.WriteString(op.line + "\n")
+		}
+		start = nextChange(ops, end)
+	}
+	return b.String()
+}
+
+func nextChange(ops []diffOp, from int) int {
+	for i := from; i < len(ops); i++ {
+		if ops[i].kind != ' ' {
+			return i
+		}
+	}
+	return len(ops)
+}
+
+// hunkEnd returns the index after the last change in the hunk that starts
+// with the change at start.
+func hunkEnd(ops []diffOp, start int) int {
+	end := start
+	for i := start; i < len(ops) && i-end <= 2*diffContext; i++ {
+		if ops[i].kind != ' ' {
+			end = i + 1
+		}
+	}
+	return end
+}
+
+// hunkRange formats lines [from, to) in the 1-based "start,count" form.
+func hunkRange(from, to int) string {
+	if to == from {
+		return fmt.Sprintf("%d,0", from)
+	}
+	return fmt.Sprintf("%d,%d", from+1, to-from)
+}
@@ -0,0 +1,223 @@
+package context
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numbered returns "line 1" to "line n", one per line.
+func numbered(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d", i+1)
+	}
+	return lines
+}
+
+func joinLines(lines []string) string {
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// editLines returns lines with the changes applied: new text by 1-based line
+// number, "" to delete the line.
+func editLines(lines []string, changes map[int]string) []string {
+	var out []string
+	for i, l := range lines {
+		out = append(out, editLine(l, changes, i+1)...)
+	}
+	return out
+}
+
+// editLine returns line n as editLines leaves it: unchanged, replaced or
+// gone.
+func editLine(l string, changes map[int]string, n int) []string {
+	c, ok := changes[n]
+	if !ok {
+		return []string{l}
+	}
+	if c == "" {
+		return nil
+	}
+	return []string{c}
+}
+
+func TestDiff(t *testing.T) {
+	twenty := numbered(20)
+	// The goldens are what diff -u prints for the same files, except that
+	// it leaves out a hunk length of one.
+	tests := []struct {
+		name     string
+		old, cur Snapshot
+		want     string
+	}{
+		{
+			name: "unchanged",
+			old:  snapshotOf("a.go", "x\n"),
+			cur:  snapshotOf("a.go", "x\n"),
+			want: "",
+		},
+		{
+			name: "hunks apart",
+			old:  snapshotOf("a.go", joinLines(twenty)),
+			cur:  snapshotOf("a.go", joinLines(editLines(twenty, map[int]string{2: "line 2 changed", 17: ""}))),
+			want: `--- a/a.go
++++ b/a.go
+@@ -1,5 +1,5 @@
+ line 1
+-line 2
++line 2 changed
+ line 3
+ line 4
+ line 5
+@@ -14,7 +14,6 @@
+ line 14
+ line 15
+ line 16
+-line 17
+ line 18
+ line 19
+ line 20
+`,
+		},
+		{
+			name: "hunks merged across twice the context",
+			old:  snapshotOf("a.go", joinLines(twenty)),
+			cur:  snapshotOf("a.go", joinLines(editLines(twenty, map[int]string{2: "two", 9: "nine"}))),
+			want: `--- a/a.go
++++ b/a.go
+@@ -1,12 +1,12 @@
+ line 1
+-line 2
++two
+ line 3
+ line 4
+ line 5
+ line 6
+ line 7
+ line 8
+-line 9
++nine
+ line 10
+ line 11
+ line 12
+`,
+		},
+		{
+			name: "added and deleted files",
+			old:  snapshotOf("gone.go", "a\nb\n"),
+			cur:  snapshotOf("new.go", "c\n"),
+			want: `--- /dev/null
++++ b/new.go
+@@ -0,0 +1,1 @@
++c
+--- a/gone.go
++++ /dev/null
+@@ -1,2 +0,0 @@
+-a
+-b
+`,
+		},
+		{
+			name: "cur order, then deletions",
+			old:  snapshotOf("b.go", "1\n", "a.go", "1\n", "c.go", "1\n"),
+			cur:  snapshotOf("a.go", "2\n", "b.go", "2\n"),
+			want: "--- a/a.go\n+++ b/a.go\n@@ -1,1 +1,1 @@\n-1\n+2\n" +
+				"--- a/b.go\n+++ b/b.go\n@@ -1,1 +1,1 @@\n-1\n+2\n" +
+				"--- a/c.go\n+++ /dev/null\n@@ -1,1 +0,0 @@\n-1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diff(tt.old, tt.cur); got != tt.want {
+				t.Errorf("Diff =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// snapshotOf builds a snapshot from name, content pairs.
+func snapshotOf(files ...string) Snapshot {
+	s := newSnapshot(nil)
+	for i := 0; i < len(files); i += 2 {
+		s.add(files[i], files[i+1])
+	}
+	return s
+}
+
+func TestLineDiffIsShortest(t *testing.T) {
+	tests := []struct{ a, b string }{
+		{"", ""},
+		{"", "abc"},
+		{"abc", ""},
+		{"abcabba", "cbabac"},
+		{"abcdef", "abxdef"},
+		{"aaaa", "aa"},
+		{"xaxbxc", "abc"},
+		{"abc", "cba"},
+		{"abababab", "babababa"},
+	}
+	for _, tt := range tests {
+		a, b := strings.Split(tt.a, ""), strings.Split(tt.b, "")
+		ops := lineDiff(a, b)
+		var gotA, gotB []string
+		edits := 0
+		for _, op := range ops {
+			if op.kind != '+' {
+				gotA = append(gotA, op.line)
+			}
+			if op.kind != '-' {
+				gotB = append(gotB, op.line)
+			}
+			if op.kind != ' ' {
+				edits++
+			}
+		}
+		if strings.Join(gotA, "") != tt.a || strings.Join(gotB, "") != tt.b {
+			t.Errorf("%q → %q: script gives %q → %q", tt.a, tt.b, strings.Join(gotA, ""), strings.Join(gotB, ""))
+		}
+		if want := len(a) + len(b) - 2*lcs(a, b); edits != want {
+			t.Errorf("%q → %q: %d edits, want %d", tt.a, tt.b, edits, want)
+		}
+	}
+}
+
+// lcs is the length of the longest common subsequence, by dynamic
+// programming.
+func lcs(a, b []string) int {
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			dp[i][j] = max(dp[i+1][j], dp[i][j+1])
+			if a[i] == b[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			}
+		}
+	}
+	return dp[0][0]
+}
+
+func TestLineDiffPastEditLimit(t *testing.T) {
+	var a, b []string
+	for i := range maxDiffEdits {
+		a = append(a, fmt.Sprint("a", i))
+		b = append(b, fmt.Sprint("b", i))
+	}
+	a, b = append([]string{"same"}, a...), append([]string{"same"}, b...)
+	ops := lineDiff(a, b)
+	if len(ops) != 1+2*maxDiffEdits || ops[0] != (diffOp{' ', "same"}) {
+		t.Fatalf("%d ops starting %v", len(ops), ops[0])
+	}
+	for i, op := range ops[1:] {
+		want := byte('-')
+		if i >= maxDiffEdits {
+			want = '+'
+		}
+		if op.kind != want {
+			t.Fatalf("op %d is %c, want the old lines removed, then the new ones added", i+1, op.kind)
+		}
+	}
+}
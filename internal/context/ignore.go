@@ -0,0 +1,172 @@
+package context
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ignoreFiles are read in every directory the walker enters, in this
+// order, so a .ignore rule wins over a .gitignore one.
+var ignoreFiles = []string{".gitignore", ".ignore"}
+
+// defaultIgnores keep dependency trees, build output and lockfiles out of
+// the context. They come before any ignore file, so a repo can re-include
+// one with a negation such as "!vendor/".
+var defaultIgnores = parseIgnore("", strings.Join([]string{
+	"node_modules/", "vendor/", "dist/", "build/", "target/", "__pycache__/",
+	"*.min.js", "*.min.css", "*.map", "*.lock", "package-lock.json", "pnpm-lock.yaml", "go.sum",
+}, "\n"))
+
+// ignoreRule is one pattern line of an ignore file.
+type ignoreRule struct {
+	base    string // directory of the ignore file, relative to the root; "" at the root
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreList is every rule that applies in one directory, outermost
+// first. As in git, the last rule matching a path decides.
+type ignoreList []ignoreRule
+
+func parseIgnore(base, text string) ignoreList {
+	var l ignoreList
+	for _, line := range strings.Split(text, "\n") {
+		if r, ok := parseIgnoreLine(base, line); ok {
+			l = append(l, r)
+		}
+	}
+	return l
+}
+
+func parseIgnoreLine(base, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	r := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		r.negate, line = true, line[1:]
+	}
+	line = strings.TrimPrefix(line, `\`)
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly, line = true, strings.TrimRight(line, "/")
+	}
+	re, err := compileGlob(line)
+	if line == "" || err != nil {
+		return ignoreRule{}, false
+	}
+	r.re = re
+	return r, true
+}
+
+// compileGlob turns a .gitignore pattern into a regexp over slash-separated
+// paths. A pattern without an inner slash matches at any depth.
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	var b strings.Builder
+	b.WriteString("^")
+	if !anchored {
+		b.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); {
+		re, n := globToken(pattern[i:])
+		b.WriteString(re)
+		i += n
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// globTokens convert the pattern element at the start of rest to a regexp
+// and report how many bytes it takes, or false if it is another element.
+// Checked in order.
+var globTokens = []func(rest string) (string, int, bool){
+	globPrefix("**/", "(?:.*/)?"),
+	globPrefix("**", ".*"),
+	globPrefix("*", "[^/]*"),
+	globPrefix("?", "[^/]"),
+	globBracket,
+	globEscape,
+}
+
+// globToken converts the element at the start of rest; anything that is
+// not a glob element matches itself.
+func globToken(rest string) (string, int) {
+	for _, tok := range globTokens {
+		if re, n, ok := tok(rest); ok {
+			return re, n
+		}
+	}
+	return regexp.QuoteMeta(rest[:1]), 1
+}
+
+func globPrefix(prefix, re string) func(string) (string, int, bool) {
+	return func(rest string) (string, int, bool) {
+		return re, len(prefix), strings.HasPrefix(rest, prefix)
+	}
+}
+
+// globBracket converts a bracket expression; a "[" without a closing "]"
+// after a non-empty body is literal.
+func globBracket(rest string) (string, int, bool) {
+	end := strings.IndexByte(rest, ']')
+	if rest[0] != '[' || end <= 1 {
+		return "", 0, false
+	}
+	return globClass(rest[1:end]), end + 1, true
+}
+
+// globEscape matches the character after a backslash literally.
+func globEscape(rest string) (string, int, bool) {
+	if rest[0] != '\\' || len(rest) < 2 {
+		return "", 0, false
+	}
+	return regexp.QuoteMeta(rest[1:2]), 2, true
+}
+
+// globClass converts a bracket expression body, where "!" negates.
+func globClass(class string) string {
+	if rest, ok := strings.CutPrefix(class, "!"); ok {
+		class = "^" + rest
+	}
+	return "[" + class + "]"
+}
+
+// withDir returns l extended by the ignore files in directory rel of root.
+func (l ignoreList) withDir(root, rel string) ignoreList {
+	out := slices.Clip(l)
+	for _, name := range ignoreFiles {
+		data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(rel), name))
+		if err == nil {
+			out = append(out, parseIgnore(rel, string(data))...)
+		}
+	}
+	return out
+}
+
+// match reports whether the last rule matching rel is a positive one.
+func (l ignoreList) match(rel string, isDir bool) bool {
+	matched := false
+	for _, r := range l {
+		if r.matches(rel, isDir) {
+			matched = !r.negate
+		}
+	}
+	return matched
+}
+
+func (r ignoreRule) matches(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	sub, ok := rel, true
+	if r.base != "" {
+		sub, ok = strings.CutPrefix(rel, r.base+"/")
+	}
+	return ok && r.re.MatchString(sub)
+}
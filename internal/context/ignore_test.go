@@ -0,0 +1,142 @@
+package context
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnoreMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns string
+		rel      string
+		isDir    bool
+		want     bool
+	}{
+		{"basename at any depth", "*.log", "a/b/debug.log", false, true},
+		{"star stops at slash", "a/*.go", "a/b/c.go", false, false},
+		{"question mark", "file?.txt", "file1.txt", false, true},
+		{"bracket class", "file[0-9].txt", "fileA.txt", false, false},
+		{"negated bracket class", "file[!0-9].txt", "fileA.txt", false, true},
+		{"escaped hash", `\#notes`, "#notes", false, true},
+		{"comment line", "#notes", "#notes", false, false},
+		{"escaped bang", `\!keep`, "!keep", false, true},
+
+		{"leading slash anchors", "/todo.txt", "sub/todo.txt", false, false},
+		{"leading slash at root", "/todo.txt", "todo.txt", false, true},
+		{"inner slash anchors", "doc/frotz", "a/doc/frotz", false, false},
+		{"inner slash at root", "doc/frotz", "doc/frotz", true, true},
+
+		{"leading double star", "**/foo", "a/b/foo", false, true},
+		{"leading double star at root", "**/foo", "foo", false, true},
+		{"inner double star", "a/**/b", "a/x/y/b", false, true},
+		{"inner double star, zero dirs", "a/**/b", "a/b", false, true},
+		{"trailing double star", "abc/**", "abc/x/y.go", false, true},
+		{"trailing double star, not the dir", "abc/**", "abc", true, false},
+
+		{"dir-only matches dir", "build/", "app/build", true, true},
+		{"dir-only skips file", "build/", "app/build", false, false},
+		{"anchored dir-only", "/out/", "out", true, true},
+
+		{"negation re-includes", "*.log\n!keep.log", "keep.log", false, false},
+		{"last rule wins", "!keep.log\n*.log", "keep.log", false, true},
+		{"negation of a dir rule", "vendor/\n!vendor/", "vendor", true, false},
+		{"blank and trailing space", "\n  \n*.tmp   \n", "x.tmp", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseIgnore("", tt.patterns).match(tt.rel, tt.isDir); got != tt.want {
+				t.Errorf("%q matching %q (dir %v) = %v, want %v", tt.patterns, tt.rel, tt.isDir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIgnoreRuleBase(t *testing.T) {
+	l := parseIgnore("pkg", "*.gen.go\n/local.txt")
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{"pkg/a.gen.go", true},
+		{"pkg/sub/a.gen.go", true},
+		{"a.gen.go", false},
+		{"other/pkg/a.gen.go", false},
+		{"pkg/local.txt", true},
+		{"pkg/sub/local.txt", false},
+		{"local.txt", false},
+	}
+	for _, tt := range tests {
+		if got := l.match(tt.rel, false); got != tt.want {
+			t.Errorf("rules in pkg matching %q = %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestIgnoreDirectoryPrecedence(t *testing.T) {
+	root := t.TempDir()
+	for name, body := range map[string]string{
+		".gitignore":          "*.txt\nsecret/\n",
+		".ignore":             "!readme.txt\n",
+		"a/.gitignore":        "!*.txt\n*.md\n",
+		"a/b/.gitignore":      "notes.txt\n",
+		"a/b/.ignore":         "!notes.txt\n",
+		"vendor/.gitignore":   "",
+		"readme.txt":          "",
+		"other.txt":           "",
+		"a/x.txt":             "",
+		"a/x.md":              "",
+		"a/b/notes.txt":       "",
+		"a/b/y.md":            "",
+		"a/secret/key.go":     "",
+		"vendor/lib/lib.go":   "",
+		"node_modules/m/m.js": "",
+	} {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		rel  string
+		want string
+	}{
+		{"readme.txt", ""},         // .ignore beats .gitignore in the same directory
+		{"other.txt", SkipIgnored}, // root .gitignore
+		{"a/x.txt", ""},            // a subdirectory re-includes
+		{"a/x.md", SkipIgnored},    // a subdirectory adds its own rule
+		{"a/b/y.md", SkipIgnored},  // which its subdirectories inherit
+		{"a/b/notes.txt", ""},      // the innermost .ignore has the last word
+		{"a/secret/key.go", SkipIgnored},
+		{"vendor/lib/lib.go", SkipIgnored},
+		{"node_modules/m/m.js", SkipIgnored},
+		{".gitignore", SkipHidden},
+	}
+	for _, tt := range tests {
+		if got := skipReason(root, tt.rel); got != tt.want {
+			t.Errorf("skipReason(%q) = %q, want %q", tt.rel, got, tt.want)
+		}
+	}
+
+	// walk must agree with skipReason, listing an ignored directory once.
+	got := map[string]string{}
+	walk(root, func(rel string, _ os.DirEntry, skip string) error {
+		got[rel] = skip
+		return nil
+	})
+	for rel, want := range map[string]string{
+		"readme.txt": "", "a/b/notes.txt": "", "a/x.md": SkipIgnored,
+		"a/secret/": SkipIgnored, "vendor/": SkipIgnored, "node_modules/": SkipIgnored,
+	} {
+		if skip, ok := got[rel]; !ok || skip != want {
+			t.Errorf("walk visited %q: %v with skip %q, want %q", rel, ok, skip, want)
+		}
+	}
+	if _, ok := got["a/secret/key.go"]; ok {
+		t.Error("walk entered an ignored directory")
+	}
+}
@@ -0,0 +1,422 @@
+package context
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+)
+
+// How a context file's content is sent.
+const (
+	ModeAuto    = ""        // in full, or outlined when over contextMaxPerFile
+	ModeFull    = "full"    // in full; skipped when over contextMaxPerFile
+	ModeOutline = "outline" // always outlined
+)
+
+// contextMaxOutlineSource is the largest file that is read to be outlined.
+const contextMaxOutlineSource = 1024 * 1024
+
+// Outline summarizes source code as its structure: package and imports,
+// type declarations and function signatures with their doc comments. Only
+// functions named in refs (lower-cased) keep their bodies. Go is parsed;
+// other languages are outlined from their chroma tokens. ok is false when
+// the language is not recognized.
+func Outline(name string, src []byte, refs map[string]bool) (string, bool) {
+	if strings.EqualFold(filepath.Ext(name), ".go") {
+		if text, ok := goOutline(src, refs); ok {
+			return text, true
+		}
+	}
+	lexer := lexers.Match(name)
+	if lexer == nil {
+		return "", false
+	}
+	lines, err := sourceLines(chroma.Coalesce(lexer), string(src))
+	if err != nil {
+		return "", false
+	}
+	return outlineLines(lines, indentLanguages[lexer.Config().Name], refs), true
+}
+
+// --- Go ---
+
+type span struct{ pos, end token.Pos }
+
+// goOutline drops the bodies of unreferenced functions and multi-line
+// variable values, and the comments inside them.
+func goOutline(src []byte, refs map[string]bool) (string, bool) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return "", false
+	}
+	var elided []span
+	for _, decl := range f.Decls {
+		elided = append(elided, elideDecl(fset, decl, refs)...)
+	}
+	var comments []*ast.CommentGroup
+	for _, c := range f.Comments {
+		if !inSpans(c.Pos(), elided) {
+			comments = append(comments, c)
+		}
+	}
+	f.Comments = comments
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, f); err != nil {
+		return "", false
+	}
+	return buf.String(), true
+}
+
+func elideDecl(fset *token.FileSet, decl ast.Decl, refs map[string]bool) []span {
+	if d, ok := decl.(*ast.FuncDecl); ok {
+		return elideBody(d, refs)
+	}
+	if d, ok := decl.(*ast.GenDecl); ok {
+		return elideSpecs(fset, d)
+	}
+	return nil
+}
+
+// elideBody drops the body of a function not in refs.
+func elideBody(d *ast.FuncDecl, refs map[string]bool) []span {
+	if d.Body == nil || refs[strings.ToLower(d.Name.Name)] {
+		return nil
+	}
+	s := span{d.Body.Pos(), d.Body.End()}
+	d.Body = nil
+	return []span{s}
+}
+
+func elideSpecs(fset *token.FileSet, d *ast.GenDecl) []span {
+	var spans []span
+	for _, spec := range d.Specs {
+		if vs, ok := spec.(*ast.ValueSpec); ok {
+			spans = append(spans, elideValues(fset, vs)...)
+		}
+	}
+	return spans
+}
+
+// elideValues replaces values spanning several lines, such as tables and
+// function literals, with an ellipsis.
+func elideValues(fset *token.FileSet, vs *ast.ValueSpec) []span {
+	var spans []span
+	for i, v := range vs.Values {
+		if fset.Position(v.End()).Line > fset.Position(v.Pos()).Line {
+			spans = append(spans, span{v.Pos(), v.End()})
+			vs.Values[i] = ast.NewIdent("…")
+		}
+	}
+	return spans
+}
+
+func inSpans(pos token.Pos, spans []span) bool {
+	for _, s := range spans {
+		if pos >= s.pos && pos < s.end {
+			return true
+		}
+	}
+	return false
+}
+
+// --- Other languages ---
+
+// indentLanguages delimit blocks by indentation rather than braces.
+var indentLanguages = map[string]bool{"Python": true, "Python 2": true, "Cython": true, "Nim": true, "CoffeeScript": true, "GDScript": true, "Starlark": true}
+
+// declWords are keywords that start a declaration worth keeping.
+var declWords = map[string]bool{
+	"import": true, "from": true, "use": true, "using": true, "package": true, "namespace": true,
+	"module": true, "class": true, "interface": true, "struct": true, "enum": true, "trait": true,
+	"impl": true, "type": true, "typedef": true, "def": true, "fn": true, "func": true,
+	"function": true, "fun": true, "sub": true, "object": true, "protocol": true, "extension": true,
+}
+
+// fnWords introduce a function whose name is the next name token.
+var fnWords = map[string]bool{"def": true, "fn": true, "func": true, "function": true, "fun": true, "sub": true}
+
+// memberStart matches a line that starts like a method: modifiers, then a
+// name and its parameters. Lexers such as JavaScript's mark neither the
+// name nor a keyword, so inside a kept class this is all there is to go on.
+var memberStart = regexp.MustCompile(`^((?:[\w$]+\s+)*)[*#]?([A-Za-z_$][\w$]*)\s*\(`)
+
+// statementWords start a statement that memberStart would take for a
+// method.
+var statementWords = map[string]bool{
+	"if": true, "else": true, "for": true, "while": true, "switch": true, "catch": true, "do": true,
+	"return": true, "new": true, "await": true, "throw": true, "yield": true, "typeof": true, "delete": true, "case": true,
+}
+
+// maxOutlineDepth keeps top-level declarations and members of top-level
+// classes or namespaces: a brace depth, or an indentation in columns.
+const (
+	maxOutlineDepth  = 2
+	maxOutlineIndent = 8
+)
+
+// srcLine is one source line as the outliner sees it.
+type srcLine struct {
+	text     string
+	indent   int // leading columns, tabs counted as 4
+	depth    int // brace depth at the start of the line
+	endDepth int
+	decl     bool   // declares something worth keeping
+	fn       string // function declared on the line, lower-cased
+	method   string // what memberStart names, lower-cased; see outliner.member
+	doc      bool   // only comments or decorators
+	blank    bool
+}
+
+// lineBuilder accumulates the tokens of one line.
+type lineBuilder struct {
+	text   strings.Builder
+	depth  int
+	decl   bool
+	fn     string
+	fnNext bool
+	doc    bool
+	any    bool
+}
+
+func (b *lineBuilder) add(tt chroma.TokenType, s string) int {
+	b.text.WriteString(s)
+	if strings.TrimSpace(s) == "" {
+		return 0
+	}
+	isDoc := (tt.InCategory(chroma.Comment) && !tt.InSubCategory(chroma.CommentPreproc)) || tt == chroma.NameDecorator
+	b.doc = isDoc && (b.doc || !b.any)
+	b.any = true
+	b.mark(tt, s)
+	// Braces count in any token but strings and comments: some lexers,
+	// TypeScript's among them, fold them into a name.
+	if tt.InSubCategory(chroma.LiteralString) || tt.InCategory(chroma.Comment) {
+		return 0
+	}
+	return strings.Count(s, "{") - strings.Count(s, "}")
+}
+
+// mark notes whether the token makes the line a declaration and what
+// function it names.
+func (b *lineBuilder) mark(tt chroma.TokenType, s string) {
+	if tt.InSubCategory(chroma.CommentPreproc) || tt == chroma.NameClass || tt == chroma.KeywordNamespace {
+		b.decl = true
+		return
+	}
+	if tt == chroma.NameFunction {
+		b.decl = true
+		b.setFn(s)
+		return
+	}
+	if tt.InCategory(chroma.Keyword) && declWords[s] {
+		b.decl = true
+		b.fnNext = b.fnNext || fnWords[s]
+		return
+	}
+	if tt.InCategory(chroma.Name) && b.fnNext {
+		b.setFn(s)
+	}
+}
+
+func (b *lineBuilder) setFn(name string) {
+	if b.fn == "" {
+		b.fn = strings.ToLower(name)
+	}
+	b.fnNext = false
+}
+
+func (b *lineBuilder) line(endDepth int) srcLine {
+	text := b.text.String()
+	trimmed := strings.TrimLeft(text, " \t")
+	indent := len(strings.ReplaceAll(text[:len(text)-len(trimmed)], "\t", "    "))
+	return srcLine{text: text, indent: indent, depth: b.depth, endDepth: endDepth, decl: b.decl, fn: b.fn, method: methodName(trimmed), doc: b.doc, blank: !b.any}
+}
+
+func methodName(line string) string {
+	m := memberStart.FindStringSubmatch(line)
+	if m == nil || statementWords[m[2]] {
+		return ""
+	}
+	for _, w := range strings.Fields(m[1]) {
+		if statementWords[w] {
+			return ""
+		}
+	}
+	return strings.ToLower(m[2])
+}
+
+func sourceLines(lexer chroma.Lexer, src string) ([]srcLine, error) {
+	it, err := lexer.Tokenise(nil, src)
+	if err != nil {
+		return nil, err
+	}
+	var lines []srcLine
+	b, depth := &lineBuilder{}, 0
+	for tok := it(); tok != chroma.EOF; tok = it() {
+		for i, part := range strings.Split(tok.Value, "\n") {
+			if i > 0 {
+				lines = append(lines, b.line(depth))
+				b = &lineBuilder{depth: depth}
+			}
+			depth += b.add(tok.Type, part)
+		}
+	}
+	return append(lines, b.line(depth)), nil
+}
+
+// outliner writes the kept lines of one file.
+type outliner struct {
+	lines  []srcLine
+	indent bool // blocks by indentation
+	refs   map[string]bool
+	out    []string
+	doc    []string // comments waiting for the declaration they document
+	open   []int    // depths of kept blocks whose closing line is due
+}
+
+func outlineLines(lines []srcLine, indent bool, refs map[string]bool) string {
+	o := &outliner{lines: lines, indent: indent, refs: refs}
+	for i := 0; i < len(lines); i++ {
+		i = o.line(i)
+	}
+	return strings.Join(o.out, "\n") + "\n"
+}
+
+// line handles line i and returns the last line it consumed.
+func (o *outliner) line(i int) int {
+	l := o.lines[i]
+	if o.member(l) {
+		l.decl, l.fn = true, l.method
+		o.lines[i] = l
+	}
+	if l.blank {
+		o.doc = nil
+		return i
+	}
+	if l.doc {
+		o.doc = append(o.doc, l.text)
+		return i
+	}
+	if l.decl && o.shallow(l) {
+		o.out = append(o.out, o.doc...)
+		o.doc = nil
+		return o.declaration(i)
+	}
+	o.doc = nil
+	o.close(l)
+	return i
+}
+
+// member reports whether l looks like a method directly inside a kept
+// block that its lexer did not mark as a declaration.
+func (o *outliner) member(l srcLine) bool {
+	n := len(o.open)
+	return !l.decl && l.method != "" && n > 0 && l.depth == o.open[n-1]+1
+}
+
+func (o *outliner) shallow(l srcLine) bool {
+	if o.indent {
+		return l.indent <= maxOutlineIndent
+	}
+	return l.depth <= maxOutlineDepth
+}
+
+// declaration keeps line i. A function's body is elided unless the
+// function is referenced; any other block stays open for its members.
+func (o *outliner) declaration(i int) int {
+	l := o.lines[i]
+	end := o.bodyEnd(i)
+	if l.fn == "" || end == i+1 {
+		o.out = append(o.out, l.text)
+		if !o.indent && l.endDepth > l.depth {
+			o.open = append(o.open, l.depth)
+		}
+		return i
+	}
+	o.out = append(o.out, o.function(i, end)...)
+	return end - 1
+}
+
+// function returns the lines kept for the function declared on line i,
+// whose body ends before end: all of them when it is referenced, else the
+// signature and an ellipsis.
+func (o *outliner) function(i, end int) []string {
+	l := o.lines[i]
+	if o.refs[l.fn] {
+		var out []string
+		for _, b := range o.lines[i:end] {
+			out = append(out, b.text)
+		}
+		return out
+	}
+	if o.indent {
+		return []string{l.text, strings.Repeat(" ", o.bodyIndent(i, end)) + "..."}
+	}
+	if l.endDepth > l.depth {
+		return []string{strings.TrimRight(l.text, " \t") + " … }"}
+	}
+	return []string{l.text, strings.Repeat(" ", l.indent) + "{ … }"}
+}
+
+// bodyEnd returns the index after the block opened by line i, or i+1 when
+// it opens none. An opening brace alone on the next line counts.
+func (o *outliner) bodyEnd(i int) int {
+	l := o.lines[i]
+	if o.indent {
+		j := i + 1
+		for j < len(o.lines) && (o.lines[j].blank || o.lines[j].indent > l.indent) {
+			j++
+		}
+		for j > i+1 && o.lines[j-1].blank {
+			j--
+		}
+		return j
+	}
+	open := i
+	if l.endDepth <= l.depth && i+1 < len(o.lines) && strings.HasPrefix(strings.TrimSpace(o.lines[i+1].text), "{") {
+		open = i + 1
+	}
+	if o.lines[open].endDepth <= l.depth {
+		return i + 1
+	}
+	for j := open + 1; j < len(o.lines); j++ {
+		if o.lines[j].endDepth <= l.depth {
+			return j + 1
+		}
+	}
+	return len(o.lines)
+}
+
+// bodyIndent returns the indentation of the first non-blank line of the
+// body in lines (i, end).
+func (o *outliner) bodyIndent(i, end int) int {
+	for _, b := range o.lines[i+1 : end] {
+		if !b.blank {
+			return b.indent
+		}
+	}
+	return o.lines[i].indent + 4
+}
+
+// close writes a line that closes a kept block.
+func (o *outliner) close(l srcLine) {
+	n := len(o.open)
+	if n == 0 || l.endDepth > o.open[n-1] {
+		return
+	}
+	for n > 0 && l.endDepth <= o.open[n-1] {
+		n--
+	}
+	o.open = o.open[:n]
+	if strings.HasPrefix(strings.TrimSpace(l.text), "}") {
+		o.out = append(o.out, l.text)
+	}
+}
@@ -0,0 +1,241 @@
+package context
+
+import "testing"
+
+const goSource = `// Package shop prices baskets.
+package shop
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rate is the tax rate.
+const Rate = 0.2
+
+var prices = map[string]int{
+	"apple": 3, // per kilo
+	"pear":  4,
+}
+
+var name = "shop"
+
+// Basket holds items.
+type Basket struct {
+	Items []string // in the order added
+}
+
+// Total sums the basket.
+func (b *Basket) Total() int {
+	// look up each item
+	sum := 0
+	for _, it := range b.Items {
+		sum += prices[it]
+	}
+	return sum
+}
+
+// Describe formats the basket.
+func Describe(b *Basket) string {
+	return fmt.Sprintf("%s: %s", name, strings.Join(b.Items, ", "))
+}
+
+type Pricer interface {
+	Total() int
+}
+`
+
+const pythonSource = `"""Shop module."""
+import json
+from typing import List
+
+RATE = 0.2
+
+
+# A basket of items.
+@dataclass
+class Basket:
+    items: List[str]
+
+    def total(self) -> int:
+        # look up each item
+        return sum(PRICES[i] for i in self.items)
+
+    def describe(self):
+        return json.dumps(self.items)
+
+
+def load(path):
+    with open(path) as f:
+        return Basket(json.load(f))
+`
+
+const jsSource = `import { readFile } from "fs";
+
+// A basket of items.
+export class Basket {
+  constructor(items) {
+    this.items = items;
+  }
+
+  total() {
+    let sum = 0;
+    for (const it of this.items) {
+      sum += PRICES[it];
+    }
+    return sum;
+  }
+}
+
+/** Loads a basket. */
+function load(path)
+{
+  return new Basket(JSON.parse(readFile(path)));
+}
+`
+
+func TestOutline(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		src    string
+		refs   []string
+		want   string
+		wantOK bool
+	}{
+		{
+			name: "go, bodies and multi-line values elided",
+			file: "shop.go", src: goSource, refs: []string{"describe"}, wantOK: true,
+			want: `// Package shop prices baskets.
+package shop
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rate is the tax rate.
+const Rate = 0.2
+
+var prices = …
+
+var name = "shop"
+
+// Basket holds items.
+type Basket struct {
+	Items []string	// in the order added
+}
+
+// Total sums the basket.
+func (b *Basket) Total() int
+
+// Describe formats the basket.
+func Describe(b *Basket) string {
+	return fmt.Sprintf("%s: %s", name, strings.Join(b.Items, ", "))
+}
+
+type Pricer interface {
+	Total() int
+}
+`,
+		},
+		{
+			name: "go that does not parse falls back to tokens",
+			file: "broken.go", src: "package shop\n\nfunc Broken( {\n\treturn 1\n}\n", wantOK: true,
+			want: "package shop\nfunc Broken( { … }\n",
+		},
+		{
+			name: "python, blocks by indentation",
+			file: "shop.py", src: pythonSource, refs: []string{"describe"}, wantOK: true,
+			want: `import json
+from typing import List
+# A basket of items.
+@dataclass
+class Basket:
+    def total(self) -> int:
+        ...
+    def describe(self):
+        return json.dumps(self.items)
+def load(path):
+    ...
+`,
+		},
+		{
+			name: "javascript, methods the lexer does not mark",
+			file: "shop.js", src: jsSource, refs: []string{"total"}, wantOK: true,
+			want: `import { readFile } from "fs";
+// A basket of items.
+export class Basket {
+  constructor(items) { … }
+  total() {
+    let sum = 0;
+    for (const it of this.items) {
+      sum += PRICES[it];
+    }
+    return sum;
+  }
+}
+/** Loads a basket. */
+function load(path)
+{ … }
+`,
+		},
+		{
+			name: "typescript folds braces into names",
+			file: "shop.ts", src: jsSource, refs: []string{"total"}, wantOK: true,
+			want: `import { readFile } from "fs";
+// A basket of items.
+export class Basket {
+  constructor(items) { … }
+  total() {
+    let sum = 0;
+    for (const it of this.items) {
+      sum += PRICES[it];
+    }
+    return sum;
+  }
+}
+/** Loads a basket. */
+function load(path)
+{ … }
+`,
+		},
+		{
+			name: "unknown language",
+			file: "notes.unknownext", src: "anything",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refs := map[string]bool{}
+			for _, r := range tt.refs {
+				refs[r] = true
+			}
+			got, ok := Outline(tt.file, []byte(tt.src), refs)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("Outline = %v\n%s\nwant %v\n%s", ok, got, tt.wantOK, tt.want)
+			}
+		})
+	}
+}
+
+func TestMethodName(t *testing.T) {
+	tests := map[string]string{
+		"total() {":               "total",
+		"static async Load(p) {":  "load",
+		"get size() { return 1 }": "size",
+		"*items() {":              "items",
+		"#secret(x) {":            "secret",
+		"if (x) {":                "",
+		"} else if (x) {":         "",
+		"return compute(x);":      "",
+		"await save(x);":          "",
+		"this.items = items;":     "",
+		"handle = (event) => {":   "",
+	}
+	for line, want := range tests {
+		if got := methodName(line); got != want {
+			t.Errorf("methodName(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
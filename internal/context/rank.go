@@ -0,0 +1,193 @@
+package context
+
+import (
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"second-nature/internal/model"
+)
+
+// Signals are the current inputs context files are ranked against.
+type Signals struct {
+	Transcript string   // selected transcript or typed follow-up
+	ScreenText string   // text read off the selected screenshots, if any
+	Answers    []string // recent answers, newest first
+}
+
+// recentAnswers is how many answers NewSignals looks back through.
+const recentAnswers = 3
+
+// NewSignals collects the signals for a turn from its text, the screen
+// text and the newest answers in history.
+func NewSignals(text, screenText string, history []model.Message) Signals {
+	sig := Signals{Transcript: text, ScreenText: screenText}
+	for i := len(history) - 1; i >= 0 && len(sig.Answers) < recentAnswers; i-- {
+		if history[i].Role == model.RoleAssistant {
+			sig.Answers = append(sig.Answers, history[i].Text())
+		}
+	}
+	return sig
+}
+
+// Score weights. A file named in the inputs outranks any number of shared
+// identifiers; recency breaks ties between otherwise unrelated files.
+const (
+	weightNamed    = 4.0  // file name appears in the transcript or on screen
+	weightAnswered = 3.0  // path or file name appears in a recent answer
+	weightTerm     = 0.25 // per distinct input identifier the file contains
+	maxTermScore   = 3.0
+	weightRecent   = 2.0 // modified just now; halves every hour
+)
+
+var identifier = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]{2,}`)
+
+// stopWords are too common in speech or code to say anything about a file.
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "this": true, "that": true, "you": true,
+	"are": true, "was": true, "can": true, "not": true, "but": true, "have": true, "from": true,
+	"what": true, "how": true, "why": true, "use": true, "get": true, "set": true, "new": true,
+	"func": true, "function": true, "return": true, "var": true, "const": true, "let": true,
+	"int": true, "string": true, "true": true, "false": true, "nil": true, "null": true,
+	"def": true, "class": true, "import": true, "package": true, "self": true, "type": true,
+}
+
+// ranker scores files against one set of signals.
+type ranker struct {
+	spoken      string          // transcript and screen text, lower-cased
+	spokenTerms map[string]bool // identifiers in spoken
+	answers     string          // recent answers, lower-cased
+	refs        map[string]bool // identifiers in the transcript and the last answer; see Outline
+	now         time.Time
+	scored      *int64 // bytes termScore has read; see contextMaxScored
+}
+
+func newRanker(sig Signals) ranker {
+	spoken := strings.ToLower(sig.Transcript + "\n" + sig.ScreenText)
+	refs := terms(sig.Transcript)
+	if len(sig.Answers) > 0 {
+		refs = terms(sig.Transcript + "\n" + sig.Answers[0])
+	}
+	return ranker{
+		spoken:      spoken,
+		spokenTerms: terms(spoken),
+		answers:     strings.ToLower(strings.Join(sig.Answers, "\n")),
+		refs:        refs,
+		now:         time.Now(),
+		scored:      new(int64),
+	}
+}
+
+func terms(text string) map[string]bool {
+	set := map[string]bool{}
+	for _, w := range identifier.FindAllString(strings.ToLower(text), -1) {
+		if !stopWords[w] {
+			set[w] = true
+		}
+	}
+	return set
+}
+
+// score rates the file at p, rel to the root, of size and modified at
+// mod. Scores are rounded to one decimal for display.
+func (r ranker) score(p, rel string, size int64, mod time.Time) float64 {
+	s := r.named(rel) + r.termScore(p, size)
+	if age := r.now.Sub(mod).Hours(); age >= 0 {
+		s += weightRecent * math.Pow(0.5, age)
+	}
+	return math.Round(s*10) / 10
+}
+
+// named rates how directly the inputs point at the file rel: by name in
+// the transcript or on screen, or by path or name in a recent answer.
+func (r ranker) named(rel string) float64 {
+	rel = strings.ToLower(rel)
+	base := path.Base(rel)
+	stem := strings.TrimSuffix(base, path.Ext(base))
+	s := 0.0
+	if strings.Contains(r.spoken, base) || (len(stem) >= 4 && r.spokenTerms[stem]) {
+		s += weightNamed
+	}
+	if strings.Contains(r.answers, rel) || strings.Contains(r.answers, base) {
+		s += weightAnswered
+	}
+	return s
+}
+
+// termScore counts the input identifiers that occur in the file, while
+// the scan has read less than contextMaxScored.
+func (r ranker) termScore(p string, size int64) float64 {
+	if len(r.spokenTerms) == 0 || *r.scored+size > contextMaxScored {
+		return 0
+	}
+	*r.scored += size
+	data, err := CtxWatcher.read(p)
+	if err != nil {
+		return 0
+	}
+	hits := 0
+	for w := range terms(string(data)) {
+		if r.spokenTerms[w] {
+			hits++
+		}
+	}
+	return math.Min(float64(hits)*weightTerm, maxTermScore)
+}
+
+// budget tracks what is left of one root's share of the context limits
+// while packing.
+type budget struct {
+	maxFiles int
+	maxTotal int64
+	total    int64
+	count    int
+}
+
+// take reserves room for a file of size, or returns SkipOverBudget.
+func (b *budget) take(size int64) string {
+	if b.count >= b.maxFiles || b.total+size > b.maxTotal {
+		return SkipOverBudget
+	}
+	b.total += size
+	b.count++
+	return ""
+}
+
+// pack fills b with the sendable files and marks the rest over budget.
+// Files named in the inputs go first, then those in kept, the files last
+// sent from the root, then the rest; each group in order of score, ties in
+// walk order. Keeping what was sent means identifier hits and recency,
+// which shift every turn, only decide what fills room that is left, so
+// the selection changes when a file is named or the files themselves
+// change. Files keep their walk order in the slice, so the same selection
+// always reads the same and stays cacheable.
+func pack(files []File, b budget, kept map[string]bool) {
+	order := make([]int, 0, len(files))
+	for i, f := range files {
+		if f.Skip == "" && !f.Excluded {
+			order = append(order, i)
+		}
+	}
+	group := func(f File) int {
+		if f.named {
+			return 0
+		}
+		if kept[f.Rel] {
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(order, func(x, y int) bool {
+		fx, fy := files[order[x]], files[order[y]]
+		if gx, gy := group(fx), group(fy); gx != gy {
+			return gx < gy
+		}
+		return fx.Score > fy.Score
+	})
+	for _, i := range order {
+		files[i].Skip = b.take(files[i].Size)
+	}
+}
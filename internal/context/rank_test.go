@@ -0,0 +1,200 @@
+package context
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRankerScore(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-100 * time.Hour)
+	tests := []struct {
+		name    string
+		sig     Signals
+		rel     string
+		content string
+		mod     time.Time
+		want    float64
+	}{
+		{"named in transcript", Signals{Transcript: "look at parser.go"}, "parser.go", "x", old, weightNamed},
+		{"named on screen", Signals{ScreenText: "PARSER.GO - editor"}, "src/parser.go", "x", old, weightNamed},
+		{"stem as a word", Signals{Transcript: "the parser is slow"}, "src/parser.go", "x", old, weightNamed},
+		{"short stem is not a name", Signals{Transcript: "use the io package"}, "io.go", "x", old, 0},
+		{"path in an answer", Signals{Answers: []string{"Change src/util.go like so"}}, "src/util.go", "x", old, weightAnswered},
+		{"named and answered", Signals{Transcript: "util.go", Answers: []string{"in util.go"}}, "util.go", "x", old, weightNamed + weightAnswered},
+		{"shared identifiers", Signals{Transcript: "tokenize lexer emit scanner"}, "a.go", "func tokenize() { lexer.emit(scanner) }", old, 4 * weightTerm},
+		{"stop words do not count", Signals{Transcript: "return the string"}, "a.go", "return string", old, 0},
+		{"identifiers capped", Signals{Transcript: manyTerms(20)}, "a.go", manyTerms(20), old, maxTermScore},
+		{"modified now", Signals{}, "a.go", "x", time.Now(), weightRecent},
+		{"modified an hour ago", Signals{}, "a.go", "x", time.Now().Add(-time.Hour), weightRecent / 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(dir, "file")
+			if err := os.WriteFile(p, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if got := newRanker(tt.sig).score(p, tt.rel, int64(len(tt.content)), tt.mod); got != tt.want {
+				t.Errorf("score = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func manyTerms(n int) string {
+	var words []string
+	for i := range n {
+		words = append(words, fmt.Sprintf("word%d", i))
+	}
+	return strings.Join(words, " ")
+}
+
+func TestRankerStopsReadingAtCap(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "a.go")
+	if err := os.WriteFile(p, []byte("tokenize"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r := newRanker(Signals{Transcript: "tokenize"})
+	if got := r.termScore(p, 8); got != weightTerm {
+		t.Fatalf("termScore = %v, want %v", got, weightTerm)
+	}
+	*r.scored = contextMaxScored - 4
+	if got := r.termScore(p, 8); got != 0 {
+		t.Errorf("termScore past contextMaxScored = %v, want 0", got)
+	}
+}
+
+func TestPack(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []File
+		b     budget
+		kept  []string
+		want  []string // sent, in walk order
+	}{
+		{
+			name:  "everything fits",
+			files: []File{{Rel: "a", Size: 10}, {Rel: "b", Size: 10, Score: 5}},
+			b:     budget{maxFiles: 5, maxTotal: 100},
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "file count by score",
+			files: []File{{Rel: "a", Size: 1, Score: 1}, {Rel: "b", Size: 1, Score: 3}, {Rel: "c", Size: 1, Score: 2}},
+			b:     budget{maxFiles: 2, maxTotal: 100},
+			want:  []string{"b", "c"},
+		},
+		{
+			name:  "ties in walk order",
+			files: []File{{Rel: "a", Size: 1}, {Rel: "b", Size: 1}, {Rel: "c", Size: 1}},
+			b:     budget{maxFiles: 2, maxTotal: 100},
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "smaller files fill the room a large one leaves",
+			files: []File{{Rel: "a", Size: 60, Score: 3}, {Rel: "b", Size: 50, Score: 2}, {Rel: "c", Size: 40, Score: 1}},
+			b:     budget{maxFiles: 5, maxTotal: 100},
+			want:  []string{"a", "c"},
+		},
+		{
+			name:  "skipped and excluded files take no room",
+			files: []File{{Rel: "a", Size: 90, Skip: SkipBinary}, {Rel: "b", Size: 90, Excluded: true}, {Rel: "c", Size: 90}},
+			b:     budget{maxFiles: 1, maxTotal: 100},
+			want:  []string{"c"},
+		},
+		{
+			name:  "last selection outranks higher scores",
+			files: []File{{Rel: "a", Size: 1, Score: 2}, {Rel: "b", Size: 1, Score: 3.5}, {Rel: "c", Size: 1, Score: 2.1}},
+			b:     budget{maxFiles: 2, maxTotal: 100},
+			kept:  []string{"a", "c"},
+			want:  []string{"a", "c"},
+		},
+		{
+			name:  "a named file displaces the lowest kept one",
+			files: []File{{Rel: "a", Size: 1, Score: 2}, {Rel: "b", Size: 1, Score: 4, named: true}, {Rel: "c", Size: 1, Score: 2.1}},
+			b:     budget{maxFiles: 2, maxTotal: 100},
+			kept:  []string{"a", "c"},
+			want:  []string{"b", "c"},
+		},
+		{
+			name:  "room left after kept files goes by score",
+			files: []File{{Rel: "a", Size: 1, Score: 1}, {Rel: "b", Size: 1, Score: 2}, {Rel: "c", Size: 1, Score: 3}},
+			b:     budget{maxFiles: 2, maxTotal: 100},
+			kept:  []string{"a"},
+			want:  []string{"a", "c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept := map[string]bool{}
+			for _, rel := range tt.kept {
+				kept[rel] = true
+			}
+			pack(tt.files, tt.b, kept)
+			var got []string
+			for _, f := range tt.files {
+				if f.Skip == "" && !f.Excluded {
+					got = append(got, f.Rel)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("sent %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestReadSnapshotStable checks that a root over its budget sends the same
+// files turn after turn until one is named.
+func TestReadSnapshotStable(t *testing.T) {
+	saved := CtxSent
+	t.Cleanup(func() { CtxSent = saved })
+	CtxSent = NewSentSnapshots()
+
+	root := t.TempDir()
+	for i := range contextMaxFiles + 10 {
+		name := filepath.Join(root, fmt.Sprintf("f%02d.txt", i))
+		if err := os.WriteFile(name, []byte(fmt.Sprintf("alpha%02d", i)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	terms := func(from, to int) string {
+		var words []string
+		for i := from; i < to; i++ {
+			words = append(words, fmt.Sprintf("alpha%02d", i))
+		}
+		return strings.Join(words, " ")
+	}
+
+	first := ReadSnapshot([]string{root}, Signals{Transcript: terms(50, 60)})
+	if len(first.Rels) != contextMaxFiles || !slices.Contains(first.Rels, "f59.txt") {
+		t.Fatalf("first turn sent %d files %v, want %d including the matching f50-f59", len(first.Rels), first.Rels, contextMaxFiles)
+	}
+	CtxSent.Remember("first", first)
+
+	next := ReadSnapshot([]string{root}, Signals{Transcript: terms(0, 10), Answers: []string{"unrelated"}})
+	if next.Text() != first.Text() {
+		t.Errorf("new identifiers changed the selection:\n%v\nthen\n%v", first.Rels, next.Rels)
+	}
+
+	left := firstUnsent(first.Rels, contextMaxFiles+10)
+	named := ReadSnapshot([]string{root}, Signals{Transcript: "open " + left})
+	if !slices.Contains(named.Rels, left) || len(named.Rels) != contextMaxFiles {
+		t.Errorf("naming %s sent %d files %v", left, len(named.Rels), named.Rels)
+	}
+}
+
+// firstUnsent returns the first of the n test files not in sent.
+func firstUnsent(sent []string, n int) string {
+	for i := range n {
+		if name := fmt.Sprintf("f%02d.txt", i); !slices.Contains(sent, name) {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,123 @@
+package context
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// root is one context root as the model sees it. With several roots, the
+// files under each are named after its label: "label/rel" for a directory,
+// the label alone for a single file.
+type root struct {
+	path  string
+	label string // "" when it is the only root
+	file  bool
+}
+
+// namedRoots labels each path by its base name, numbering repeats.
+func namedRoots(paths []string) []root {
+	roots := make([]root, len(paths))
+	seen := map[string]int{}
+	for i, p := range paths {
+		roots[i] = root{path: p}
+		if len(paths) > 1 {
+			roots[i].label = label(p, seen)
+		}
+	}
+	return roots
+}
+
+func label(p string, seen map[string]int) string {
+	base := filepath.Base(p)
+	seen[base]++
+	if n := seen[base]; n > 1 {
+		return fmt.Sprintf("%s~%d", base, n)
+	}
+	return base
+}
+
+// stat fills in whether the root is a single file.
+func (r *root) stat() (os.FileInfo, error) {
+	info, err := os.Stat(r.path)
+	if err == nil {
+		r.file = !info.IsDir()
+	}
+	return info, err
+}
+
+// name is how the file rel under the root is named to the model.
+func (r root) name(rel string) string {
+	if r.label == "" {
+		return rel
+	}
+	if r.file {
+		return r.label
+	}
+	return r.label + "/" + rel
+}
+
+// resolve finds the root a name given by the model is under, and the
+// name relative to it.
+func resolve(paths []string, name string) (string, string, error) {
+	if len(paths) == 1 {
+		return paths[0], name, nil
+	}
+	clean := strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+name)), "/")
+	head, rest, _ := strings.Cut(clean, "/")
+	for _, r := range namedRoots(paths) {
+		if r.label == clean {
+			return r.path, filepath.Base(r.path), nil
+		}
+		if r.label == head && rest != "" {
+			return r.path, rest, nil
+		}
+	}
+	return "", "", fmt.Errorf("%s: not in context", name)
+}
+
+// share is each of n roots' part of the context budget.
+func share(n int) budget {
+	n = max(n, 1)
+	return budget{maxFiles: max(contextMaxFiles/n, 1), maxTotal: contextMaxTotal / int64(n)}
+}
+
+// Tree is the scan of one context root.
+type Tree struct {
+	Root    string
+	Label   string // the prefix of its files' names, when there are several roots
+	File    bool   // the root is a single file
+	Missing bool   // the path no longer exists
+	Files   []File
+}
+
+// Scan lists everything under the context roots, as the Context tab shows
+// it: each file with its size, whether the user unchecked it, its score
+// against sig, why it will not be sent and how it changed since the last
+// send. Files deleted since are listed with only their change. Each root
+// is packed into its own share of the budget.
+func Scan(paths []string, sig Signals) []Tree {
+	CtxWatcher.Watch(paths...)
+	r := newRanker(sig)
+	var trees []Tree
+	for _, rt := range namedRoots(paths) {
+		trees = append(trees, scanRoot(rt, r, share(len(paths))))
+	}
+	return trees
+}
+
+func scanRoot(rt root, r ranker, b budget) Tree {
+	info, err := rt.stat()
+	t := Tree{Root: rt.path, Label: rt.label, File: rt.file, Missing: err != nil}
+	if err != nil {
+		return t
+	}
+	sel := CtxFileSelection.selection(rt.path)
+	if rt.file {
+		t.Files = markChanges(rt.path, []File{singleFile(rt.path, info, sel, r, &b)})
+		return t
+	}
+	t.Files = markChanges(rt.path, scan(rt.path, sel, r, b))
+	return t
+}
@@ -0,0 +1,164 @@
+package context
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Snapshot is the text of the files in one context block, root by root in
+// walk order, and every file the walks saw at the time. Rels, Files and
+// Outlines use the names the model sees; Seen and Picked are relative to
+// each root.
+type Snapshot struct {
+	Roots    []string
+	Rels     []string
+	Files    map[string]string
+	Outlines map[string]bool            // files sent as their Outline
+	Seen     map[string]map[string]bool // root → rel
+	Picked   map[string]map[string]bool // root → rel of the files in Rels; see pack
+	Time     time.Time
+}
+
+// Text formats the snapshot as the context block sent to the model.
+func (s Snapshot) Text() string {
+	var buf strings.Builder
+	for _, rel := range s.Rels {
+		buf.WriteString("--- File: ")
+		buf.WriteString(rel)
+		if s.Outlines[rel] {
+			buf.WriteString(" (outline)")
+		}
+		buf.WriteString(" ---\n")
+		buf.WriteString(s.Files[rel])
+		buf.WriteString("\n\n")
+	}
+	return buf.String()
+}
+
+func (s *Snapshot) add(rel, content string) {
+	s.Rels = append(s.Rels, rel)
+	s.Files[rel] = content
+}
+
+func (s *Snapshot) addOutline(rel, outline string) {
+	s.add(rel, outline)
+	s.Outlines[rel] = true
+}
+
+func (s *Snapshot) see(root, rel string) {
+	if s.Seen[root] == nil {
+		s.Seen[root] = map[string]bool{}
+	}
+	s.Seen[root][rel] = true
+}
+
+func (s *Snapshot) pick(root, rel string) {
+	if s.Picked[root] == nil {
+		s.Picked[root] = map[string]bool{}
+	}
+	s.Picked[root][rel] = true
+}
+
+func newSnapshot(roots []string) Snapshot {
+	return Snapshot{Roots: roots, Files: map[string]string{}, Outlines: map[string]bool{}, Seen: map[string]map[string]bool{}, Picked: map[string]map[string]bool{}, Time: time.Now()}
+}
+
+// How a file changed since the last snapshot sent from its root.
+const (
+	ChangeAdded    = "added"
+	ChangeModified = "modified"
+	ChangeDeleted  = "deleted"
+)
+
+// sentLimit is how many sent snapshots are kept for diffing.
+const sentLimit = 16
+
+var CtxSent = NewSentSnapshots()
+
+// SentSnapshots remembers the context blocks recently sent, by hash, so a
+// follow-up can send only what changed since and the Context tab can mark
+// changed files.
+type SentSnapshots struct {
+	mu     sync.Mutex
+	byHash map[string]Snapshot
+	order  []string
+	last   map[string]string // root → hash of the newest snapshot sent from it
+}
+
+func NewSentSnapshots() *SentSnapshots {
+	return &SentSnapshots{byHash: make(map[string]Snapshot), last: make(map[string]string)}
+}
+
+// Remember records snap as sent under hash, evicting the least recently
+// sent beyond sentLimit.
+func (s *SentSnapshots) Remember(hash string, snap Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, root := range snap.Roots {
+		s.last[root] = hash
+	}
+	s.order = append(slices.DeleteFunc(s.order, func(h string) bool { return h == hash }), hash)
+	s.byHash[hash] = snap
+	if len(s.order) > sentLimit {
+		delete(s.byHash, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// Recall returns the snapshot sent under hash.
+func (s *SentSnapshots) Recall(hash string) (Snapshot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap, ok := s.byHash[hash]
+	return snap, ok
+}
+
+// Last returns the newest snapshot sent with root among its roots.
+func (s *SentSnapshots) Last(root string) (Snapshot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap, ok := s.byHash[s.last[root]]
+	return snap, ok
+}
+
+// markChanges sets Change on files against the last snapshot sent from
+// root and appends the files deleted since. Nothing is marked before the
+// first send.
+func markChanges(root string, files []File) []File {
+	sent, ok := CtxSent.Last(root)
+	if !ok {
+		return files
+	}
+	now := map[string]bool{}
+	for i, f := range files {
+		now[f.Rel] = true
+		files[i].Change = changeOf(sent.Seen[root], sent.Time, f)
+	}
+	var deleted []string
+	for rel := range sent.Seen[root] {
+		if !now[rel] {
+			deleted = append(deleted, rel)
+		}
+	}
+	sort.Strings(deleted)
+	for _, rel := range deleted {
+		files = append(files, File{Rel: rel, Change: ChangeDeleted})
+	}
+	return files
+}
+
+func changeOf(seen map[string]bool, sent time.Time, f File) string {
+	if strings.HasSuffix(f.Rel, "/") {
+		return ""
+	}
+	if !seen[f.Rel] {
+		return ChangeAdded
+	}
+	if f.Mod.After(sent) {
+		return ChangeModified
+	}
+	return ""
+}
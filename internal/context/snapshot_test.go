@@ -0,0 +1,73 @@
+package context
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSentSnapshots(t *testing.T) {
+	s := NewSentSnapshots()
+	snap := func(roots ...string) Snapshot { return newSnapshot(roots) }
+
+	s.Remember("h0", snap("api"))
+	for i := 1; i < sentLimit; i++ {
+		s.Remember(fmt.Sprint("h", i), snap("client"))
+	}
+	// Sending h0 again makes it the newest, so h1 goes first.
+	s.Remember("h0", snap("api"))
+	s.Remember("h16", snap("client"))
+	if _, ok := s.Recall("h1"); ok {
+		t.Error("h1 kept past sentLimit")
+	}
+	for _, hash := range []string{"h0", "h2", "h16"} {
+		if _, ok := s.Recall(hash); !ok {
+			t.Errorf("%s evicted", hash)
+		}
+	}
+	if len(s.order) != sentLimit || len(s.byHash) != sentLimit {
+		t.Errorf("%d in order, %d by hash, want %d", len(s.order), len(s.byHash), sentLimit)
+	}
+
+	s.Remember("both", snap("api", "client"))
+	for _, root := range []string{"api", "client"} {
+		if got, ok := s.Last(root); !ok || !slices.Equal(got.Roots, []string{"api", "client"}) {
+			t.Errorf("Last(%q) = %v, %v", root, got.Roots, ok)
+		}
+	}
+	if _, ok := s.Last("other"); ok {
+		t.Error("Last of a root never sent")
+	}
+}
+
+func TestMarkChanges(t *testing.T) {
+	saved := CtxSent
+	t.Cleanup(func() { CtxSent = saved })
+	CtxSent = NewSentSnapshots()
+
+	sentAt := time.Now()
+	before, after := sentAt.Add(-time.Minute), sentAt.Add(time.Minute)
+	files := func() []File {
+		return []File{{Rel: "dir/"}, {Rel: "kept.go", Mod: before}, {Rel: "edited.go", Mod: after}, {Rel: "new.go", Mod: before}}
+	}
+	if got := markChanges("root", files()); len(got) != 4 || slices.ContainsFunc(got, func(f File) bool { return f.Change != "" }) {
+		t.Fatalf("changes marked before the first send: %+v", got)
+	}
+
+	snap := newSnapshot([]string{"root"})
+	snap.Time = sentAt
+	for _, rel := range []string{"kept.go", "edited.go", "z.go", "a.go"} {
+		snap.see("root", rel)
+	}
+	CtxSent.Remember("h", snap)
+
+	var got []string
+	for _, f := range markChanges("root", files()) {
+		got = append(got, f.Rel+":"+f.Change)
+	}
+	want := []string{"dir/:", "kept.go:", "edited.go:" + ChangeModified, "new.go:" + ChangeAdded, "a.go:" + ChangeDeleted, "z.go:" + ChangeDeleted}
+	if !slices.Equal(got, want) {
+		t.Errorf("markChanges = %v, want %v", got, want)
+	}
+}
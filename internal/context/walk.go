@@ -0,0 +1,311 @@
+package context
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"second-nature/internal/model"
+)
+
+// Why a file under a context directory is not sent.
+const (
+	SkipIgnored    = "ignored"     // .gitignore, .ignore or a built-in default
+	SkipPattern    = "pattern"     // the directory's include/exclude globs or extensions
+	SkipHidden     = "hidden"      // dot-files, never listed
+	SkipBinary     = "binary"      // NUL bytes near the start
+	SkipTooLarge   = "too large"   // over contextMaxPerFile
+	SkipOverBudget = "over budget" // outranked once the root's share of contextMaxFiles or contextMaxTotal is used
+)
+
+// contextMaxListed caps ListContextFiles, which is not bound by the budget.
+const contextMaxListed = 500
+
+// contextMaxScanned caps the entries one scan of a root walks, and
+// contextMaxScored the bytes a scan of all roots reads to match files
+// against the inputs; files past it are ranked on name and age alone.
+const (
+	contextMaxScanned = 5000
+	contextMaxScored  = 8 << 20
+)
+
+var CtxRules = NewContextRuleSet()
+
+// ContextRuleSet holds the configured rules per context directory.
+type ContextRuleSet struct {
+	mu    sync.Mutex
+	byDir map[string]model.ContextRules // absolute dir, or "default"
+}
+
+func NewContextRuleSet() *ContextRuleSet {
+	return &ContextRuleSet{byDir: make(map[string]model.ContextRules)}
+}
+
+// Load replaces the rules with AppConfig.ContextRules. Keys are directory
+// paths; the "default" entry applies to directories without their own.
+func (c *ContextRuleSet) Load(rules map[string]model.ContextRules) {
+	byDir := make(map[string]model.ContextRules, len(rules))
+	for dir, r := range rules {
+		byDir[ruleKey(dir)] = r
+	}
+	c.mu.Lock()
+	c.byDir = byDir
+	c.mu.Unlock()
+}
+
+// For returns the rules for the context directory root.
+func (c *ContextRuleSet) For(root string) model.ContextRules {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r, ok := c.byDir[ruleKey(root)]; ok {
+		return r
+	}
+	return c.byDir["default"]
+}
+
+func ruleKey(dir string) string {
+	if dir == "default" {
+		return dir
+	}
+	return rootKey(dir)
+}
+
+// rootKey makes relative and absolute forms of a context root match.
+func rootKey(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return dir
+	}
+	return abs
+}
+
+// File is one entry of a context directory scan. An ignored or excluded
+// directory is listed once, with a trailing slash, instead of its files.
+type File struct {
+	Rel      string
+	Size     int64
+	Excluded bool    // unchecked in the Context tab
+	Skip     string  // empty when the file is sent
+	Score    float64 // relevance to the current inputs; see ranker
+	Mod      time.Time
+	Change   string // against the last snapshot sent; see markChanges
+	Mode     string // chosen in the Context tab; see ModeAuto
+	Outline  bool   // sent as its Outline, and Size is the outline's
+	text     string // the outline, when Outline
+	named    bool   // named in the inputs; see pack
+}
+
+// filter applies one directory's ContextRules.
+type filter struct {
+	include, exclude ignoreList
+	exts             map[string]bool
+}
+
+func newFilter(r model.ContextRules) filter {
+	f := filter{
+		include: parseIgnore("", strings.Join(r.Include, "\n")),
+		exclude: parseIgnore("", strings.Join(r.Exclude, "\n")),
+	}
+	if len(r.Extensions) > 0 {
+		f.exts = make(map[string]bool, len(r.Extensions))
+	}
+	for _, ext := range r.Extensions {
+		f.exts["."+strings.TrimPrefix(strings.ToLower(ext), ".")] = true
+	}
+	return f
+}
+
+// skip returns why rel is left out by the ignore files in scope or by the
+// rules, or "".
+func (f filter) skip(ignores ignoreList, rel string, isDir bool) string {
+	if ignores.match(rel, isDir) {
+		return SkipIgnored
+	}
+	if f.exclude.match(rel, isDir) {
+		return SkipPattern
+	}
+	if isDir {
+		return ""
+	}
+	if len(f.include) > 0 && !f.include.match(rel, false) {
+		return SkipPattern
+	}
+	if f.exts != nil && !f.exts[strings.ToLower(path.Ext(rel))] {
+		return SkipPattern
+	}
+	return ""
+}
+
+// walk calls visit for every file under root in walk order, with the reason
+// the ignore files or root's rules leave it out. A directory left out is
+// visited instead of its contents. Hidden entries are skipped silently.
+// visit may return filepath.SkipAll to stop.
+func walk(root string, visit func(rel string, d fs.DirEntry, skip string) error) {
+	f := newFilter(CtxRules.For(root))
+	scopes := map[string]ignoreList{}
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, p)
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			scopes["."] = defaultIgnores.withDir(root, "")
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			return skipEntry(d, nil)
+		}
+		ignores := scopes[path.Dir(rel)]
+		if skip := f.skip(ignores, rel, d.IsDir()); skip != "" {
+			return skipEntry(d, visit(rel+dirSuffix(d), d, skip))
+		}
+		if d.IsDir() {
+			scopes[rel] = ignores.withDir(root, rel)
+			return nil
+		}
+		return visit(rel, d, "")
+	})
+}
+
+// skipEntry keeps WalkDir out of a skipped directory unless visit stopped
+// the walk altogether.
+func skipEntry(d fs.DirEntry, err error) error {
+	if err == nil && d.IsDir() {
+		return filepath.SkipDir
+	}
+	return err
+}
+
+func dirSuffix(d fs.DirEntry) string {
+	if d.IsDir() {
+		return "/"
+	}
+	return ""
+}
+
+// skipReason applies root's ignore files and rules to the single file rel
+// the way walk would reach it.
+func skipReason(root, rel string) string {
+	f := newFilter(CtxRules.For(root))
+	ignores := defaultIgnores.withDir(root, "")
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i, name := range parts {
+		sub := strings.Join(parts[:i+1], "/")
+		isDir := i < len(parts)-1
+		if strings.HasPrefix(name, ".") {
+			return SkipHidden
+		}
+		if skip := f.skip(ignores, sub, isDir); skip != "" {
+			return skip
+		}
+		if isDir {
+			ignores = ignores.withDir(root, sub)
+		}
+	}
+	return ""
+}
+
+// singleFile is the scan of a context root that is one file.
+func singleFile(p string, info os.FileInfo, sel selection, r ranker, b *budget) File {
+	name := filepath.Base(p)
+	f := File{Rel: name, Size: info.Size(), Excluded: sel.excluded[name], Mod: info.ModTime(), Mode: sel.modes[name]}
+	f = classify(p, f, r.refs)
+	if f.Skip == "" && !f.Excluded {
+		f.Skip = b.take(f.Size)
+	}
+	return f
+}
+
+func scan(root string, sel selection, r ranker, b budget) []File {
+	var files []File
+	walk(root, func(rel string, d fs.DirEntry, skip string) error {
+		if len(files) >= contextMaxScanned {
+			fmt.Fprintf(os.Stderr, "context: %s: stopped after %d entries\n", root, contextMaxScanned)
+			return filepath.SkipAll
+		}
+		f := File{Rel: rel, Excluded: sel.excluded[rel], Skip: skip, Mode: sel.modes[rel]}
+		files = append(files, scanned(root, rel, d, f, r))
+		return nil
+	})
+	sent, _ := CtxSent.Last(root)
+	pack(files, b, sent.Picked[root])
+	return files
+}
+
+// scanned fills in the size, skip reason and score of a walked file.
+func scanned(root, rel string, d fs.DirEntry, f File, r ranker) File {
+	info, err := d.Info()
+	if err != nil || d.IsDir() {
+		return f
+	}
+	f.Size, f.Mod = info.Size(), info.ModTime()
+	if f.Skip != "" {
+		return f
+	}
+	p := filepath.Join(root, filepath.FromSlash(rel))
+	f = classify(p, f, r.refs)
+	if f.Skip == "" {
+		f.Score, f.named = r.score(p, rel, f.Size, info.ModTime()), r.named(rel) > 0
+	}
+	return f
+}
+
+// classify marks a file that cannot be sent whatever the budget and
+// outlines it when its mode asks for that, or in ModeAuto when it is over
+// contextMaxPerFile. Functions in refs keep their bodies.
+func classify(path string, f File, refs map[string]bool) File {
+	if sniffBinary(path) {
+		f.Skip = SkipBinary
+		return f
+	}
+	if f.Mode == ModeOutline || (f.Mode == ModeAuto && f.Size > contextMaxPerFile) {
+		return outlined(path, f, refs)
+	}
+	if f.Size > contextMaxPerFile {
+		f.Skip = SkipTooLarge
+	}
+	return f
+}
+
+// outlined replaces the file's content with its outline, which must fit
+// contextMaxPerFile. A file that cannot be outlined is sent in full if it
+// fits.
+func outlined(path string, f File, refs map[string]bool) File {
+	if f.Size > contextMaxOutlineSource {
+		f.Skip = SkipTooLarge
+		return f
+	}
+	data, err := CtxWatcher.read(path)
+	if err != nil {
+		return f
+	}
+	text, ok := Outline(path, data, refs)
+	if ok && len(text) <= contextMaxPerFile {
+		f.Outline, f.text, f.Size = true, text, int64(len(text))
+		return f
+	}
+	if f.Size > contextMaxPerFile {
+		f.Skip = SkipTooLarge
+	}
+	return f
+}
+
+// sniffBinary applies isBinary to the start of the file without reading
+// the rest.
+func sniffBinary(path string) bool {
+	fh, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer fh.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(fh, head)
+	return isBinary(head[:n])
+}
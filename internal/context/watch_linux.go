@@ -0,0 +1,277 @@
+package context
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"second-nature/internal/applog"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+const (
+	maxWatches    = 4096 // directories watched per root, well under the default inotify limit
+	watchDebounce = 300 * time.Millisecond
+)
+
+var CtxWatcher = &Watcher{watches: make(map[string]*watch), cache: make(map[string][]byte)}
+
+// Watcher follows the context roots with inotify. It keeps the files read
+// from them in memory until they change, so a turn does not re-read an
+// unchanged tree, and reports changes to the change handler.
+type Watcher struct {
+	mu       sync.Mutex
+	watches  map[string]*watch // root → its watch
+	cache    map[string][]byte // path → content
+	gen      int               // bumped on every change, to drop reads that raced one
+	onChange func()
+	pending  bool
+}
+
+// watch is one inotify instance on one root.
+type watch struct {
+	root string
+	file *os.File
+	fd   int
+	dirs map[int32]string // watch descriptor → directory
+}
+
+// SetChangeHandler sets fn to run, at most every watchDebounce, after files
+// under the watched roots change.
+func (w *Watcher) SetChangeHandler(fn func()) {
+	w.mu.Lock()
+	w.onChange = fn
+	w.mu.Unlock()
+}
+
+// Watch switches to roots, each a directory or a single file. Roots
+// already watched are kept as they are; the others stop being watched.
+func (w *Watcher) Watch(roots ...string) {
+	want := map[string]bool{}
+	for _, root := range roots {
+		want[filepath.Clean(root)] = true
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for root, wt := range w.watches {
+		if !want[root] {
+			w.stop(wt)
+		}
+	}
+	for root := range want {
+		w.start(root)
+	}
+}
+
+// start watches root unless it already is; called with w.mu held.
+func (w *Watcher) start(root string) {
+	if _, ok := w.watches[root]; ok {
+		return
+	}
+	wt, err := startWatch(root)
+	if err != nil {
+		applog.AppLog.Warn("context: cannot watch %s: %v", root, err)
+		return
+	}
+	w.watches[root] = wt
+	applog.AppLog.Info("context: watching %s (%d directories)", root, len(wt.dirs))
+	go w.loop(wt)
+}
+
+// stop closes wt and drops the cached files only it covered; called with
+// w.mu held.
+func (w *Watcher) stop(wt *watch) {
+	wt.file.Close()
+	delete(w.watches, wt.root)
+	for p := range w.cache {
+		if !w.watched(p) {
+			delete(w.cache, p)
+		}
+	}
+}
+
+// watched reports whether path is under a watched root; called with w.mu
+// held.
+func (w *Watcher) watched(path string) bool {
+	for root := range w.watches {
+		if within(root, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func startWatch(root string) (*watch, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify: %w", err)
+	}
+	wt := &watch{root: root, file: os.NewFile(uintptr(fd), "inotify"), fd: fd, dirs: make(map[int32]string)}
+	if !info.IsDir() {
+		// Editors often save by renaming over the file, so watch its directory.
+		wt.add(filepath.Dir(root))
+		return wt, nil
+	}
+	wt.addTree(root)
+	return wt, nil
+}
+
+func (wt *watch) add(dir string) {
+	if len(wt.dirs) >= maxWatches {
+		return
+	}
+	wd, err := syscall.InotifyAddWatch(wt.fd, dir, inotifyMask)
+	if err != nil {
+		applog.AppLog.Warn("context: cannot watch %s: %v", dir, err)
+		return
+	}
+	wt.dirs[int32(wd)] = dir
+}
+
+// addTree watches dir and the directories below it that the walker could
+// enter: hidden and default-ignored ones are left out.
+func (wt *watch) addTree(dir string) {
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if p != wt.root && wt.skipDir(p) {
+			return filepath.SkipDir
+		}
+		wt.add(p)
+		return nil
+	})
+}
+
+func (wt *watch) skipDir(p string) bool {
+	rel, err := filepath.Rel(wt.root, p)
+	if err != nil {
+		return true
+	}
+	return strings.HasPrefix(filepath.Base(p), ".") || defaultIgnores.match(filepath.ToSlash(rel), true)
+}
+
+// read returns a file's content, from memory when it is under a watched
+// root and has not changed since it was last read.
+func (w *Watcher) read(path string) ([]byte, error) {
+	path = filepath.Clean(path)
+	w.mu.Lock()
+	data, ok := w.cache[path]
+	gen, watched := w.gen, w.watched(path)
+	w.mu.Unlock()
+	if ok {
+		return data, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || !watched {
+		return data, err
+	}
+	w.mu.Lock()
+	if w.gen == gen {
+		w.cache[path] = data
+	}
+	w.mu.Unlock()
+	return data, nil
+}
+
+func within(root, path string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+// loop reads events until the watch is closed by Watch.
+func (w *Watcher) loop(wt *watch) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := wt.file.Read(buf)
+		if err != nil {
+			return
+		}
+		w.handle(wt, buf[:n])
+	}
+}
+
+// handle applies a batch of raw inotify events.
+func (w *Watcher) handle(wt *watch, buf []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.watches[wt.root] != wt {
+		return
+	}
+	for off := 0; off+syscall.SizeofInotifyEvent <= len(buf); {
+		ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+		nameStart := off + syscall.SizeofInotifyEvent
+		name := strings.TrimRight(string(buf[nameStart:nameStart+int(ev.Len)]), "\x00")
+		w.event(wt, ev.Wd, ev.Mask, name)
+		off = nameStart + int(ev.Len)
+	}
+	w.gen++
+	w.notify()
+}
+
+func (w *Watcher) event(wt *watch, wd int32, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.overflow(wt)
+		return
+	}
+	dir, ok := wt.dirs[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(wt.dirs, wd)
+	}
+	if !ok {
+		return
+	}
+	p := filepath.Join(dir, name)
+	w.forget(p)
+	created := mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0
+	if created && mask&syscall.IN_ISDIR != 0 && within(wt.root, p) && !wt.skipDir(p) {
+		wt.addTree(p)
+	}
+}
+
+// overflow recovers from events the kernel dropped: nothing cached under
+// the root can be trusted, and directories created meanwhile are not
+// watched. handle then bumps gen and notifies, so the next scan reads the
+// whole tree afresh.
+func (w *Watcher) overflow(wt *watch) {
+	applog.AppLog.Warn("context: %s: inotify queue overflowed, rescanning", wt.root)
+	w.forget(wt.root)
+	wt.addTree(wt.root)
+}
+
+// forget drops the cached content of path and of anything below it.
+func (w *Watcher) forget(path string) {
+	for p := range w.cache {
+		if within(path, p) {
+			delete(w.cache, p)
+		}
+	}
+}
+
+// notify schedules the change handler; called with w.mu held.
+func (w *Watcher) notify() {
+	if w.onChange == nil || w.pending {
+		return
+	}
+	w.pending = true
+	time.AfterFunc(watchDebounce, func() {
+		w.mu.Lock()
+		w.pending = false
+		fn := w.onChange
+		w.mu.Unlock()
+		if fn != nil {
+			fn()
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package context
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// inotifyBatch encodes events the way the kernel returns them from read,
+// into a buffer with room to spare as loop's is.
+func inotifyBatch(events ...syscall.InotifyEvent) []byte {
+	buf := make([]byte, 0, 4096)
+	for _, ev := range events {
+		buf = append(buf, unsafe.Slice((*byte)(unsafe.Pointer(&ev)), syscall.SizeofInotifyEvent)...)
+	}
+	return buf
+}
+
+func TestWatcherOverflow(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "a.go")
+	if err := os.WriteFile(file, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w := &Watcher{watches: make(map[string]*watch), cache: make(map[string][]byte)}
+	wt, err := startWatch(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { wt.file.Close() })
+	w.watches[root] = wt
+	changed := make(chan struct{}, 1)
+	w.SetChangeHandler(func() { changed <- struct{}{} })
+
+	if data, _ := w.read(file); string(data) != "old" {
+		t.Fatalf("read %q", data)
+	}
+	// Changes whose events the kernel dropped.
+	if err := os.WriteFile(file, []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(root, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	gen := w.gen
+
+	w.handle(wt, inotifyBatch(syscall.InotifyEvent{Wd: -1, Mask: syscall.IN_Q_OVERFLOW}))
+
+	if data, _ := w.read(file); string(data) != "new" {
+		t.Errorf("read %q after overflow, want the file re-read", data)
+	}
+	if w.gen == gen {
+		t.Error("generation not bumped")
+	}
+	w.mu.Lock()
+	dirs := make([]string, 0, len(wt.dirs))
+	for _, d := range wt.dirs {
+		dirs = append(dirs, d)
+	}
+	w.mu.Unlock()
+	if !slices.Contains(dirs, sub) {
+		t.Errorf("watching %v, want the directory created during the overflow too", dirs)
+	}
+	select {
+	case <-changed:
+	case <-time.After(10 * watchDebounce):
+		t.Error("change handler not run")
+	}
+}
+
+func TestWatcherHandlerClearedBeforeNotify(t *testing.T) {
+	w := &Watcher{watches: make(map[string]*watch), cache: make(map[string][]byte)}
+	w.SetChangeHandler(func() { t.Error("cleared handler ran") })
+	w.mu.Lock()
+	w.notify()
+	w.mu.Unlock()
+	w.SetChangeHandler(nil)
+	time.Sleep(2 * watchDebounce)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"unsafe"
 
@@ -38,6 +39,13 @@ type keyState struct {
 	down  bool
 }
 
+// chordState is the held keys plus a chord that is complete but waits to
+// see whether a longer chord containing it is pressed.
+type chordState struct {
+	held    keyState
+	pending *binding
+}
+
 func ListenHotkey(ch chan<- model.HotkeyAction) error {
 	keyboards := findAllKeyboards()
 	if len(keyboards) == 0 {
@@ -101,7 +109,7 @@ func listenDevice(path string, ch chan<- model.HotkeyAction) error {
 	defer f.Close()
 
 	buf := make([]byte, inputEventSize*64)
-	var ks keyState
+	var ks chordState
 
 	for {
 		n, err := f.Read(buf)
@@ -115,52 +123,122 @@ func listenDevice(path string, ch chan<- model.HotkeyAction) error {
 	}
 }
 
-func processEvent(ev *inputEvent, ks keyState, ch chan<- model.HotkeyAction) keyState {
-	if ev.Type != evKey {
-		return ks
-	}
+// binding maps a chord of held arrow keys to an action.
+type binding struct {
+	keys   keyState
+	action model.HotkeyAction
+}
 
-	pressed := ev.Value == keyPress
-	ks = updateKeyState(ev.Code, pressed, ks)
+// builtinBindings are the fixed chords, most keys first so a chord wins
+// over any chord it contains. A chord that a longer one contains fires
+// when one of its keys is released, unless the longer one completes first.
+var builtinBindings = []binding{
+	{keyState{left: true, right: true, up: true, down: true}, model.HotkeyClear}, // clear conversation history
+	{keyState{left: true, right: true}, model.HotkeyCapture},                     // screen capture
+	{keyState{left: true, down: true}, model.HotkeyAudioCapture},                 // toggle audio capture
+	{keyState{right: true, down: true}, model.HotkeyAudioSend},                   // send accumulated transcript to LLM
+	{keyState{right: true, up: true}, model.HotkeyStop},                          // stop the in-flight response
+	{keyState{up: true, down: true}, model.HotkeyFollowUp},                       // toggle voice recording
+}
 
-	// All four keys → clear conversation history
-	if ks.left && ks.right && ks.up && ks.down {
-		send(ch, model.HotkeyClear)
-		ks.left, ks.right, ks.up, ks.down = false, false, false, false
-		return ks
-	}
+var bindings = builtinBindings
 
-	// Left+Right → screen capture
-	if ks.left && ks.right {
-		send(ch, model.HotkeyCapture)
-		ks.left, ks.right = false, false
-		return ks
+// BindCustomActions adds the chords of the registered custom actions. It
+// must be called before ListenHotkey. A chord already taken by a built-in
+// or another custom action is an error.
+func BindCustomActions() error {
+	all := append([]binding(nil), builtinBindings...)
+	for id, c := range model.CustomActions {
+		var err error
+		if all, err = bindChord(all, id, c); err != nil {
+			return err
+		}
 	}
+	slices.SortStableFunc(all, func(a, b binding) int { return b.keys.count() - a.keys.count() })
+	bindings = all
+	return nil
+}
 
-	// Left+Down → toggle audio capture
-	if ks.left && ks.down {
-		send(ch, model.HotkeyAudioCapture)
-		ks.left, ks.down = false, false
-		return ks
+func bindChord(all []binding, id model.HotkeyAction, c model.CustomAction) ([]binding, error) {
+	if c.Chord == "" {
+		return all, nil
 	}
-
-	// Right+Down → send accumulated transcript to LLM
-	if ks.right && ks.down {
-		send(ch, model.HotkeyAudioSend)
-		ks.right, ks.down = false, false
-		return ks
+	keys, err := model.ChordKeys(c.Chord)
+	if err != nil {
+		return nil, fmt.Errorf("custom action %q: %w", c.Name, err)
 	}
+	var ks keyState
+	for _, k := range keys {
+		ks = updateKeyState(keyCodes[k], true, ks)
+	}
+	if i := slices.IndexFunc(all, func(b binding) bool { return b.keys == ks }); i >= 0 {
+		return nil, fmt.Errorf("custom action %q: chord %q is already bound to %q", c.Name, c.Chord, model.ActionNames[all[i].action])
+	}
+	return append(all, binding{ks, id}), nil
+}
 
-	// Up+Down → toggle voice recording
-	if ks.up && ks.down {
-		send(ch, model.HotkeyFollowUp)
-		ks.up, ks.down = false, false
-		return ks
+var keyCodes = map[string]uint16{"left": keyLeft, "right": keyRight, "up": keyUp, "down": keyDown}
+
+func (ks keyState) count() int {
+	n := 0
+	for _, held := range []bool{ks.left, ks.right, ks.up, ks.down} {
+		if held {
+			n++
+		}
 	}
+	return n
+}
+
+// holds reports whether every key of chord is held in ks.
+func (ks keyState) holds(chord keyState) bool {
+	return (ks.left || !chord.left) && (ks.right || !chord.right) && (ks.up || !chord.up) && (ks.down || !chord.down)
+}
+
+// extended reports whether a longer bound chord contains b.
+func extended(b binding) bool {
+	return slices.ContainsFunc(bindings, func(o binding) bool {
+		return o.keys.count() > b.keys.count() && o.keys.holds(b.keys)
+	})
+}
 
+// release clears the keys of chord so it fires once per press.
+func (ks keyState) release(chord keyState) keyState {
+	ks.left = ks.left && !chord.left
+	ks.right = ks.right && !chord.right
+	ks.up = ks.up && !chord.up
+	ks.down = ks.down && !chord.down
 	return ks
 }
 
+// processEvent fires the longest chord held. A chord contained in a longer
+// one is kept pending until a key is released, so press order does not
+// decide between them.
+func processEvent(ev *inputEvent, cs chordState, ch chan<- model.HotkeyAction) chordState {
+	if ev.Type != evKey || ev.Value > keyPress {
+		return cs
+	}
+	pressed := ev.Value == keyPress
+	if !pressed && cs.pending != nil && updateKeyState(ev.Code, false, cs.pending.keys) != cs.pending.keys {
+		return fire(cs, *cs.pending, ch)
+	}
+	cs.held = updateKeyState(ev.Code, pressed, cs.held)
+	i := slices.IndexFunc(bindings, func(b binding) bool { return cs.held.holds(b.keys) })
+	if !pressed || i < 0 {
+		return cs
+	}
+	if extended(bindings[i]) {
+		cs.pending = &bindings[i]
+		return cs
+	}
+	return fire(cs, bindings[i], ch)
+}
+
+// fire sends b's action and clears its keys so it fires once per press.
+func fire(cs chordState, b binding, ch chan<- model.HotkeyAction) chordState {
+	send(ch, b.action)
+	return chordState{held: cs.held.release(b.keys)}
+}
+
 func updateKeyState(code uint16, pressed bool, ks keyState) keyState {
 	if code == keyLeft {
 		ks.left = pressed
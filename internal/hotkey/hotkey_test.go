@@ -0,0 +1,67 @@
+package hotkey
+
+import (
+	"slices"
+	"testing"
+
+	"second-nature/internal/model"
+)
+
+// step is one key event: a code pressed (+) or released (-).
+type step struct {
+	code    uint16
+	pressed bool
+}
+
+func down(code uint16) step { return step{code, true} }
+func up(code uint16) step   { return step{code, false} }
+
+func TestChordsFireLongestMatch(t *testing.T) {
+	if _, ok := model.ActionByName("chord-test"); !ok {
+		if _, err := model.RegisterActions([]model.CustomAction{{Name: "chord-test", Prompt: "x", Chord: "left+up+down"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := bindings
+	t.Cleanup(func() { bindings = saved })
+	if err := BindCustomActions(); err != nil {
+		t.Fatal(err)
+	}
+	custom, _ := model.ActionByName("chord-test")
+
+	tests := []struct {
+		name  string
+		steps []step
+		want  []model.HotkeyAction
+	}{
+		{"three keys, sub-chord first", []step{down(keyLeft), down(keyDown), down(keyUp), up(keyUp), up(keyDown), up(keyLeft)}, []model.HotkeyAction{custom}},
+		{"three keys, other order", []step{down(keyUp), down(keyLeft), down(keyDown), up(keyLeft), up(keyUp), up(keyDown)}, []model.HotkeyAction{custom}},
+		{"sub-chord alone fires on release", []step{down(keyLeft), down(keyDown), up(keyDown), up(keyLeft)}, []model.HotkeyAction{model.HotkeyAudioCapture}},
+		{"stop", []step{down(keyRight), down(keyUp), up(keyRight), up(keyUp)}, []model.HotkeyAction{model.HotkeyStop}},
+		{"longest chord fires on press", []step{down(keyUp), down(keyDown), down(keyLeft), down(keyRight)}, []model.HotkeyAction{model.HotkeyClear}},
+		{"all four keys", []step{down(keyLeft), down(keyRight), down(keyUp), down(keyDown), up(keyLeft), up(keyRight), up(keyUp), up(keyDown)}, []model.HotkeyAction{model.HotkeyClear}},
+		{"fires once per press", []step{down(keyUp), down(keyDown), up(keyDown), down(keyDown), up(keyDown), up(keyUp)}, []model.HotkeyAction{model.HotkeyFollowUp}},
+		{"single key", []step{down(keyLeft), up(keyLeft)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := make(chan model.HotkeyAction, 8)
+			var cs chordState
+			for _, s := range tt.steps {
+				value := int32(keyRelease)
+				if s.pressed {
+					value = keyPress
+				}
+				cs = processEvent(&inputEvent{Type: evKey, Code: s.code, Value: value}, cs, ch)
+			}
+			close(ch)
+			var got []model.HotkeyAction
+			for a := range ch {
+				got = append(got, a)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("fired %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
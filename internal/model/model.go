@@ -1,6 +1,13 @@
 package model
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -15,12 +22,15 @@ const (
 	HotkeyExplain                          // Left+Up
 	HotkeyAudioCapture                     // Left+Down (toggle audio capture)
 	HotkeyAudioSend                        // Right+Down (send accumulated transcript to LLM)
-	HotkeyToggleView                       // Right+Up (toggle transcript/chat view)
+	HotkeyToggleView                       // unused (superseded by overlay tabs)
 	HotkeyClear                            // Right+Left+Up+Down (clear conversation history)
 	HotkeySoundCheck                       // overlay-button only
 	HotkeyImplement                        // overlay-button only
 	HotkeyOptimize                         // inline button only
 	HotkeySimplify                         // inline button only
+	HotkeyStop                             // Right+Up (cancel in-flight response)
+	HotkeyCompare                          // overlay-button only
+	HotkeyCustom                           // first user-defined action, see RegisterActions
 )
 
 var KeyLabels = map[HotkeyAction]string{
@@ -31,6 +41,8 @@ var KeyLabels = map[HotkeyAction]string{
 	HotkeyClear:        "clear all",
 	HotkeySoundCheck:   "🔊 check",
 	HotkeyImplement:    "⚙ impl",
+	HotkeyStop:         "→↑ stop",
+	HotkeyCompare:      "⇆ compare",
 }
 
 var KeyOrder = []HotkeyAction{
@@ -39,6 +51,7 @@ var KeyOrder = []HotkeyAction{
 	HotkeyFollowUp,
 	HotkeyAudioSend,
 	HotkeyImplement,
+	HotkeyCompare,
 	HotkeyClear,
 }
 
@@ -53,6 +66,128 @@ var ActionNames = map[HotkeyAction]string{
 	HotkeyOptimize:     "optimize",
 	HotkeySimplify:     "simplify",
 	HotkeyClear:        "clear",
+	HotkeyStop:         "stop",
+	HotkeyCompare:      "compare",
+}
+
+// ActionByName looks up an action by its ActionNames name.
+func ActionByName(name string) (HotkeyAction, bool) {
+	for a, n := range ActionNames {
+		if n == name {
+			return a, true
+		}
+	}
+	return 0, false
+}
+
+// CustomAction is a user-defined action from AppConfig.Actions. Prompt is a
+// text/template executed with provider.ActionData; Inputs lists what the
+// action consumes (Input* constants). Chord is an optional hotkey made of
+// arrow keys joined by "+", e.g. "left+up".
+type CustomAction struct {
+	Name   string   `json:"name"`
+	Label  string   `json:"label,omitempty"` // overlay button label, defaults to Name
+	Prompt string   `json:"prompt"`
+	Inputs []string `json:"inputs,omitempty"`
+	Chord  string   `json:"chord,omitempty"`
+}
+
+const (
+	InputScreenshots = "screenshots" // selected screenshots
+	InputTranscript  = "transcript"  // selected transcript
+	InputContext     = "context"     // source files from the context directory
+	InputLastAnswer  = "last_answer" // the most recent answer in the conversation
+)
+
+var actionName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+var validInputs = map[string]bool{InputScreenshots: true, InputTranscript: true, InputContext: true, InputLastAnswer: true}
+
+// Uses reports whether the action consumes input.
+func (c CustomAction) Uses(input string) bool {
+	for _, in := range c.Inputs {
+		if in == input {
+			return true
+		}
+	}
+	return false
+}
+
+// chordArrows maps chord key names to the arrows shown in button labels.
+var chordArrows = map[string]string{"left": "←", "right": "→", "up": "↑", "down": "↓"}
+
+// ChordKeys splits a chord into its key names, rejecting unknown or
+// repeated keys and chords of fewer than two keys.
+func ChordKeys(chord string) ([]string, error) {
+	keys := strings.Split(strings.ToLower(strings.ReplaceAll(chord, " ", "")), "+")
+	seen := map[string]bool{}
+	for _, k := range keys {
+		if chordArrows[k] == "" || seen[k] {
+			return nil, fmt.Errorf("chord %q: want two or more distinct keys of left, right, up, down", chord)
+		}
+		seen[k] = true
+	}
+	if len(keys) < 2 {
+		return nil, fmt.Errorf("chord %q: want two or more distinct keys of left, right, up, down", chord)
+	}
+	return keys, nil
+}
+
+// CustomActions holds the registered user-defined actions.
+var CustomActions = map[HotkeyAction]CustomAction{}
+
+// RegisterActions adds user-defined actions to the action tables, after
+// any registered before, with footer buttons ahead of "clear all". It must
+// run before the hotkey listener and renderers are created. Each action
+// gets its own HotkeyAction, returned in order.
+func RegisterActions(actions []CustomAction) ([]HotkeyAction, error) {
+	ids := make([]HotkeyAction, 0, len(actions))
+	for _, c := range actions {
+		id := HotkeyCustom + HotkeyAction(len(CustomActions))
+		if err := registerAction(id, c); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func registerAction(id HotkeyAction, c CustomAction) error {
+	if !actionName.MatchString(c.Name) || c.Prompt == "" {
+		return fmt.Errorf("custom action %q: needs a prompt and a name of lowercase letters, digits, - or _", c.Name)
+	}
+	if _, taken := ActionByName(c.Name); taken || c.Name == "chat" || c.Name == "default" {
+		return fmt.Errorf("custom action %q: name already in use", c.Name)
+	}
+	for _, in := range c.Inputs {
+		if !validInputs[in] {
+			return fmt.Errorf("custom action %q: unknown input %q", c.Name, in)
+		}
+	}
+	label := c.Label
+	if label == "" {
+		label = c.Name
+	}
+	if c.Chord != "" {
+		keys, err := ChordKeys(c.Chord)
+		if err != nil {
+			return fmt.Errorf("custom action %q: %w", c.Name, err)
+		}
+		arrows := ""
+		for _, k := range keys {
+			arrows += chordArrows[k]
+		}
+		label = arrows + " " + label
+	}
+	CustomActions[id] = c
+	ActionNames[id] = c.Name
+	KeyLabels[id] = label
+	at := len(KeyOrder)
+	if i := slices.Index(KeyOrder, HotkeyClear); i >= 0 {
+		at = i
+	}
+	KeyOrder = slices.Insert(KeyOrder, at, id)
+	return nil
 }
 
 // --- Audio ---
@@ -67,7 +202,7 @@ const (
 
 // SummarizeFn sends text to the LLM and returns a summary.
 // Stateless — does not append to conversation history.
-type SummarizeFn func(text string) (string, error)
+type SummarizeFn func(ctx context.Context, text string) (string, error)
 
 // TranscriptEntry is a UI-stable transcript chunk with a unique ID.
 type TranscriptEntry struct {
@@ -79,17 +214,282 @@ type TranscriptEntry struct {
 
 // --- Provider ---
 
+// ErrInterrupted is returned when a streaming call is cancelled mid-answer.
+// The partial text is returned alongside it and kept in history.
+var ErrInterrupted = errors.New("response interrupted")
+
+// InterruptedMarker is appended to partial answers kept in history.
+const InterruptedMarker = "\n\n[interrupted]"
+
+// --- Conversation ---
+
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+)
+
+// Part is one piece of message content: text or a JPEG image.
+// ContextHash is set on the context-directory block and identifies the
+// file snapshot it carries. A block that holds only the changes since an
+// earlier one names that snapshot in ContextBase.
+type Part struct {
+	Text        string
+	Image       []byte
+	ContextHash string
+	ContextBase string
+}
+
+// Message is a provider-neutral conversation turn. Each provider converts
+// messages to its own wire format when sending.
+type Message struct {
+	Role        Role
+	Parts       []Part
+	TraceID     int
+	Traced      bool
+	Interrupted bool
+	Summary     bool // synthetic turn standing in for compacted history
+	Model       string
+	Time        time.Time
+	Seq         int    // stable ID within a conversation, assigned by Append
+	Input       string // what the user supplied: a solve turn's transcript or a follow-up's text
+	Lang        string // answer language of a solve turn, for rebuilding its prompt; empty otherwise
+	Profile     string // prompt profile of a solve turn, likewise
+}
+
+func TextPart(text string) Part  { return Part{Text: text} }
+func ImagePart(jpeg []byte) Part { return Part{Image: jpeg} }
+
+// Text joins the text parts, appending InterruptedMarker for partial answers.
+func (m Message) Text() string {
+	var b strings.Builder
+	for _, p := range m.Parts {
+		b.WriteString(p.Text)
+	}
+	if m.Interrupted {
+		b.WriteString(InterruptedMarker)
+	}
+	return b.String()
+}
+
+// Conversation is the shared message history. It outlives any single
+// provider so the model can be switched mid-session.
+type Conversation struct {
+	mu       sync.Mutex
+	messages []Message
+	seq      int
+}
+
+func NewConversation() *Conversation {
+	return &Conversation{}
+}
+
+// conversationOf wraps messages, continuing Seq numbering after theirs.
+func conversationOf(messages []Message) *Conversation {
+	c := &Conversation{messages: messages}
+	for _, m := range messages {
+		c.seq = max(c.seq, m.Seq)
+	}
+	return c
+}
+
+func (c *Conversation) Append(m Message) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+	c.seq++
+	m.Seq = c.seq
+	c.messages = append(c.messages, m)
+	return len(c.messages) - 1
+}
+
+// IndexOfSeq returns the index of the message with sequence number seq,
+// or -1.
+func (c *Conversation) IndexOfSeq(seq int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, m := range c.messages {
+		if m.Seq == seq {
+			return i
+		}
+	}
+	return -1
+}
+
+// ReplacePair swaps the user turn with sequence number seq and its reply
+// for a new pair, keeping their positions, sequence numbers and trace tags
+// so trace history indices stay valid. Reports whether the pair was found.
+func (c *Conversation) ReplacePair(seq int, user, reply Message) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := -1
+	for j, m := range c.messages {
+		if m.Seq == seq {
+			i = j
+		}
+	}
+	if i < 0 || i+1 >= len(c.messages) {
+		return false
+	}
+	for k, m := range []Message{user, reply} {
+		old := c.messages[i+k]
+		m.Seq, m.TraceID, m.Traced = old.Seq, old.TraceID, old.Traced
+		if m.Time.IsZero() {
+			m.Time = time.Now()
+		}
+		c.messages[i+k] = m
+	}
+	return true
+}
+
+// Messages returns a copy of the history.
+func (c *Conversation) Messages() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]Message, len(c.messages))
+	copy(out, c.messages)
+	return out
+}
+
+func (c *Conversation) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.messages)
+}
+
+func (c *Conversation) Clear() {
+	c.mu.Lock()
+	c.messages = nil
+	c.mu.Unlock()
+}
+
+// Truncate drops every message from index n on.
+func (c *Conversation) Truncate(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n < 0 || n >= len(c.messages) {
+		return
+	}
+	c.messages = c.messages[:n]
+}
+
+// RemovePair drops the user message at userIndex and the reply after it.
+func (c *Conversation) RemovePair(userIndex int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if userIndex < 0 || userIndex+2 > len(c.messages) {
+		return
+	}
+	c.messages = append(c.messages[:userIndex], c.messages[userIndex+2:]...)
+}
+
+// TagTrace links the user message at userIndex and its reply to a trace.
+func (c *Conversation) TagTrace(userIndex, traceID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := userIndex; i >= 0 && i < len(c.messages) && i < userIndex+2; i++ {
+		c.messages[i].TraceID = traceID
+		c.messages[i].Traced = true
+	}
+}
+
+// IndexOfTrace returns the index of the first message of a trace, or -1.
+func (c *Conversation) IndexOfTrace(traceID int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, m := range c.messages {
+		if m.Traced && m.TraceID == traceID {
+			return i
+		}
+	}
+	return -1
+}
+
+// RemoveTrace drops every message linked to traceID. Returns the index the
+// removed messages started at and how many were removed.
+func (c *Conversation) RemoveTrace(traceID int) (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	start := -1
+	kept := c.messages[:0]
+	for i, m := range c.messages {
+		match := m.Traced && m.TraceID == traceID
+		if match && start < 0 {
+			start = i
+		}
+		if !match {
+			kept = append(kept, m)
+		}
+	}
+	removed := len(c.messages) - len(kept)
+	c.messages = kept
+	return start, removed
+}
+
+// Clone returns an independent copy of the history.
+func (c *Conversation) Clone() *Conversation {
+	return conversationOf(c.Messages())
+}
+
+// Replace swaps in a whole new history, e.g. the fork of a compare answer
+// picked to continue the conversation.
+func (c *Conversation) Replace(messages []Message) {
+	fresh := conversationOf(append([]Message(nil), messages...))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = fresh.messages
+	c.seq = max(c.seq, fresh.seq)
+}
+
+// Compact replaces messages [0, cut) with replacement.
+func (c *Conversation) Compact(cut int, replacement []Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cut <= 0 || cut > len(c.messages) {
+		return
+	}
+	c.messages = append(append([]Message(nil), replacement...), c.messages[cut:]...)
+}
+
+// Compaction describes one history compaction: messages [0, Cut) were
+// folded into Replacement summary messages.
+type Compaction struct {
+	Cut          int
+	Replacement  int
+	Turns        int // user turns summarized
+	BeforeTokens int
+	AfterTokens  int
+	Time         time.Time
+}
+
+// Shift maps a history index from before the compaction to after it.
+// Indices inside the compacted range no longer exist and map to -1.
+func (c Compaction) Shift(index int) int {
+	if index < 0 || index < c.Cut {
+		return -1
+	}
+	return index - c.Cut + c.Replacement
+}
+
 type Provider interface {
-	Solve(images [][]byte, transcript string, onDelta func(string)) (string, error)
-	FollowUp(text string, onDelta func(string)) (string, error)
-	Summarize(text string) (string, error)
+	Solve(ctx context.Context, images [][]byte, transcript string, onDelta func(string)) (string, error)
+	FollowUp(ctx context.Context, text string, onDelta func(string)) (string, error)
+	// Reply answers user after history without touching the conversation.
+	Reply(ctx context.Context, history []Message, user Message, onDelta func(string)) (string, error)
+	// Action answers custom action a as a new turn, like FollowUp.
+	Action(ctx context.Context, a HotkeyAction, images [][]byte, transcript string, onDelta func(string)) (string, error)
+	Summarize(ctx context.Context, text string) (string, error)
 	ModelName() string
 	SetLanguage(lang string)
-	SetContextDir(dir string)
-	ContextDir() string
+	SetContextRoots(roots []string)
+	ContextRoots() []string
 	ClearHistory()
 	HistoryLen() int
-	RemoveHistoryPair(userIndex int)
+	Conversation() *Conversation
+	SetConversation(c *Conversation)
 }
 
 // --- Renderer ---
@@ -100,6 +500,8 @@ type Renderer interface {
 	StreamStart()
 	StreamDelta(delta string)
 	StreamDone()
+	StreamInterrupted()
+	StreamReset()
 	AppendStreamStart()
 	AppendStreamDelta(delta string)
 	AppendStreamDone()
@@ -116,11 +518,431 @@ type Renderer interface {
 	SetCurrentTraceID(id int)
 	AddObserveTrace(trace Trace)
 	RemoveObserveTrace(traceID int)
+	ReasoningDelta(delta string)
+	ToolActivity(ev ToolEvent)
+	UpdateUsage(traceID int, trace, session Usage)
+	AddCompactionMarker(c Compaction)
+	StreamAnswerDone(a Answer)
+	ReplaceStreamStart(seq int)
+	SetBranches(branches []BranchInfo)
+	SwitchBranch(from, to int)
+	CompareStart(models []string)
+	CompareDelta(col int, delta string)
+	CompareReset(col int)
+	CompareDone(col int, answer, errMsg string)
+	CompareChosen(col int)
 	ClearContextData()
 	Clear()
 	Close()
 }
 
+// --- Streaming ---
+
+// StreamControl holds the cancel funcs of the provider calls in flight so
+// a stop control can interrupt the one that is running.
+type StreamControl struct {
+	mu    sync.Mutex
+	calls map[int]context.CancelFunc // generation → cancel, until the call finishes
+	gen   int
+}
+
+// Begin returns a cancellable context for a new call. Calls already in
+// flight are left alone: the provider queue runs them first. The returned
+// func must be called when the call finishes.
+func (s *StreamControl) Begin(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	s.mu.Lock()
+	if s.calls == nil {
+		s.calls = make(map[int]context.CancelFunc)
+	}
+	s.gen++
+	gen := s.gen
+	s.calls[gen] = cancel
+	s.mu.Unlock()
+	return ctx, func() {
+		cancel()
+		s.mu.Lock()
+		delete(s.calls, gen)
+		s.mu.Unlock()
+	}
+}
+
+// Stop cancels the running call: the oldest one not finished, since the
+// provider queue runs interactive calls in the order they began. Calls
+// waiting behind it still run. Returns false if nothing was streaming.
+func (s *StreamControl) Stop() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gen := s.oldest()
+	if gen == 0 {
+		return false
+	}
+	s.calls[gen]()
+	return true
+}
+
+// oldest returns the generation of the oldest call in flight, or 0;
+// called with s.mu held.
+func (s *StreamControl) oldest() int {
+	first := 0
+	for gen := range s.calls {
+		if first == 0 || gen < first {
+			first = gen
+		}
+	}
+	return first
+}
+
+// Active reports whether a call is in flight.
+func (s *StreamControl) Active() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.calls) > 0
+}
+
+// HandleHotkey stops the in-flight call for HotkeyStop and reports whether
+// it consumed a. The dispatch loop calls it before dispatching any action,
+// for hotkeys and overlay buttons alike.
+func (s *StreamControl) HandleHotkey(a HotkeyAction) bool {
+	if a != HotkeyStop {
+		return false
+	}
+	s.Stop()
+	return true
+}
+
+// Stream runs call with a context Stop can cancel and ends r's stream: as
+// interrupted when the call was stopped, keeping the partial text, and as
+// done otherwise.
+func (s *StreamControl) Stream(parent context.Context, r Renderer, call func(ctx context.Context) (string, error)) (string, error) {
+	ctx, done := s.Begin(parent)
+	defer done()
+	text, err := call(ctx)
+	if errors.Is(err, ErrInterrupted) {
+		r.StreamInterrupted()
+		return text, err
+	}
+	r.StreamDone()
+	return text, err
+}
+
+// StreamHooks lets the dispatcher observe provider-side events other than
+// text deltas. Attached to the call context with WithStreamHooks.
+type StreamHooks struct {
+	Status    func(string)     // transient status, e.g. retry countdowns
+	Reset     func()           // discard streamed text; the answer restarts from scratch
+	Usage     func(Usage)      // token usage and cost of the finished call
+	Compacted func(Compaction) // history was compacted before the call
+	Reasoning func(string)     // thinking / reasoning summary deltas
+	Tool      func(ToolEvent)  // agentic tool call started or finished
+	Served    func(string)     // model the call was routed to, before it starts
+}
+
+type streamHooksKey struct{}
+
+func WithStreamHooks(ctx context.Context, h StreamHooks) context.Context {
+	return context.WithValue(ctx, streamHooksKey{}, h)
+}
+
+func StreamHooksFrom(ctx context.Context) StreamHooks {
+	h, _ := ctx.Value(streamHooksKey{}).(StreamHooks)
+	return h
+}
+
+func (h StreamHooks) SetStatus(status string) {
+	if h.Status != nil {
+		h.Status(status)
+	}
+}
+
+func (h StreamHooks) ResetStream() {
+	if h.Reset != nil {
+		h.Reset()
+	}
+}
+
+func (h StreamHooks) ReportUsage(u Usage) {
+	if h.Usage != nil {
+		h.Usage(u)
+	}
+}
+
+func (h StreamHooks) ReasoningDelta(delta string) {
+	if h.Reasoning != nil {
+		h.Reasoning(delta)
+	}
+}
+
+func (h StreamHooks) ReportTool(ev ToolEvent) {
+	if h.Tool != nil {
+		h.Tool(ev)
+	}
+}
+
+func (h StreamHooks) ReportServed(modelName string) {
+	if h.Served != nil {
+		h.Served(modelName)
+	}
+}
+
+func (h StreamHooks) ReportCompaction(c Compaction) {
+	if h.Compacted != nil {
+		h.Compacted(c)
+	}
+}
+
+// --- Priority ---
+
+// Priority orders calls waiting in a provider queue. Background work such as
+// transcript summarization runs only when no interactive call is waiting.
+type Priority int
+
+const (
+	PriorityInteractive Priority = iota
+	PriorityBackground
+)
+
+type priorityKey struct{}
+
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFrom returns the context's priority, interactive by default.
+func PriorityFrom(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+// --- Routing ---
+
+// Route names, the keys of AppConfig.Routes. Solve and FollowUp calls
+// without a route use RouteSolve and RouteFollowUp; custom actions can be
+// routed by their ActionNames name.
+const (
+	RouteSolve    = "solve"
+	RouteFollowUp = "followup"
+	RouteSummary  = "summary" // transcript summarization
+	RouteTests    = "tests"   // sandbox test generation
+	RouteExplain  = "explain"
+	RouteOptimize = "optimize"
+	RouteSimplify = "simplify"
+)
+
+// Route sends one kind of call to its own model. Provider and BaseURL are
+// as in CompareTarget; MaxTokens caps the answer (0 keeps the default).
+type Route struct {
+	Provider  string `json:"provider"`
+	Model     string `json:"model"`
+	BaseURL   string `json:"base_url,omitempty"`
+	MaxTokens int64  `json:"max_tokens,omitempty"`
+}
+
+type routeKey struct{}
+
+// WithRoute names the route the next provider call should take.
+func WithRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+func RouteFrom(ctx context.Context) string {
+	r, _ := ctx.Value(routeKey{}).(string)
+	return r
+}
+
+type maxTokensKey struct{}
+
+// WithMaxTokens caps the answer length of the next provider call.
+func WithMaxTokens(ctx context.Context, n int64) context.Context {
+	return context.WithValue(ctx, maxTokensKey{}, n)
+}
+
+// MaxTokensFrom returns the context's answer cap, or def if none is set.
+func MaxTokensFrom(ctx context.Context, def int64) int64 {
+	if n, _ := ctx.Value(maxTokensKey{}).(int64); n > 0 {
+		return n
+	}
+	return def
+}
+
+// --- Tools ---
+
+// ToolEvent reports one call in the agentic tool loop: once when it starts
+// (Done false) and again with its result.
+type ToolEvent struct {
+	ID      string
+	Name    string
+	Input   string // JSON arguments
+	Output  string
+	IsError bool
+	Done    bool
+}
+
+// --- Thinking ---
+
+// Thinking enables extended thinking for a call: BudgetTokens for Anthropic,
+// Effort ("minimal", "low", "medium", "high") for OpenAI and local servers.
+// The zero value disables it.
+type Thinking struct {
+	BudgetTokens int64  `json:"budget_tokens,omitempty"`
+	Effort       string `json:"effort,omitempty"`
+}
+
+type thinkingKey struct{}
+
+// WithThinking attaches the thinking settings for the next provider call.
+func WithThinking(ctx context.Context, t Thinking) context.Context {
+	return context.WithValue(ctx, thinkingKey{}, t)
+}
+
+func ThinkingFrom(ctx context.Context) Thinking {
+	t, _ := ctx.Value(thinkingKey{}).(Thinking)
+	return t
+}
+
+// --- Usage ---
+
+// Usage counts tokens for one or more calls. InputTokens excludes cached
+// tokens, which are billed separately.
+type Usage struct {
+	InputTokens      int64   `json:"input_tokens"`
+	OutputTokens     int64   `json:"output_tokens"`
+	CachedTokens     int64   `json:"cached_tokens"`
+	CacheWriteTokens int64   `json:"cache_write_tokens"`
+	Cost             float64 `json:"cost"`
+	Calls            int     `json:"calls"`
+}
+
+func (u Usage) Add(o Usage) Usage {
+	return Usage{
+		InputTokens:      u.InputTokens + o.InputTokens,
+		OutputTokens:     u.OutputTokens + o.OutputTokens,
+		CachedTokens:     u.CachedTokens + o.CachedTokens,
+		CacheWriteTokens: u.CacheWriteTokens + o.CacheWriteTokens,
+		Cost:             u.Cost + o.Cost,
+		Calls:            u.Calls + o.Calls,
+	}
+}
+
+// Price is USD per million tokens for one model.
+type Price struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CachedRead float64 `json:"cached_read"`
+	CacheWrite float64 `json:"cache_write"`
+}
+
+// ErrSpendingCap is returned instead of calling the API once the session
+// cost has reached AppConfig.SpendingCap.
+var ErrSpendingCap = errors.New("session spending cap reached")
+
+// --- API errors ---
+
+type ErrorKind int
+
+const (
+	ErrKindUnknown ErrorKind = iota
+	ErrKindRateLimited
+	ErrKindOverloaded
+	ErrKindAuth
+	ErrKindContextTooLong
+	ErrKindNetwork
+	ErrKindModelNotFound
+)
+
+var ErrorKindNames = map[ErrorKind]string{
+	ErrKindUnknown:        "api error",
+	ErrKindRateLimited:    "rate limited",
+	ErrKindOverloaded:     "overloaded",
+	ErrKindAuth:           "auth failed",
+	ErrKindContextTooLong: "context too long",
+	ErrKindNetwork:        "network error",
+	ErrKindModelNotFound:  "model not found",
+}
+
+var retryableKinds = map[ErrorKind]bool{
+	ErrKindRateLimited: true,
+	ErrKindOverloaded:  true,
+	ErrKindNetwork:     true,
+}
+
+// APIError is a classified provider failure.
+type APIError struct {
+	Kind       ErrorKind
+	Status     int
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	return ErrorKindNames[e.Kind] + ": " + e.Err.Error()
+}
+
+func (e *APIError) Unwrap() error { return e.Err }
+
+// Retryable reports whether the call may succeed if sent again.
+func (e *APIError) Retryable() bool { return retryableKinds[e.Kind] }
+
+// --- Structured answer ---
+
+// Answer is a solve reply in structured answer mode, decoded from the JSON
+// the model returns.
+type Answer struct {
+	Goal       string     `json:"goal"`
+	TLDR       string     `json:"tldr"`
+	Approach   string     `json:"approach"` // markdown, may include pseudocode
+	Artifacts  []Artifact `json:"artifacts"`
+	Complexity string     `json:"complexity"`
+	EdgeCases  []string   `json:"edge_cases"`
+}
+
+// Artifact is one complete code file of an Answer.
+type Artifact struct {
+	Language string `json:"language"`
+	Filename string `json:"filename"`
+	Content  string `json:"content"`
+}
+
+// ParseAnswer decodes a structured reply, tolerating a surrounding code
+// fence. It fails on anything that is not an answer object.
+func ParseAnswer(text string) (Answer, error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(strings.TrimPrefix(text, "```json"), "```")
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+	}
+	var a Answer
+	if err := json.Unmarshal([]byte(text), &a); err != nil {
+		return Answer{}, fmt.Errorf("decode structured answer: %w", err)
+	}
+	if a.Goal == "" && a.TLDR == "" && len(a.Artifacts) == 0 {
+		return Answer{}, fmt.Errorf("decode structured answer: no answer fields")
+	}
+	return a, nil
+}
+
+// Markdown renders the answer in the section layout of the free-form solve
+// prompt, for the terminal and for copying.
+func (a Answer) Markdown() string {
+	var b strings.Builder
+	section := func(title, body string) {
+		if body != "" {
+			fmt.Fprintf(&b, "## %s\n\n%s\n\n", title, body)
+		}
+	}
+	section("Goal", a.Goal)
+	section("TLDR", a.TLDR)
+	section("Approach", a.Approach)
+	for _, art := range a.Artifacts {
+		fmt.Fprintf(&b, "### %s\n\n```%s\n%s\n```\n\n", art.Filename, art.Language, strings.TrimRight(art.Content, "\n"))
+	}
+	section("Complexity", a.Complexity)
+	if len(a.EdgeCases) > 0 {
+		section("Edge cases", "- "+strings.Join(a.EdgeCases, "\n- "))
+	}
+	return strings.TrimSpace(b.String())
+}
+
 // --- Trace ---
 
 type Trace struct {
@@ -130,11 +952,144 @@ type Trace struct {
 	ScreenCount       int
 	ScreenTimes       []time.Time
 	HasTranscript     bool
-	HasContext         bool
-	ContextDir        string
+	HasContext        bool
+	ContextRoots      []string // every root the context was read from
 	ContextFiles      []string
 	TranscriptSnippet string
 	HistoryIndex      int
+	Usage             Usage
+	Answers           []TraceAnswer // compare runs: one per model
+	Model             string        // model that answered, after routing
+}
+
+// TraceAnswer is one model's answer in a compare run. Chosen marks the
+// answer that continued the conversation.
+type TraceAnswer struct {
+	Model  string
+	Text   string
+	Err    string
+	Usage  Usage
+	Chosen bool
+}
+
+// --- Branches ---
+
+// Branch is one line of the conversation. A fork shares its parent's
+// history up to and including the trace it was forked at, then continues
+// on its own. Each branch keeps its own trace list; trace IDs stay unique
+// across branches.
+type Branch struct {
+	ID        int
+	Name      string
+	ParentID  int // -1 for main
+	ForkTrace int // trace the branch was forked at; -1 for main
+	Conv      *Conversation
+	traces    []Trace // the branch's traces while it is inactive
+}
+
+// BranchInfo is what the overlay lists for a branch.
+type BranchInfo struct {
+	ID     int
+	Name   string
+	Turns  int
+	Active bool
+}
+
+// BranchSet holds a session's branches. The active branch's traces live
+// in AppState.Traces; switching swaps them with the saved list.
+type BranchSet struct {
+	mu       sync.Mutex
+	branches []*Branch
+	active   *Branch
+}
+
+func NewBranchSet(conv *Conversation) *BranchSet {
+	b := &BranchSet{}
+	b.Reset(conv)
+	return b
+}
+
+// Reset drops every branch and starts over with conv as main.
+func (b *BranchSet) Reset(conv *Conversation) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	main := &Branch{ID: 0, Name: "main", ParentID: -1, ForkTrace: -1, Conv: conv}
+	b.branches = []*Branch{main}
+	b.active = main
+}
+
+func (b *BranchSet) Active() *Branch {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.active
+}
+
+// List describes the branches in creation order.
+func (b *BranchSet) List() []BranchInfo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]BranchInfo, len(b.branches))
+	for i, br := range b.branches {
+		out[i] = BranchInfo{ID: br.ID, Name: br.Name, Turns: br.Conv.Len() / 2, Active: br == b.active}
+	}
+	return out
+}
+
+// Fork creates a branch from the active one at traceID and switches to
+// it. The new branch gets a copy of the history through that trace's reply
+// and the traces up to it. Traces folded into a compaction summary cannot
+// be forked from.
+func (b *BranchSet) Fork(state *AppState, traceID int) (*Branch, error) {
+	tp := state.GetTrace(traceID)
+	if tp == nil {
+		return nil, fmt.Errorf("no trace #%d on this branch", traceID)
+	}
+	at := tp.HistoryIndex
+	if at < 0 {
+		return nil, fmt.Errorf("trace #%d was summarized by history compaction", traceID)
+	}
+	var traces []Trace
+	for _, t := range state.TracesSnapshot() {
+		if t.HistoryIndex >= 0 && t.HistoryIndex <= at {
+			traces = append(traces, t)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	messages := b.active.Conv.Messages()
+	messages = messages[:min(at+2, len(messages))]
+	nb := &Branch{
+		ID:        len(b.branches),
+		Name:      fmt.Sprintf("branch %d · trace #%d", len(b.branches), traceID),
+		ParentID:  b.active.ID,
+		ForkTrace: traceID,
+		Conv:      conversationOf(messages),
+		traces:    traces,
+	}
+	b.branches = append(b.branches, nb)
+	b.switchLocked(state, nb)
+	return nb, nil
+}
+
+// Switch makes branch id active, swapping the trace lists in state.
+func (b *BranchSet) Switch(state *AppState, id int) (*Branch, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if id < 0 || id >= len(b.branches) {
+		return nil, fmt.Errorf("no branch %d", id)
+	}
+	b.switchLocked(state, b.branches[id])
+	return b.active, nil
+}
+
+func (b *BranchSet) switchLocked(state *AppState, to *Branch) {
+	if to == b.active {
+		return
+	}
+	b.active.traces = state.SwapTraces(to.traces)
+	to.traces = nil
+	b.active = to
 }
 
 // --- Screenshot ---
@@ -321,7 +1276,7 @@ func (s *AppState) RestoreScreenshot(id int) *ScreenshotEntry {
 	return &e
 }
 
-func (s *AppState) AddTrace(screenIDs []int, screenTimes []time.Time, hasTranscript, hasContext bool, contextDir string, contextFiles []string, transcriptSnippet string, historyIndex int) Trace {
+func (s *AppState) AddTrace(screenIDs []int, screenTimes []time.Time, hasTranscript, hasContext bool, contextRoots []string, contextFiles []string, transcriptSnippet string, historyIndex int) Trace {
 	s.Mu.Lock()
 	defer s.Mu.Unlock()
 	id := s.NextTraceID
@@ -336,8 +1291,8 @@ func (s *AppState) AddTrace(screenIDs []int, screenTimes []time.Time, hasTranscr
 		ScreenCount:       len(screenIDs),
 		ScreenTimes:       screenTimes,
 		HasTranscript:     hasTranscript,
-		HasContext:         hasContext,
-		ContextDir:        contextDir,
+		HasContext:        hasContext,
+		ContextRoots:      contextRoots,
 		ContextFiles:      contextFiles,
 		TranscriptSnippet: transcriptSnippet,
 		HistoryIndex:      historyIndex,
@@ -376,6 +1331,79 @@ func (s *AppState) TracesSnapshot() []Trace {
 	return out
 }
 
+// AddTraceUsage accumulates a call's usage onto a trace and returns the total.
+func (s *AppState) AddTraceUsage(id int, u Usage) Usage {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	for i := range s.Traces {
+		if s.Traces[i].ID == id {
+			s.Traces[i].Usage = s.Traces[i].Usage.Add(u)
+			return s.Traces[i].Usage
+		}
+	}
+	return u
+}
+
+// SwapTraces replaces the trace list and returns the previous one.
+func (s *AppState) SwapTraces(traces []Trace) []Trace {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	old := s.Traces
+	s.Traces = traces
+	return old
+}
+
+// SetTraceModel records which model answered trace id.
+func (s *AppState) SetTraceModel(id int, modelName string) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	if t := s.traceLocked(id); t != nil {
+		t.Model = modelName
+	}
+}
+
+// SetTraceAnswers records the answers of a compare run on a trace.
+func (s *AppState) SetTraceAnswers(id int, answers []TraceAnswer) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	if t := s.traceLocked(id); t != nil {
+		t.Answers = append([]TraceAnswer(nil), answers...)
+	}
+}
+
+func (s *AppState) traceLocked(id int) *Trace {
+	for i := range s.Traces {
+		if s.Traces[i].ID == id {
+			return &s.Traces[i]
+		}
+	}
+	return nil
+}
+
+// ChooseTraceAnswer marks answer idx of a compare trace as the one that
+// continued the conversation.
+func (s *AppState) ChooseTraceAnswer(id, idx int) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	t := s.traceLocked(id)
+	if t == nil {
+		return
+	}
+	for j := range t.Answers {
+		t.Answers[j].Chosen = j == idx
+	}
+}
+
+// CompactTraceIndices remaps trace history indices after a compaction.
+// Traces whose turns were summarized get HistoryIndex -1.
+func (s *AppState) CompactTraceIndices(c Compaction) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	for i := range s.Traces {
+		s.Traces[i].HistoryIndex = c.Shift(s.Traces[i].HistoryIndex)
+	}
+}
+
 func (s *AppState) AdjustTraceIndicesAfter(idx, delta int) {
 	s.Mu.Lock()
 	defer s.Mu.Unlock()
@@ -428,18 +1456,84 @@ type LogEntry struct {
 
 // --- Config ---
 
+// ProviderLocal is the AppConfig.Provider value that selects the
+// OpenAI-compatible local server provider configured by BaseURL and Model.
+const ProviderLocal = "local"
+
+// ProviderGemini selects the Gemini API provider; BaseURL optionally
+// points it at another endpoint.
+const ProviderGemini = "gemini"
+
 type AppConfig struct {
-	Name              string `json:"name"`
-	Monitor           int    `json:"monitor"`
-	OverlayMonitor    int    `json:"overlay_monitor"`
-	OverlayFullscreen bool   `json:"overlay_fullscreen,omitempty"`
-	Provider          string `json:"provider"`
-	Renderer          string `json:"renderer"`
-	Language          string `json:"language"`
-	AudioMode         string `json:"audio_mode"`
-	MonSource         string `json:"mon_source,omitempty"`
-	WhisperModel      string `json:"whisper_model,omitempty"`
-	ContextDir        string `json:"context_dir,omitempty"`
+	Name              string                  `json:"name"`
+	Monitor           int                     `json:"monitor"`
+	OverlayMonitor    int                     `json:"overlay_monitor"`
+	OverlayFullscreen bool                    `json:"overlay_fullscreen,omitempty"`
+	Provider          string                  `json:"provider"`
+	Renderer          string                  `json:"renderer"`
+	Language          string                  `json:"language"`
+	AudioMode         string                  `json:"audio_mode"`
+	MonSource         string                  `json:"mon_source,omitempty"`
+	WhisperModel      string                  `json:"whisper_model,omitempty"`
+	ContextDir        string                  `json:"context_dir,omitempty"`
+	ContextRoots      []string                `json:"context_roots,omitempty"` // more roots after context_dir
+	BaseURL           string                  `json:"base_url,omitempty"`
+	Model             string                  `json:"model,omitempty"`
+	Prices            map[string]Price        `json:"prices,omitempty"`
+	SpendingCap       float64                 `json:"spending_cap,omitempty"`
+	CompactThreshold  int                     `json:"compact_threshold,omitempty"`
+	CompactKeepTurns  int                     `json:"compact_keep_turns,omitempty"`
+	PromptDir         string                  `json:"prompt_dir,omitempty"`
+	PromptProfile     string                  `json:"prompt_profile,omitempty"`
+	Thinking          map[string]Thinking     `json:"thinking,omitempty"`
+	Tools             []string                `json:"tools,omitempty"`
+	ToolMaxIterations int                     `json:"tool_max_iterations,omitempty"`
+	Compare           []CompareTarget         `json:"compare,omitempty"`
+	StructuredAnswers bool                    `json:"structured_answers,omitempty"`
+	RecordDir         string                  `json:"record_dir,omitempty"`
+	Replay            string                  `json:"replay,omitempty"`
+	ReplaySpeed       float64                 `json:"replay_speed,omitempty"`
+	Actions           []CustomAction          `json:"actions,omitempty"`
+	LanguageFile      string                  `json:"language_file,omitempty"`
+	Routes            map[string]Route        `json:"routes,omitempty"`
+	ContextRules      map[string]ContextRules `json:"context_rules,omitempty"`
+	ContextDiffs      bool                    `json:"context_diffs,omitempty"`
+}
+
+// ContextRules narrows what is read from one context directory, on top of
+// its .gitignore and .ignore files. Globs use .gitignore syntax relative to
+// the directory; an empty list allows everything.
+type ContextRules struct {
+	Include    []string `json:"include,omitempty"`    // only files matching one of these
+	Exclude    []string `json:"exclude,omitempty"`    // files and directories to leave out
+	Extensions []string `json:"extensions,omitempty"` // e.g. ".go", ".md"
+}
+
+// CompareTarget is one model queried by the compare action. Provider is
+// "anthropic", "openai", ProviderLocal or ProviderGemini; BaseURL applies
+// to local and Gemini only.
+type CompareTarget struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	BaseURL  string `json:"base_url,omitempty"`
+}
+
+// ThinkingFor returns the thinking settings for an action, keyed by its
+// ActionNames name or "chat" for typed messages, falling back to "default".
+func (c AppConfig) ThinkingFor(action string) Thinking {
+	if t, ok := c.Thinking[action]; ok {
+		return t
+	}
+	return c.Thinking["default"]
+}
+
+// InitialContextRoots returns the context roots a session starts with:
+// context_dir, then context_roots.
+func (c AppConfig) InitialContextRoots() []string {
+	if c.ContextDir == "" {
+		return c.ContextRoots
+	}
+	return append([]string{c.ContextDir}, c.ContextRoots...)
 }
 
 type ConfigFile struct {
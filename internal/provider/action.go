@@ -0,0 +1,99 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+
+	"second-nature/internal/model"
+)
+
+// ActionData is what a custom action's prompt template is executed with.
+// Inputs the action does not list are left empty.
+type ActionData struct {
+	Transcript string
+	LastAnswer string
+	HasContext bool // a Source files block is in the conversation
+	ImageCount int
+	CodeRules  string
+}
+
+// ActionSet holds the parsed prompt templates of the custom actions.
+type ActionSet struct {
+	mu    sync.Mutex
+	tmpls map[model.HotkeyAction]*template.Template
+}
+
+// Actions is the session's custom action set.
+var Actions = &ActionSet{tmpls: map[model.HotkeyAction]*template.Template{}}
+
+// Load registers actions with the model action tables and parses their
+// prompts. It must run before the hotkey listener and renderers are
+// created. A bad action stops loading; those before it stay registered.
+func (s *ActionSet) Load(actions []model.CustomAction) error {
+	for _, c := range actions {
+		t, err := template.New(c.Name).Option("missingkey=error").Parse(c.Prompt)
+		if err != nil {
+			return fmt.Errorf("custom action %q: parse prompt: %w", c.Name, err)
+		}
+		ids, err := model.RegisterActions([]model.CustomAction{c})
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.tmpls[ids[0]] = t
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *ActionSet) template(a model.HotkeyAction) *template.Template {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tmpls[a]
+}
+
+// actionMessage builds the user turn for custom action a, with only the
+// inputs the action lists. Images and transcript are the user's current
+// selection. Providers send it through exchange, like a follow-up.
+func actionMessage(ctx context.Context, conv *model.Conversation, roots []string, a model.HotkeyAction, images [][]byte, transcript string) (model.Message, error) {
+	c, ok := model.CustomActions[a]
+	t := Actions.template(a)
+	if !ok || t == nil {
+		return model.Message{}, fmt.Errorf("no custom action %d", a)
+	}
+	var ctxParts []model.Part
+	data := ActionData{CodeRules: CodeRules}
+	if c.Uses(model.InputContext) {
+		ctxParts, data.HasContext = contextParts(ctx, conv, roots, transcript, images)
+	}
+	if c.Uses(model.InputTranscript) {
+		data.Transcript = transcript
+	}
+	if c.Uses(model.InputLastAnswer) {
+		data.LastAnswer = lastAnswer(conv.Messages())
+	}
+	if !c.Uses(model.InputScreenshots) {
+		images = nil
+	}
+	data.ImageCount = len(images)
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return model.Message{}, fmt.Errorf("custom action %q: %w", c.Name, err)
+	}
+	user := solveMessage(images, ctxParts, b.String(), "", "")
+	user.Input = b.String()
+	return user, nil
+}
+
+// lastAnswer returns the text of the newest assistant turn.
+func lastAnswer(messages []model.Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == model.RoleAssistant {
+			return messages[i].Text()
+		}
+	}
+	return ""
+}
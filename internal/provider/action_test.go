@@ -0,0 +1,78 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"second-nature/internal/model"
+)
+
+// testAction registers a custom action once per test binary.
+func testAction(t *testing.T) model.HotkeyAction {
+	t.Helper()
+	if a, ok := model.ActionByName("test-review"); ok {
+		return a
+	}
+	err := Actions.Load([]model.CustomAction{{Name: "test-review", Prompt: "review: {{.LastAnswer}}", Inputs: []string{model.InputLastAnswer}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := model.ActionByName("test-review")
+	return a
+}
+
+func TestActionsShareTheQueue(t *testing.T) {
+	a := testAction(t)
+	// The server echoes the turn it answers, slowly, so overlapping turns
+	// would pair answers with the wrong questions.
+	local, _ := localServer(t, func(w http.ResponseWriter, cr chatRequest) {
+		last := fmt.Sprint(cr.Messages[len(cr.Messages)-1].Content)
+		writeSSE(w, fmt.Sprintf(`{"choices":[{"delta":{"content":%q}}]}`, "re "))
+		time.Sleep(5 * time.Millisecond)
+		writeSSE(w, fmt.Sprintf(`{"choices":[{"delta":{"content":%q}}]}`, last), `[DONE]`)
+	})
+	q := NewQueued(local, nil)
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := q.FollowUp(context.Background(), fmt.Sprint("question ", i), nil); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := q.Action(context.Background(), a, nil, "", nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	messages := q.Conversation().Messages()
+	if len(messages) != 16 {
+		t.Fatalf("%d messages, want 16", len(messages))
+	}
+	for i := 0; i < len(messages); i += 2 {
+		user, reply := messages[i], messages[i+1]
+		if user.Role != model.RoleUser || reply.Role != model.RoleAssistant || reply.Text() != "re "+user.Text() {
+			t.Errorf("turn %d: %s %q answered by %s %q", i/2, user.Role, user.Text(), reply.Role, reply.Text())
+		}
+	}
+	actions := 0
+	for _, m := range messages {
+		if strings.HasPrefix(m.Input, "review: ") {
+			actions++
+		}
+	}
+	if actions != 4 {
+		t.Errorf("%d action turns, want 4", actions)
+	}
+}
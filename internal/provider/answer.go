@@ -0,0 +1,63 @@
+package provider
+
+import "context"
+
+// StructuredAnswers switches Solve to the JSON answer schema so the reply
+// decodes into a model.Answer; set from AppConfig at startup. Follow-ups
+// stay free-form markdown.
+var StructuredAnswers bool
+
+const answerSchemaName = "solve_answer"
+
+const structuredInstruction = `
+
+**Answer format:** reply with a single JSON object matching the provided schema, with no code fence around it. Put the problem goal in "goal", a one-line summary in "tldr", the approach (markdown, pseudocode welcome) in "approach", every complete code file in "artifacts" (raw source in "content", no markdown fences), time and space complexity in "complexity", and the edge cases handled in "edge_cases".`
+
+type structuredKey struct{}
+
+// structure marks a Solve call as structured and appends the format
+// instruction to its prompt; a no-op when structured mode is off.
+func structure(ctx context.Context, prompt string) (context.Context, string) {
+	if !StructuredAnswers {
+		return ctx, prompt
+	}
+	return context.WithValue(ctx, structuredKey{}, true), prompt + structuredInstruction
+}
+
+// wantsAnswerSchema reports whether a stream call should constrain its
+// output to answerSchema.
+func wantsAnswerSchema(ctx context.Context) bool {
+	on, _ := ctx.Value(structuredKey{}).(bool)
+	return on
+}
+
+// answerSchema is the JSON schema of model.Answer, in the strict subset all
+// four backends accept: every property required, no extra properties. It
+// goes out as Anthropic's output format, OpenAI's and local servers'
+// json_schema, and Gemini's responseJsonSchema.
+func answerSchema() map[string]any {
+	str := map[string]any{"type": "string"}
+	artifact := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"language": str,
+			"filename": str,
+			"content":  str,
+		},
+		"required":             []string{"language", "filename", "content"},
+		"additionalProperties": false,
+	}
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"goal":       str,
+			"tldr":       str,
+			"approach":   str,
+			"artifacts":  map[string]any{"type": "array", "items": artifact},
+			"complexity": str,
+			"edge_cases": map[string]any{"type": "array", "items": str},
+		},
+		"required":             []string{"goal", "tldr", "approach", "artifacts", "complexity", "edge_cases"},
+		"additionalProperties": false,
+	}
+}
@@ -7,137 +7,203 @@ import (
 	"strings"
 
 	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
 	"github.com/anthropics/anthropic-sdk-go/packages/ssestream"
 
-	appctx "second-nature/internal/context"
+	"second-nature/internal/model"
 )
 
 type AnthropicProvider struct {
-	client     anthropic.Client
-	model      anthropic.Model
-	lang       string
-	contextDir string
-	history    []anthropic.MessageParam
+	client anthropic.Client
+	model  anthropic.Model
+	session
 }
 
-func NewAnthropicProvider(model anthropic.Model) *AnthropicProvider {
-	return &AnthropicProvider{client: anthropic.NewClient(), model: model, lang: "Python"}
-}
-
-func (p *AnthropicProvider) SetLanguage(lang string) {
-	p.lang = lang
-}
-
-func (p *AnthropicProvider) SetContextDir(dir string) {
-	p.contextDir = dir
-}
-
-func (p *AnthropicProvider) ContextDir() string {
-	return p.contextDir
-}
-
-func (p *AnthropicProvider) ClearHistory() {
-	p.history = nil
+func NewAnthropicProvider(m anthropic.Model) *AnthropicProvider {
+	return &AnthropicProvider{client: anthropic.NewClient(option.WithMaxRetries(0)), model: m, session: newSession()}
 }
 
 func (p *AnthropicProvider) ModelName() string {
 	return string(p.model)
 }
 
-func streamText(stream *ssestream.Stream[anthropic.MessageStreamEventUnion], onDelta func(string)) (string, error) {
+// streamText collects text deltas and forwards thinking deltas to
+// onReasoning. If acc is non-nil the full message, including tool_use
+// blocks, is accumulated into it. acc and onReasoning may be nil.
+func streamText(stream *ssestream.Stream[anthropic.MessageStreamEventUnion], acc *anthropic.Message, onDelta, onReasoning func(string)) (string, model.Usage, error) {
 	var buf strings.Builder
+	var u model.Usage
 	for stream.Next() {
 		evt := stream.Current()
+		if acc != nil {
+			acc.Accumulate(evt)
+		}
 		if evt.Type == "content_block_delta" && evt.Delta.Type == "text_delta" {
 			buf.WriteString(evt.Delta.Text)
 			if onDelta != nil {
 				onDelta(evt.Delta.Text)
 			}
 		}
+		if evt.Type == "content_block_delta" && evt.Delta.Type == "thinking_delta" && onReasoning != nil {
+			onReasoning(evt.Delta.Thinking)
+		}
+		if evt.Type == "message_start" {
+			mu := evt.Message.Usage
+			u = model.Usage{InputTokens: mu.InputTokens, OutputTokens: mu.OutputTokens, CachedTokens: mu.CacheReadInputTokens, CacheWriteTokens: mu.CacheCreationInputTokens}
+		}
+		if evt.Type == "message_delta" {
+			u.OutputTokens = evt.Usage.OutputTokens
+		}
 	}
 	if err := stream.Err(); err != nil {
-		return "", fmt.Errorf("api call failed: %w", err)
+		return buf.String(), u, fmt.Errorf("api call failed: %w", err)
 	}
-	return buf.String(), nil
+	return buf.String(), u, nil
 }
 
-func (p *AnthropicProvider) Solve(images [][]byte, transcript string, onDelta func(string)) (string, error) {
+// toAnthropic converts neutral history to Messages API params and sets two
+// cache breakpoints: on the newest context block, so source files are
+// billed at the cached rate while unchanged, and on the last block, so the
+// whole history prefix is reused by the next turn.
+func toAnthropic(messages []model.Message) []anthropic.MessageParam {
+	out := make([]anthropic.MessageParam, 0, len(messages))
+	for _, m := range messages {
+		out = append(out, anthropicMessage(m))
+	}
+	if ci, cj := lastContextPart(messages); ci >= 0 && messages[ci].Role == model.RoleUser {
+		cacheBreakpoint(out[ci].Content[cj])
+	}
+	if n := len(out); n > 0 && len(out[n-1].Content) > 0 {
+		cacheBreakpoint(out[n-1].Content[len(out[n-1].Content)-1])
+	}
+	return out
+}
+
+func cacheBreakpoint(block anthropic.ContentBlockParamUnion) {
+	if cc := block.GetCacheControl(); cc != nil {
+		*cc = anthropic.NewCacheControlEphemeralParam()
+	}
+}
+
+func anthropicMessage(m model.Message) anthropic.MessageParam {
+	if m.Role == model.RoleAssistant {
+		return anthropic.NewAssistantMessage(anthropic.NewTextBlock(m.Text()))
+	}
 	var blocks []anthropic.ContentBlockParamUnion
-	for _, img := range images {
-		blocks = append(blocks, anthropic.NewImageBlockBase64("image/jpeg", base64.StdEncoding.EncodeToString(img)))
+	for _, part := range m.Parts {
+		blocks = append(blocks, anthropicBlock(part))
 	}
-	blocks = append(blocks, anthropic.NewTextBlock(BuildSolvePrompt(p.lang, appctx.ReadContextPath(p.contextDir), transcript, len(images))))
+	return anthropic.NewUserMessage(blocks...)
+}
 
-	p.history = append(p.history, anthropic.NewUserMessage(blocks...))
+func anthropicBlock(part model.Part) anthropic.ContentBlockParamUnion {
+	if part.Image != nil {
+		return anthropic.NewImageBlockBase64("image/jpeg", base64.StdEncoding.EncodeToString(part.Image))
+	}
+	return anthropic.NewTextBlock(part.Text)
+}
 
-	stream := p.client.Messages.NewStreaming(context.Background(), anthropic.MessageNewParams{
+// stream enables extended thinking when the context asks for a budget;
+// max_tokens must cover the budget plus the answer. With tools enabled it
+// runs the tool loop: tool_use turns and their results stay local to this
+// call, only the combined answer text is returned. Structured Solve calls
+// constrain the output to the answer schema.
+func (p *AnthropicProvider) stream(ctx context.Context, messages []model.Message, onDelta func(string)) (string, model.Usage, error) {
+	params := anthropic.MessageNewParams{
 		Model:     p.model,
-		MaxTokens: 4096,
-		Messages:  p.history,
-	})
+		MaxTokens: model.MaxTokensFrom(ctx, 4096),
+		Messages:  toAnthropic(messages),
+		Tools:     anthropicTools(activeTools()),
+	}
+	if budget := model.ThinkingFrom(ctx).BudgetTokens; budget > 0 {
+		params.Thinking = anthropic.ThinkingConfigParamOfEnabled(budget)
+		params.MaxTokens += budget
+	}
+	if wantsAnswerSchema(ctx) {
+		params.OutputConfig = anthropic.OutputConfigParam{Format: anthropic.JSONOutputFormatParam{Schema: answerSchema()}}
+	}
+	onReasoning := model.StreamHooksFrom(ctx).ReasoningDelta
+	answer := newAnswerStream(ctx, onDelta)
+	var total model.Usage
+	for iteration := 0; ; iteration++ {
+		if len(params.Tools) > 0 && toolsExhausted(iteration) {
+			params.ToolChoice = anthropic.ToolChoiceUnionParam{OfNone: &anthropic.ToolChoiceNoneParam{}}
+		}
+		var msg anthropic.Message
+		answer.nextRound()
+		_, u, err := streamText(p.client.Messages.NewStreaming(ctx, params), &msg, answer.delta, onReasoning)
+		logCache(p.ModelName(), u)
+		total = total.Add(u)
+		if err != nil || msg.StopReason != anthropic.StopReasonToolUse {
+			return answer.buf.String(), total, roundErr(err, iteration)
+		}
+		params.Messages = append(params.Messages, msg.ToParam(), anthropic.NewUserMessage(p.runTools(ctx, msg)...))
+	}
+}
+
+func anthropicTools(tools []tool) []anthropic.ToolUnionParam {
+	var out []anthropic.ToolUnionParam
+	for _, t := range tools {
+		out = append(out, anthropic.ToolUnionParam{OfTool: &anthropic.ToolParam{
+			Name:        t.name,
+			Description: anthropic.String(t.description),
+			InputSchema: anthropic.ToolInputSchemaParam{Properties: t.schema, Required: t.required},
+		}})
+	}
+	return out
+}
 
-	text, err := streamText(stream, onDelta)
-	if err != nil || text == "" {
-		p.history = p.history[:len(p.history)-1]
-		if err != nil {
-			return "", err
+// runTools executes every tool_use block of msg and returns the results.
+func (p *AnthropicProvider) runTools(ctx context.Context, msg anthropic.Message) []anthropic.ContentBlockParamUnion {
+	var results []anthropic.ContentBlockParamUnion
+	for _, block := range msg.Content {
+		if block.Type == "tool_use" {
+			out, isErr := runTool(ctx, p.ContextRoots(), block.ID, block.Name, block.Input)
+			results = append(results, anthropic.NewToolResultBlock(block.ID, out, isErr))
 		}
-		return "", fmt.Errorf("no text in response")
 	}
+	return results
+}
 
-	p.history = append(p.history, anthropic.NewAssistantMessage(
-		anthropic.NewTextBlock(text),
-	))
-	return text, nil
+func (p *AnthropicProvider) Solve(ctx context.Context, images [][]byte, transcript string, onDelta func(string)) (string, error) {
+	lang, roots, conv := p.snapshot()
+	ctxParts, hasContext := contextParts(ctx, conv, roots, transcript, images)
+	ctx, user := solveTurn(ctx, images, ctxParts, hasContext, transcript, lang)
+	return exchange(ctx, conv, p.ModelName(), user, p.stream, p.Summarize, onDelta)
 }
 
-func (p *AnthropicProvider) Summarize(text string) (string, error) {
-	stream := p.client.Messages.NewStreaming(context.Background(), anthropic.MessageNewParams{
-		Model:     p.model,
-		MaxTokens: 2048,
-		Messages: []anthropic.MessageParam{
-			anthropic.NewUserMessage(anthropic.NewTextBlock(text)),
-		},
+func (p *AnthropicProvider) Reply(ctx context.Context, history []model.Message, user model.Message, onDelta func(string)) (string, error) {
+	return reply(ctx, p.ModelName(), history, user, p.stream, onDelta)
+}
+
+func (p *AnthropicProvider) Summarize(ctx context.Context, text string) (string, error) {
+	result, err := oneShot(ctx, p.ModelName(), func(func(string)) (string, model.Usage, error) {
+		stream := p.client.Messages.NewStreaming(ctx, anthropic.MessageNewParams{
+			Model:     p.model,
+			MaxTokens: model.MaxTokensFrom(ctx, 2048),
+			Messages: []anthropic.MessageParam{
+				anthropic.NewUserMessage(anthropic.NewTextBlock(text)),
+			},
+		})
+		return streamText(stream, nil, nil, nil)
 	})
-	result, err := streamText(stream, nil)
 	if err != nil {
 		return "", fmt.Errorf("summarize failed: %w", err)
 	}
 	return result, nil
 }
 
-func (p *AnthropicProvider) HistoryLen() int { return len(p.history) }
-
-func (p *AnthropicProvider) RemoveHistoryPair(userIndex int) {
-	if userIndex < 0 || userIndex+2 > len(p.history) {
-		return
-	}
-	p.history = append(p.history[:userIndex], p.history[userIndex+2:]...)
+func (p *AnthropicProvider) FollowUp(ctx context.Context, text string, onDelta func(string)) (string, error) {
+	_, roots, conv := p.snapshot()
+	ctxParts, _ := followUpContextParts(ctx, conv, roots, text)
+	return exchange(ctx, conv, p.ModelName(), followUpMessage(ctxParts, text), p.stream, p.Summarize, onDelta)
 }
 
-func (p *AnthropicProvider) FollowUp(text string, onDelta func(string)) (string, error) {
-	msg := appctx.ReadContextPath(p.contextDir) + text
-	p.history = append(p.history, anthropic.NewUserMessage(
-		anthropic.NewTextBlock(msg),
-	))
-
-	stream := p.client.Messages.NewStreaming(context.Background(), anthropic.MessageNewParams{
-		Model:     p.model,
-		MaxTokens: 4096,
-		Messages:  p.history,
-	})
-
-	reply, err := streamText(stream, onDelta)
-	if err != nil || reply == "" {
-		p.history = p.history[:len(p.history)-1]
-		if err != nil {
-			return "", err
-		}
-		return "", fmt.Errorf("no text in response")
+func (p *AnthropicProvider) Action(ctx context.Context, a model.HotkeyAction, images [][]byte, transcript string, onDelta func(string)) (string, error) {
+	_, roots, conv := p.snapshot()
+	user, err := actionMessage(ctx, conv, roots, a, images, transcript)
+	if err != nil {
+		return "", err
 	}
-
-	p.history = append(p.history, anthropic.NewAssistantMessage(
-		anthropic.NewTextBlock(reply),
-	))
-	return reply, nil
+	return exchange(ctx, conv, p.ModelName(), user, p.stream, p.Summarize, onDelta)
 }
@@ -0,0 +1,178 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"strings"
+	"time"
+
+	"second-nature/internal/applog"
+	"second-nature/internal/model"
+)
+
+// CompactConfig controls automatic history compaction. Once the estimated
+// history size passes Threshold tokens, everything but the last KeepTurns
+// user turns is replaced by a model-written summary. Threshold <= 0
+// disables compaction.
+type CompactConfig struct {
+	Threshold int
+	KeepTurns int
+}
+
+// Compaction is the active policy; set from AppConfig at startup.
+var Compaction = CompactConfig{Threshold: 100_000, KeepTurns: 4}
+
+const (
+	charsPerToken       = 4
+	imageFallbackTokens = 1600
+	imageMaxEdge        = 1568
+	summaryInputLimit   = 6000 // chars kept per message when building the summary prompt
+)
+
+const compactPrompt = `Summarize the earlier part of this coding-assistant conversation so it can replace the original messages. Keep: the problem statement and constraints, what the screenshots showed, decisions made, the current solution approach and its complexity, and any open questions or user preferences. Include final code only if it is short. Be concise and factual; write it as notes, not as a reply.
+
+Conversation:
+
+`
+
+// EstimateTokens roughly sizes messages: text at ~4 chars per token,
+// images by their pixel area the way vision models bill them.
+func EstimateTokens(messages []model.Message) int {
+	total := 0
+	for _, m := range messages {
+		for _, p := range m.Parts {
+			total += len(p.Text) / charsPerToken
+			if p.Image != nil {
+				total += imageTokens(p.Image)
+			}
+		}
+	}
+	return total
+}
+
+// imageTokens estimates the cost of one JPEG after the provider downscales
+// its long edge to imageMaxEdge.
+func imageTokens(jpeg []byte) int {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(jpeg))
+	if err != nil || cfg.Width == 0 || cfg.Height == 0 {
+		return imageFallbackTokens
+	}
+	w, h := cfg.Width, cfg.Height
+	long := max(w, h)
+	if long > imageMaxEdge {
+		w = w * imageMaxEdge / long
+		h = h * imageMaxEdge / long
+	}
+	return w * h / 750
+}
+
+// compact summarizes old history when the conversation plus the pending
+// user turn exceeds the threshold. Failures are logged and the call proceeds
+// uncompacted.
+func compact(ctx context.Context, conv *model.Conversation, pending model.Message, summarize model.SummarizeFn) {
+	cfg := Compaction
+	if cfg.Threshold <= 0 || summarize == nil {
+		return
+	}
+	messages := conv.Messages()
+	before := EstimateTokens(append(messages, pending))
+	if before < cfg.Threshold {
+		return
+	}
+	cut, turns := compactCut(messages, cfg.KeepTurns)
+	if turns == 0 {
+		return
+	}
+
+	hooks := model.StreamHooksFrom(ctx)
+	hooks.SetStatus("compacting history…")
+	summary, err := summarize(ctx, compactPrompt+transcriptOf(messages[:cut]))
+	hooks.SetStatus("")
+	if err != nil {
+		applog.AppLog.Warn("compact: summary failed, keeping full history: %v", err)
+		return
+	}
+
+	// The newest context block survives so source files are not re-sent.
+	summaryParts := []model.Part{model.TextPart("Summary of the earlier conversation:\n\n" + summary)}
+	if i, j := lastFullContextPart(messages); i >= 0 && i < cut {
+		summaryParts = append([]model.Part{messages[i].Parts[j]}, summaryParts...)
+	}
+	replacement := []model.Message{
+		{Role: model.RoleUser, Parts: summaryParts, Summary: true},
+		{Role: model.RoleAssistant, Parts: []model.Part{model.TextPart("Understood — continuing from that summary.")}, Summary: true},
+	}
+	conv.Compact(cut, replacement)
+
+	c := model.Compaction{
+		Cut:          cut,
+		Replacement:  len(replacement),
+		Turns:        turns,
+		BeforeTokens: before,
+		AfterTokens:  EstimateTokens(append(conv.Messages(), pending)),
+		Time:         time.Now(),
+	}
+	applog.AppLog.Info("compact: %d turn(s) summarized, ~%d → ~%d tokens", c.Turns, c.BeforeTokens, c.AfterTokens)
+	hooks.ReportCompaction(c)
+}
+
+// compactCut returns the index of the first message to keep verbatim (the
+// start of the keep-th newest user turn) and how many new user turns fall
+// before it. A leading summary from an earlier compaction is re-summarized
+// but not counted.
+func compactCut(messages []model.Message, keep int) (int, int) {
+	var starts []int
+	for i, m := range messages {
+		if m.Role == model.RoleUser && !m.Summary {
+			starts = append(starts, i)
+		}
+	}
+	keep = max(keep, 0)
+	if len(starts) <= keep {
+		return 0, 0
+	}
+	cut := len(messages)
+	if keep > 0 {
+		cut = starts[len(starts)-keep]
+	}
+	return cut, len(starts) - keep
+}
+
+// transcriptOf renders messages as plain text for the summary prompt.
+// Images and context blocks become placeholders; long texts are clipped.
+func transcriptOf(messages []model.Message) string {
+	var b strings.Builder
+	for _, m := range messages {
+		images := 0
+		var sb strings.Builder
+		for _, p := range m.Parts {
+			if p.Image != nil {
+				images++
+			}
+			sb.WriteString(summaryText(p))
+		}
+		if m.Interrupted {
+			sb.WriteString(model.InterruptedMarker)
+		}
+		text := sb.String()
+		if len(text) > summaryInputLimit {
+			text = text[:summaryInputLimit] + "\n[…clipped]"
+		}
+		fmt.Fprintf(&b, "### %s\n", m.Role)
+		if images > 0 {
+			fmt.Fprintf(&b, "[%d screenshot(s)]\n", images)
+		}
+		b.WriteString(text + "\n\n")
+	}
+	return b.String()
+}
+
+func summaryText(p model.Part) string {
+	if p.ContextHash != "" {
+		return "[source files]\n"
+	}
+	return p.Text
+}
@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go/shared"
+
+	"second-nature/internal/applog"
+	"second-nature/internal/model"
+)
+
+// targetProviders builds a provider for each CompareTarget.Provider value.
+var targetProviders = map[string]func(model.CompareTarget) model.Provider{
+	"anthropic": func(t model.CompareTarget) model.Provider {
+		return NewAnthropicProvider(anthropic.Model(t.Model))
+	},
+	"openai": func(t model.CompareTarget) model.Provider {
+		return NewOpenAIProvider(shared.ResponsesModel(t.Model))
+	},
+	model.ProviderLocal: func(t model.CompareTarget) model.Provider {
+		return NewLocalProvider(t.BaseURL, t.Model)
+	},
+	model.ProviderGemini: func(t model.CompareTarget) model.Provider {
+		return NewGeminiProvider(t.BaseURL, t.Model)
+	},
+}
+
+// NewForTarget creates a provider for one compare target.
+func NewForTarget(t model.CompareTarget) (model.Provider, error) {
+	newProvider, ok := targetProviders[t.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown compare provider %q", t.Provider)
+	}
+	return newProvider(t), nil
+}
+
+// CompareRun holds the answers of one compare action until the user picks
+// the one that continues the conversation.
+type CompareRun struct {
+	Answers     []model.TraceAnswer
+	forks       []*model.Conversation
+	compactions [][]model.Compaction
+}
+
+// Compare sends the same Solve inputs to every candidate at once. Each
+// candidate answers on its own copy of base, so the shared history is
+// untouched until Adopt. Candidates must be dedicated instances (see
+// NewForTarget) with language and context dir already set.
+//
+// Deltas and stream resets are reported per column. Usage still goes to the
+// context's hooks; reasoning, tool and compaction events are not shown.
+func Compare(ctx context.Context, candidates []model.Provider, base *model.Conversation, images [][]byte, transcript string, onDelta func(col int, delta string), onReset func(col int)) *CompareRun {
+	run := &CompareRun{
+		Answers:     make([]model.TraceAnswer, len(candidates)),
+		forks:       make([]*model.Conversation, len(candidates)),
+		compactions: make([][]model.Compaction, len(candidates)),
+	}
+	var wg sync.WaitGroup
+	for i, p := range candidates {
+		run.forks[i] = base.Clone()
+		p.SetConversation(run.forks[i])
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run.solve(ctx, i, p, images, transcript, onDelta, onReset)
+		}()
+	}
+	wg.Wait()
+	return run
+}
+
+func (r *CompareRun) solve(ctx context.Context, col int, p model.Provider, images [][]byte, transcript string, onDelta func(int, string), onReset func(int)) {
+	parent := model.StreamHooksFrom(ctx)
+	a := &r.Answers[col]
+	a.Model = p.ModelName()
+	hooks := model.StreamHooks{
+		Status: parent.Status,
+		Reset:  func() { onReset(col) },
+		Usage: func(u model.Usage) {
+			a.Usage = a.Usage.Add(u)
+			parent.ReportUsage(u)
+		},
+		Compacted: func(c model.Compaction) { r.compactions[col] = append(r.compactions[col], c) },
+	}
+	answer, err := p.Solve(model.WithStreamHooks(ctx, hooks), images, transcript, func(d string) { onDelta(col, d) })
+	a.Text = answer
+	if err != nil {
+		a.Err = err.Error()
+	}
+	applog.AppLog.Info("compare: %s → %d chars, error=%v", a.Model, len(answer), err)
+}
+
+// Adopt makes answer col the conversation's continuation: conv's history is
+// replaced by that answer's fork and the answer is marked chosen. Any
+// compactions that happened in the fork are returned so trace indices can
+// be remapped.
+func (r *CompareRun) Adopt(col int, conv *model.Conversation) ([]model.Compaction, error) {
+	if col < 0 || col >= len(r.forks) {
+		return nil, fmt.Errorf("no compare answer %d", col)
+	}
+	if r.Answers[col].Err != "" || r.Answers[col].Text == "" {
+		return nil, fmt.Errorf("%s has no answer to continue with", r.Answers[col].Model)
+	}
+	conv.Replace(r.forks[col].Messages())
+	for i := range r.Answers {
+		r.Answers[i].Chosen = i == col
+	}
+	applog.AppLog.Info("compare: continuing with %s", r.Answers[col].Model)
+	return r.compactions[col], nil
+}
@@ -0,0 +1,178 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"second-nature/internal/applog"
+	appctx "second-nature/internal/context"
+	"second-nature/internal/model"
+	"second-nature/internal/system"
+)
+
+// ContextDiffs sends follow-ups only the changes to the source files since
+// the newest context block, as a unified diff; set from AppConfig at
+// startup.
+var ContextDiffs bool
+
+// contextParts reads the context roots and returns them as a separate part
+// for the next user turn, or nil if the same snapshot is already in the
+// history. hasContext reports whether any source files are in play, so the
+// prompt can refer to them either way. When a root is over its share of
+// the budget, its files most relevant to the turn's text and images are
+// sent; reading the images' text stops when ctx is done.
+func contextParts(ctx context.Context, conv *model.Conversation, roots []string, input string, images [][]byte) (parts []model.Part, hasContext bool) {
+	return contextBlock(ctx, conv, roots, input, images, false)
+}
+
+// followUpContextParts is contextParts for a follow-up, which sends a diff
+// against the newest context block instead when ContextDiffs is set.
+func followUpContextParts(ctx context.Context, conv *model.Conversation, roots []string, input string) ([]model.Part, bool) {
+	return contextBlock(ctx, conv, roots, input, nil, ContextDiffs)
+}
+
+func contextBlock(ctx context.Context, conv *model.Conversation, roots []string, input string, images [][]byte, diff bool) ([]model.Part, bool) {
+	if len(roots) == 0 {
+		return nil, false
+	}
+	messages := conv.Messages()
+	snap := appctx.ReadSnapshot(roots, appctx.NewSignals(input, system.ScreenText(ctx, images), messages))
+	text := snap.Text()
+	if text == "" {
+		return nil, false
+	}
+	sum := sha256.Sum256([]byte(text))
+	hash := hex.EncodeToString(sum[:6])
+	base, hasBase := latestSnapshot(messages)
+	if hasContextSnapshot(messages, hash) {
+		applog.AppLog.Info("context: %s unchanged, not re-sent", hash)
+		return nil, true
+	}
+	unsent.add(hash, snap)
+	if diff && hasBase {
+		if part, ok := diffPart(base, snap, hash, len(text)); ok {
+			return []model.Part{part}, true
+		}
+	}
+	applog.AppLog.Info("context: sending snapshot %s (%d KB)", hash, len(text)/1024)
+	return []model.Part{{Text: "**Source files:**\n\n" + text, ContextHash: hash}}, true
+}
+
+// unsent holds the snapshots of context blocks whose turn is still in
+// flight, by hash. CtxSent learns of a block only once its turn is in the
+// history, so a failed call neither becomes the base of the next diff nor
+// the selection the next turn keeps.
+var unsent = &pendingSnapshots{snaps: make(map[string]appctx.Snapshot)}
+
+type pendingSnapshots struct {
+	mu    sync.Mutex
+	snaps map[string]appctx.Snapshot
+}
+
+func (s *pendingSnapshots) add(hash string, snap appctx.Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snaps[hash] = snap
+}
+
+// settle drops the snapshots of user's context blocks, recording them in
+// CtxSent first when the turn was kept in the history.
+func (s *pendingSnapshots) settle(user model.Message, kept bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range user.Parts {
+		snap, ok := s.snaps[p.ContextHash]
+		delete(s.snaps, p.ContextHash)
+		if ok && kept {
+			appctx.CtxSent.Remember(p.ContextHash, snap)
+		}
+	}
+}
+
+// latestSnapshot returns the hash of the newest context block the model
+// can still see in full.
+func latestSnapshot(messages []model.Message) (string, bool) {
+	i, j := lastContextPart(messages)
+	if i < 0 {
+		return "", false
+	}
+	hash := messages[i].Parts[j].ContextHash
+	return hash, hasContextSnapshot(messages, hash)
+}
+
+// diffPart builds a context part with only the changes from the snapshot
+// base to snap, when base is still remembered and the diff is shorter than
+// the full text.
+func diffPart(base string, snap appctx.Snapshot, hash string, full int) (model.Part, bool) {
+	old, ok := appctx.CtxSent.Recall(base)
+	if !ok {
+		return model.Part{}, false
+	}
+	d := appctx.Diff(old, snap)
+	if d == "" || len(d) >= full {
+		return model.Part{}, false
+	}
+	applog.AppLog.Info("context: sending changes %s → %s (%d KB)", base, hash, len(d)/1024)
+	text := "**Source file changes since the last turn** (unified diff against the files sent before):\n\n```diff\n" + d + "```"
+	return model.Part{Text: text, ContextHash: hash, ContextBase: base}, true
+}
+
+// hasContextSnapshot reports whether the snapshot hash can be rebuilt from
+// the history: a context part with that hash is still there and, for a
+// diff, so is the snapshot it applies to. Compaction drops such parts,
+// which forces a re-send.
+func hasContextSnapshot(messages []model.Message, hash string) bool {
+	return snapshotIn(messages, hash, map[string]bool{})
+}
+
+func snapshotIn(messages []model.Message, hash string, seen map[string]bool) bool {
+	if seen[hash] {
+		return false
+	}
+	seen[hash] = true
+	for _, m := range messages {
+		for _, p := range m.Parts {
+			if p.ContextHash == hash && (p.ContextBase == "" || snapshotIn(messages, p.ContextBase, seen)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lastContextPart locates the newest context part, or (-1, -1).
+func lastContextPart(messages []model.Message) (int, int) {
+	return lastPartWhere(messages, func(p model.Part) bool { return p.ContextHash != "" })
+}
+
+// lastFullContextPart locates the newest context part that is not a diff.
+func lastFullContextPart(messages []model.Message) (int, int) {
+	return lastPartWhere(messages, func(p model.Part) bool { return p.ContextHash != "" && p.ContextBase == "" })
+}
+
+func lastPartWhere(messages []model.Message, match func(model.Part) bool) (int, int) {
+	for i := len(messages) - 1; i >= 0; i-- {
+		for j := len(messages[i].Parts) - 1; j >= 0; j-- {
+			if match(messages[i].Parts[j]) {
+				return i, j
+			}
+		}
+	}
+	return -1, -1
+}
+
+// logCache reports prompt-cache effectiveness for one call.
+func logCache(modelName string, u model.Usage) {
+	total := u.InputTokens + u.CachedTokens + u.CacheWriteTokens
+	if total == 0 {
+		return
+	}
+	if u.CachedTokens == 0 {
+		applog.AppLog.Info("cache: %s miss — %d written, %d uncached", modelName, u.CacheWriteTokens, u.InputTokens)
+		return
+	}
+	applog.AppLog.Info("cache: %s hit — %d read (%.0f%% of input), %d written, %d uncached",
+		modelName, u.CachedTokens, float64(u.CachedTokens)*100/float64(total), u.CacheWriteTokens, u.InputTokens)
+}
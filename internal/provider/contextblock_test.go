@@ -0,0 +1,51 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	appctx "second-nature/internal/context"
+)
+
+// TestContextSentAfterSuccess checks that a context block counts as sent
+// only once its turn is in the history.
+func TestContextSentAfterSuccess(t *testing.T) {
+	saved := appctx.CtxSent
+	t.Cleanup(func() { appctx.CtxSent = saved })
+	appctx.CtxSent = appctx.NewSentSnapshots()
+
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fail := true
+	p, _ := localServer(t, func(w http.ResponseWriter, cr chatRequest) {
+		if fail {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"model not found"}`)
+			return
+		}
+		writeSSE(w, `{"choices":[{"delta":{"content":"answer"}}]}`, `[DONE]`)
+	})
+	p.SetContextRoots([]string{root})
+
+	if _, err := p.Solve(context.Background(), nil, "two sum", nil); err == nil {
+		t.Fatal("Solve succeeded against a failing server")
+	}
+	if _, ok := appctx.CtxSent.Last(root); ok {
+		t.Error("a failed turn recorded its context block as sent")
+	}
+
+	fail = false
+	if _, err := p.Solve(context.Background(), nil, "two sum", nil); err != nil {
+		t.Fatalf("Solve: %v", err)
+	}
+	hash := p.Conversation().Messages()[0].Parts[0].ContextHash
+	if _, ok := appctx.CtxSent.Recall(hash); hash == "" || !ok {
+		t.Errorf("context block %q not recorded after the turn succeeded", hash)
+	}
+}
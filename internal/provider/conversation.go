@@ -0,0 +1,136 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"second-nature/internal/model"
+	"second-nature/internal/usage"
+)
+
+// streamFn sends the full message history in a provider's wire format and
+// streams the reply text.
+type streamFn func(ctx context.Context, messages []model.Message, onDelta func(string)) (string, model.Usage, error)
+
+// attemptFn is a single API attempt, retried by withRetry.
+type attemptFn func(onDelta func(string)) (string, model.Usage, error)
+
+// exchange appends the user turn, streams a reply and records it. On
+// failure the user turn is rolled back; on cancellation a partial reply is
+// kept and marked interrupted so the user/assistant pair stays intact.
+// The context blocks of a kept user turn are recorded in CtxSent.
+// Oversized history is compacted with summarize first.
+func exchange(ctx context.Context, conv *model.Conversation, modelName string, user model.Message, stream streamFn, summarize model.SummarizeFn, onDelta func(string)) (string, error) {
+	if err := usage.Session.Allow(); err != nil {
+		return "", err
+	}
+	compact(ctx, conv, user, summarize)
+	user.Role = model.RoleUser
+	idx := conv.Append(user)
+	defer unsent.settle(user, false)
+
+	messages := conv.Messages()
+	reply, u, err := withRetry(ctx, onDelta, func(onDelta func(string)) (string, model.Usage, error) {
+		return stream(ctx, messages, onDelta)
+	})
+	record(ctx, modelName, u)
+	if ctx.Err() != nil && reply != "" {
+		conv.Append(model.Message{Role: model.RoleAssistant, Parts: []model.Part{model.TextPart(reply)}, Model: modelName, Interrupted: true})
+		unsent.settle(user, true)
+		return reply, model.ErrInterrupted
+	}
+	if ctx.Err() != nil {
+		conv.Truncate(idx)
+		return "", model.ErrInterrupted
+	}
+	if err != nil || reply == "" {
+		conv.Truncate(idx)
+		if err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("no text in response")
+	}
+
+	conv.Append(model.Message{Role: model.RoleAssistant, Parts: []model.Part{model.TextPart(reply)}, Model: modelName})
+	unsent.settle(user, true)
+	return reply, nil
+}
+
+// reply streams an answer to user after history without reading or
+// writing any conversation; regenerate splices the result in itself.
+func reply(ctx context.Context, modelName string, history []model.Message, user model.Message, stream streamFn, onDelta func(string)) (string, error) {
+	if err := usage.Session.Allow(); err != nil {
+		return "", err
+	}
+	user.Role = model.RoleUser
+	messages := append(append([]model.Message(nil), history...), user)
+	text, u, err := withRetry(ctx, onDelta, func(onDelta func(string)) (string, model.Usage, error) {
+		return stream(ctx, messages, onDelta)
+	})
+	record(ctx, modelName, u)
+	if ctx.Err() != nil {
+		return text, model.ErrInterrupted
+	}
+	if err != nil {
+		return "", err
+	}
+	if text == "" {
+		return "", fmt.Errorf("no text in response")
+	}
+	return text, nil
+}
+
+// oneShot runs a stateless call such as Summarize under the same spending
+// cap, retry policy and usage accounting as conversation turns.
+func oneShot(ctx context.Context, modelName string, call attemptFn) (string, error) {
+	if err := usage.Session.Allow(); err != nil {
+		return "", err
+	}
+	text, u, err := withRetry(ctx, nil, call)
+	record(ctx, modelName, u)
+	return text, err
+}
+
+// record prices a call's usage into the session meter and reports it to the
+// caller's hooks so it can be attached to a trace.
+func record(ctx context.Context, modelName string, u model.Usage) {
+	if u == (model.Usage{}) {
+		return
+	}
+	model.StreamHooksFrom(ctx).ReportUsage(usage.Session.Record(modelName, u))
+}
+
+// solveTurn builds a Solve call's prompt with the active profile and wraps
+// it in the user turn, which keeps the profile for edit & resend.
+func solveTurn(ctx context.Context, images [][]byte, ctxParts []model.Part, hasContext bool, transcript, lang string) (context.Context, model.Message) {
+	profile := Profiles.Active()
+	ctx, prompt := structure(ctx, Profiles.SolvePrompt(profile, lang, hasContext, transcript, len(images)))
+	user := solveMessage(images, ctxParts, prompt, transcript, lang)
+	user.Profile = profile
+	return ctx, user
+}
+
+// solveMessage builds the user turn for Solve: screenshots first, then any
+// new context block, then the prompt. The transcript and language are kept
+// so the prompt can be rebuilt by edit & resend.
+func solveMessage(images [][]byte, ctxParts []model.Part, prompt, transcript, lang string) model.Message {
+	var parts []model.Part
+	for _, img := range images {
+		parts = append(parts, model.ImagePart(img))
+	}
+	parts = append(parts, ctxParts...)
+	parts = append(parts, model.TextPart(prompt))
+	return model.Message{Role: model.RoleUser, Parts: parts, Input: transcript, Lang: lang}
+}
+
+// followUpMessage builds a follow-up turn, carrying a context block only
+// when the files changed since it was last sent.
+func followUpMessage(ctxParts []model.Part, text string) model.Message {
+	parts := append(append([]model.Part(nil), ctxParts...), model.TextPart(text))
+	return model.Message{Role: model.RoleUser, Parts: parts, Input: text}
+}
+
+// textMessage builds a text-only user turn.
+func textMessage(text string) model.Message {
+	return model.Message{Role: model.RoleUser, Parts: []model.Part{model.TextPart(text)}}
+}
@@ -0,0 +1,304 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"second-nature/internal/model"
+)
+
+const (
+	DefaultGeminiBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+	DefaultGeminiModel   = "gemini-2.5-pro"
+	geminiMinThinking    = 128 // lowest thinking budget the Pro models allow
+)
+
+// geminiLegacyModels predate thinking and reject a thinkingConfig.
+var geminiLegacyModels = []string{"gemini-1.", "gemini-2.0-", "gemma-"}
+
+// geminiThinks reports whether modelName accepts a thinkingConfig.
+func geminiThinks(modelName string) bool {
+	return !slices.ContainsFunc(geminiLegacyModels, func(prefix string) bool { return strings.HasPrefix(modelName, prefix) })
+}
+
+// geminiSummaryThinking keeps thinking out of a summary's token limit: off
+// where the model allows it, the smallest budget on Pro models, which
+// always think, and no thinkingConfig at all for models without thinking.
+func geminiSummaryThinking(modelName string) *geminiThinkingConfig {
+	if !geminiThinks(modelName) {
+		return nil
+	}
+	if strings.Contains(modelName, "-pro") {
+		return &geminiThinkingConfig{ThinkingBudget: geminiMinThinking}
+	}
+	return &geminiThinkingConfig{ThinkingBudget: 0}
+}
+
+// GeminiProvider talks to the Gemini API's streamGenerateContent endpoint
+// over server-sent events. GEMINI_API_KEY authenticates it.
+type GeminiProvider struct {
+	client  *http.Client
+	baseURL string
+	apiKey  string
+	model   string
+	session
+}
+
+type geminiRequest struct {
+	Contents         []geminiContent         `json:"contents"`
+	GenerationConfig *geminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role"` // "user" or "model"
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text       string            `json:"text,omitempty"`
+	Thought    bool              `json:"thought,omitempty"`
+	InlineData *geminiInlineData `json:"inlineData,omitempty"`
+}
+
+type geminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"` // base64
+}
+
+type geminiGenerationConfig struct {
+	MaxOutputTokens    int64                 `json:"maxOutputTokens,omitempty"`
+	ThinkingConfig     *geminiThinkingConfig `json:"thinkingConfig,omitempty"`
+	ResponseMimeType   string                `json:"responseMimeType,omitempty"`
+	ResponseJSONSchema map[string]any        `json:"responseJsonSchema,omitempty"`
+}
+
+type geminiThinkingConfig struct {
+	ThinkingBudget  int64 `json:"thinkingBudget"`
+	IncludeThoughts bool  `json:"includeThoughts"`
+}
+
+type geminiChunk struct {
+	Candidates []struct {
+		Content struct {
+			Parts []geminiPart `json:"parts"`
+		} `json:"content"`
+		FinishReason string `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata *geminiUsage `json:"usageMetadata"`
+	Error         *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+type geminiUsage struct {
+	PromptTokenCount        int64 `json:"promptTokenCount"`
+	CandidatesTokenCount    int64 `json:"candidatesTokenCount"`
+	ThoughtsTokenCount      int64 `json:"thoughtsTokenCount"`
+	CachedContentTokenCount int64 `json:"cachedContentTokenCount"`
+}
+
+// usage bills thinking tokens as output, as Gemini does.
+func (gu *geminiUsage) usage() model.Usage {
+	if gu == nil {
+		return model.Usage{}
+	}
+	cached := gu.CachedContentTokenCount
+	return model.Usage{InputTokens: gu.PromptTokenCount - cached, OutputTokens: gu.CandidatesTokenCount + gu.ThoughtsTokenCount, CachedTokens: cached}
+}
+
+// NewGeminiProvider creates a provider for modelName. Empty arguments fall
+// back to the public endpoint and DefaultGeminiModel.
+func NewGeminiProvider(baseURL, modelName string) *GeminiProvider {
+	if baseURL == "" {
+		baseURL = DefaultGeminiBaseURL
+	}
+	if modelName == "" {
+		modelName = DefaultGeminiModel
+	}
+	return &GeminiProvider{
+		client:  &http.Client{},
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  os.Getenv("GEMINI_API_KEY"),
+		model:   modelName,
+		session: newSession(),
+	}
+}
+
+func (p *GeminiProvider) ModelName() string {
+	return p.model
+}
+
+// toGemini converts neutral history to Gemini contents.
+func toGemini(messages []model.Message) []geminiContent {
+	out := make([]geminiContent, 0, len(messages))
+	for _, m := range messages {
+		out = append(out, geminiContentOf(m))
+	}
+	return out
+}
+
+func geminiContentOf(m model.Message) geminiContent {
+	if m.Role == model.RoleAssistant {
+		return geminiContent{Role: "model", Parts: []geminiPart{{Text: m.Text()}}}
+	}
+	parts := make([]geminiPart, 0, len(m.Parts))
+	for _, part := range m.Parts {
+		parts = append(parts, geminiPartOf(part))
+	}
+	return geminiContent{Role: "user", Parts: parts}
+}
+
+func geminiPartOf(part model.Part) geminiPart {
+	if part.Image == nil {
+		return geminiPart{Text: part.Text}
+	}
+	return geminiPart{InlineData: &geminiInlineData{MimeType: "image/jpeg", Data: base64.StdEncoding.EncodeToString(part.Image)}}
+}
+
+// streamGenerate streams one generateContent call. Thought summaries,
+// returned when thinking is enabled, go to onReasoning (may be nil).
+func (p *GeminiProvider) streamGenerate(ctx context.Context, gr geminiRequest, onDelta, onReasoning func(string)) (string, model.Usage, error) {
+	body, err := json.Marshal(gr)
+	if err != nil {
+		return "", model.Usage{}, fmt.Errorf("encode request: %w", err)
+	}
+	endpoint := p.baseURL + "/models/" + url.PathEscape(p.model) + ":streamGenerateContent?alt=sse"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", model.Usage{}, fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("x-goog-api-key", p.apiKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", model.Usage{}, fmt.Errorf("api call failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return "", model.Usage{}, fmt.Errorf("api call failed: %w", &statusError{Status: resp.StatusCode, Header: resp.Header, Body: strings.TrimSpace(string(b))})
+	}
+
+	var buf strings.Builder
+	var u model.Usage
+	err = readSSE(resp.Body, func(data []byte) error {
+		return geminiChunkEvent(data, &buf, &u, onDelta, onReasoning)
+	})
+	if err != nil {
+		return buf.String(), u, fmt.Errorf("api call failed: %w", err)
+	}
+	return buf.String(), u, nil
+}
+
+// geminiChunkEvent applies one SSE payload: answer text is appended to buf
+// and forwarded, thoughts go to onReasoning, and the latest usage metadata
+// (cumulative in every chunk) replaces u. An error chunk is classified by
+// its HTTP code so withRetry can retry it.
+func geminiChunkEvent(data []byte, buf *strings.Builder, u *model.Usage, onDelta, onReasoning func(string)) error {
+	var chunk geminiChunk
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return fmt.Errorf("decode chunk: %w", err)
+	}
+	if e := chunk.Error; e != nil {
+		err := fmt.Errorf("%s: %s", e.Status, e.Message)
+		return &model.APIError{Kind: kindOf(err, e.Code), Status: e.Code, Err: err}
+	}
+	for _, c := range chunk.Candidates {
+		for _, part := range c.Content.Parts {
+			emitGeminiPart(part, buf, onDelta, onReasoning)
+		}
+	}
+	if chunk.UsageMetadata != nil {
+		*u = chunk.UsageMetadata.usage()
+	}
+	return nil
+}
+
+func emitGeminiPart(part geminiPart, buf *strings.Builder, onDelta, onReasoning func(string)) {
+	if !part.Thought {
+		emitDelta(buf, part.Text, onDelta)
+		return
+	}
+	if part.Text != "" && onReasoning != nil {
+		onReasoning(part.Text)
+	}
+}
+
+// stream maps a thinking budget to Gemini's thinkingConfig, on models that
+// think, and constrains structured Solve calls to the answer schema.
+// Without a budget the model thinks as much as it likes, so no output
+// limit is set unless the call is routed with one: thinking tokens count
+// against it.
+func (p *GeminiProvider) stream(ctx context.Context, messages []model.Message, onDelta func(string)) (string, model.Usage, error) {
+	if err := toolsUnsupported(p.ModelName()); err != nil {
+		return "", model.Usage{}, err
+	}
+	gc := &geminiGenerationConfig{MaxOutputTokens: model.MaxTokensFrom(ctx, 0)}
+	if budget := model.ThinkingFrom(ctx).BudgetTokens; budget > 0 && geminiThinks(p.model) {
+		gc.ThinkingConfig = &geminiThinkingConfig{ThinkingBudget: budget, IncludeThoughts: true}
+		gc.MaxOutputTokens = model.MaxTokensFrom(ctx, 4096) + budget
+	}
+	if wantsAnswerSchema(ctx) {
+		gc.ResponseMimeType = "application/json"
+		gc.ResponseJSONSchema = answerSchema()
+	}
+	gr := geminiRequest{Contents: toGemini(messages), GenerationConfig: gc}
+	return p.streamGenerate(ctx, gr, onDelta, model.StreamHooksFrom(ctx).ReasoningDelta)
+}
+
+func (p *GeminiProvider) Solve(ctx context.Context, images [][]byte, transcript string, onDelta func(string)) (string, error) {
+	lang, roots, conv := p.snapshot()
+	ctxParts, hasContext := contextParts(ctx, conv, roots, transcript, images)
+	ctx, user := solveTurn(ctx, images, ctxParts, hasContext, transcript, lang)
+	return exchange(ctx, conv, p.ModelName(), user, p.stream, p.Summarize, onDelta)
+}
+
+func (p *GeminiProvider) FollowUp(ctx context.Context, text string, onDelta func(string)) (string, error) {
+	_, roots, conv := p.snapshot()
+	ctxParts, _ := followUpContextParts(ctx, conv, roots, text)
+	return exchange(ctx, conv, p.ModelName(), followUpMessage(ctxParts, text), p.stream, p.Summarize, onDelta)
+}
+
+func (p *GeminiProvider) Action(ctx context.Context, a model.HotkeyAction, images [][]byte, transcript string, onDelta func(string)) (string, error) {
+	_, roots, conv := p.snapshot()
+	user, err := actionMessage(ctx, conv, roots, a, images, transcript)
+	if err != nil {
+		return "", err
+	}
+	return exchange(ctx, conv, p.ModelName(), user, p.stream, p.Summarize, onDelta)
+}
+
+func (p *GeminiProvider) Reply(ctx context.Context, history []model.Message, user model.Message, onDelta func(string)) (string, error) {
+	return reply(ctx, p.ModelName(), history, user, p.stream, onDelta)
+}
+
+// Summarize thinks as little as the model allows; see
+// geminiSummaryThinking.
+func (p *GeminiProvider) Summarize(ctx context.Context, text string) (string, error) {
+	gc := &geminiGenerationConfig{MaxOutputTokens: model.MaxTokensFrom(ctx, 2048), ThinkingConfig: geminiSummaryThinking(p.model)}
+	if gc.ThinkingConfig != nil {
+		gc.MaxOutputTokens += gc.ThinkingConfig.ThinkingBudget
+	}
+	gr := geminiRequest{Contents: toGemini([]model.Message{textMessage(text)}), GenerationConfig: gc}
+	result, err := oneShot(ctx, p.ModelName(), func(func(string)) (string, model.Usage, error) {
+		return p.streamGenerate(ctx, gr, nil, nil)
+	})
+	if err != nil {
+		return "", fmt.Errorf("summarize failed: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,133 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"second-nature/internal/model"
+)
+
+// geminiRecording is a streamGenerateContent?alt=sse response in the shape
+// gemini-2.5-flash sends with includeThoughts, cut down to a few chunks.
+// Gemini ends lines with CRLF and sends cumulative usage in every chunk.
+var geminiRecording = []string{
+	`{"candidates": [{"content": {"parts": [{"text": "**Weighing the approach**\n\nA hash map gives O(n).","thought": true}],"role": "model"},"index": 0}],"usageMetadata": {"promptTokenCount": 41,"totalTokenCount": 41},"modelVersion": "gemini-2.5-flash","responseId": "k3nTaL2uN5uvz7IPmYyS0Q0"}`,
+	`{"candidates": [{"content": {"parts": [{"text": "Use a hash map"}],"role": "model"},"index": 0}],"usageMetadata": {"promptTokenCount": 41,"candidatesTokenCount": 4,"totalTokenCount": 310,"thoughtsTokenCount": 265},"modelVersion": "gemini-2.5-flash","responseId": "k3nTaL2uN5uvz7IPmYyS0Q0"}`,
+	`{"candidates": [{"content": {"parts": [{"text": " from value to index."}],"role": "model"},"finishReason": "STOP","index": 0}],"usageMetadata": {"promptTokenCount": 41,"candidatesTokenCount": 10,"totalTokenCount": 316,"cachedContentTokenCount": 16,"thoughtsTokenCount": 265},"modelVersion": "gemini-2.5-flash","responseId": "k3nTaL2uN5uvz7IPmYyS0Q0"}`,
+}
+
+// geminiServer serves payloads as Gemini's SSE stream. With drop set, it
+// cuts the connection after them instead of ending the response.
+func geminiServer(t *testing.T, modelName string, payloads []string, drop bool, onRequest func(geminiRequest)) *GeminiProvider {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if want := "/v1beta/models/" + modelName + ":streamGenerateContent"; r.URL.Path != want || r.URL.Query().Get("alt") != "sse" {
+			t.Errorf("request %s, want %s?alt=sse", r.URL, want)
+		}
+		var gr geminiRequest
+		if err := json.NewDecoder(r.Body).Decode(&gr); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		if onRequest != nil {
+			onRequest(gr)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, p := range payloads {
+			fmt.Fprintf(w, "data: %s\r\n\r\n", p)
+			w.(http.Flusher).Flush()
+		}
+		if drop {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return NewGeminiProvider(srv.URL+"/v1beta", modelName)
+}
+
+func TestGeminiStream(t *testing.T) {
+	p := geminiServer(t, "gemini-2.5-flash", geminiRecording, false, nil)
+	var deltas, thoughts []string
+	text, u, err := p.streamGenerate(context.Background(), geminiRequest{}, func(d string) { deltas = append(deltas, d) }, func(d string) { thoughts = append(thoughts, d) })
+	if err != nil {
+		t.Fatalf("streamGenerate: %v", err)
+	}
+	if text != "Use a hash map from value to index." || strings.Join(deltas, "|") != "Use a hash map| from value to index." {
+		t.Errorf("text %q, deltas %q", text, deltas)
+	}
+	if len(thoughts) != 1 || !strings.HasPrefix(thoughts[0], "**Weighing") {
+		t.Errorf("thoughts = %q", thoughts)
+	}
+	want := model.Usage{InputTokens: 25, CachedTokens: 16, OutputTokens: 10 + 265}
+	if u != want {
+		t.Errorf("usage = %+v, want %+v (last chunk's, thoughts billed as output)", u, want)
+	}
+}
+
+func TestGeminiStreamDropped(t *testing.T) {
+	p := geminiServer(t, "gemini-2.5-flash", geminiRecording[:2], true, nil)
+	text, u, err := p.streamGenerate(context.Background(), geminiRequest{}, nil, nil)
+	if err == nil {
+		t.Fatal("dropped stream returned no error")
+	}
+	if kind := classify(err).Kind; kind != model.ErrKindNetwork {
+		t.Errorf("err %v classified as %v, want a retryable network error", err, kind)
+	}
+	if text != "Use a hash map" || u.OutputTokens != 4+265 {
+		t.Errorf("partial text %q usage %+v, want what arrived before the drop", text, u)
+	}
+}
+
+func TestGeminiStreamError(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    model.ErrorKind
+	}{
+		{`{"error": {"code": 503,"message": "The model is overloaded. Please try again later.","status": "UNAVAILABLE"}}`, model.ErrKindOverloaded},
+		{`{"error": {"code": 429,"message": "Resource has been exhausted (e.g. check quota).","status": "RESOURCE_EXHAUSTED"}}`, model.ErrKindRateLimited},
+	}
+	for _, tt := range tests {
+		p := geminiServer(t, "gemini-2.5-flash", []string{geminiRecording[1], tt.payload}, false, nil)
+		_, _, err := p.streamGenerate(context.Background(), geminiRequest{}, nil, nil)
+		if err == nil {
+			t.Fatalf("%s: no error", tt.payload)
+		}
+		if apiErr := classify(err); apiErr.Kind != tt.want || !apiErr.Retryable() {
+			t.Errorf("err = %v classified as %v, want a retryable %v", err, apiErr.Kind, tt.want)
+		}
+	}
+}
+
+func TestGeminiSummarizeThinking(t *testing.T) {
+	tests := []struct {
+		model  string
+		budget int64 // -1: no thinkingConfig
+	}{
+		{"gemini-2.5-pro", geminiMinThinking},
+		{"gemini-2.5-flash", 0},
+		{"gemini-2.5-flash-lite", 0},
+		{"gemini-2.0-flash", -1},
+		{"gemini-1.5-pro", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			var sent *geminiGenerationConfig
+			p := geminiServer(t, tt.model, geminiRecording[1:], false, func(gr geminiRequest) { sent = gr.GenerationConfig })
+			if _, err := p.Summarize(context.Background(), "text"); err != nil {
+				t.Fatalf("Summarize: %v", err)
+			}
+			budget := int64(-1)
+			if sent.ThinkingConfig != nil {
+				budget = sent.ThinkingConfig.ThinkingBudget
+			}
+			if budget != tt.budget || sent.MaxOutputTokens != 2048+max(tt.budget, 0) {
+				t.Errorf("thinking budget %d max tokens %d, want %d and %d", budget, sent.MaxOutputTokens, tt.budget, 2048+max(tt.budget, 0))
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"second-nature/internal/applog"
+	appctx "second-nature/internal/context"
+	"second-nature/internal/model"
+)
+
+// LanguageAuto selects automatic language detection.
+const LanguageAuto = "auto"
+
+// DefaultLanguage answers when nothing else points at a language.
+const DefaultLanguage = "Python"
+
+// DefaultLanguageFile is where per-directory language choices are kept
+// when AppConfig.LanguageFile is empty.
+const DefaultLanguageFile = "languages.json"
+
+// Where a language choice came from.
+const (
+	SourceOverride    = "override"
+	SourceFiles       = "files"
+	SourceAnswers     = "answers"
+	SourceScreenshots = "screenshots"
+	SourceDefault     = "default"
+)
+
+// LanguageNames lists the languages offered for a manual override.
+var LanguageNames = []string{"Python", "Go", "JavaScript", "TypeScript", "Java", "C++", "C", "C#", "Rust", "Kotlin", "Swift", "Ruby", "PHP", "Scala", "SQL", "Bash"}
+
+var extLanguages = map[string]string{
+	".py": "Python", ".go": "Go", ".js": "JavaScript", ".jsx": "JavaScript", ".mjs": "JavaScript",
+	".ts": "TypeScript", ".tsx": "TypeScript", ".java": "Java", ".cpp": "C++", ".cc": "C++",
+	".cxx": "C++", ".hpp": "C++", ".c": "C", ".cs": "C#", ".rs": "Rust", ".kt": "Kotlin",
+	".swift": "Swift", ".rb": "Ruby", ".php": "PHP", ".scala": "Scala", ".sql": "SQL", ".sh": "Bash",
+}
+
+// fenceLanguages maps code-fence tags (and classifier replies) to names.
+var fenceLanguages = map[string]string{
+	"python": "Python", "py": "Python", "go": "Go", "golang": "Go", "javascript": "JavaScript",
+	"js": "JavaScript", "jsx": "JavaScript", "typescript": "TypeScript", "ts": "TypeScript",
+	"tsx": "TypeScript", "java": "Java", "cpp": "C++", "c++": "C++", "c": "C", "csharp": "C#",
+	"c#": "C#", "cs": "C#", "rust": "Rust", "rs": "Rust", "kotlin": "Kotlin", "kt": "Kotlin",
+	"swift": "Swift", "ruby": "Ruby", "rb": "Ruby", "php": "PHP", "scala": "Scala", "sql": "SQL",
+	"bash": "Bash", "sh": "Bash", "shell": "Bash",
+}
+
+const classifyPrompt = "Which programming language is the code in these screenshots written in? Reply with only the language name, or \"none\" if no code is visible."
+
+var fenceTag = regexp.MustCompile("(?m)^```([A-Za-z0-9+#]+)")
+
+// LanguageChoice is the language a Solve answers in and how it was chosen.
+type LanguageChoice struct {
+	Lang   string
+	Source string
+}
+
+// LanguageSet resolves the answer language in auto mode and remembers
+// manual overrides per context directory across sessions.
+type LanguageSet struct {
+	mu        sync.Mutex
+	path      string
+	overrides map[string]string // context dir → language
+}
+
+// Languages is the session's language memory.
+var Languages = &LanguageSet{overrides: map[string]string{}}
+
+// Load reads the saved overrides from path. A missing file is not an error.
+func (s *LanguageSet) Load(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	if err := json.Unmarshal(b, &s.overrides); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// Override pins dir to lang, or returns it to detection for LanguageAuto,
+// and saves the choice.
+func (s *LanguageSet) Override(dir, lang string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := languageKey(dir)
+	s.overrides[key] = lang
+	if lang == LanguageAuto || lang == "" {
+		delete(s.overrides, key)
+	}
+	if s.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(s.overrides, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, b, 0o644)
+}
+
+func (s *LanguageSet) override(dir string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.overrides[languageKey(dir)]
+}
+
+// PrimaryRoot is the context root a language override is saved under: the
+// first one, or "" when there are none.
+func PrimaryRoot(roots []string) string {
+	if len(roots) == 0 {
+		return ""
+	}
+	return roots[0]
+}
+
+// languageKey makes relative and absolute forms of a directory match.
+func languageKey(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return dir
+	}
+	return abs
+}
+
+// Resolve picks the language for the next Solve on p: a saved override for
+// its first context root, else the dominant source-file extension across
+// its roots, else the fences of recent answers, else a short
+// classification call on images.
+func (s *LanguageSet) Resolve(ctx context.Context, p model.Provider, images [][]byte) LanguageChoice {
+	roots := p.ContextRoots()
+	if lang := s.override(PrimaryRoot(roots)); lang != "" {
+		return LanguageChoice{lang, SourceOverride}
+	}
+	if lang := languageOfFiles(appctx.ListContextFiles(roots)); lang != "" {
+		return LanguageChoice{lang, SourceFiles}
+	}
+	if lang := languageOfAnswers(p.Conversation().Messages()); lang != "" {
+		return LanguageChoice{lang, SourceAnswers}
+	}
+	if lang := classifyLanguage(ctx, p, images); lang != "" {
+		return LanguageChoice{lang, SourceScreenshots}
+	}
+	return LanguageChoice{DefaultLanguage, SourceDefault}
+}
+
+// languageOfFiles returns the language with the most files by extension.
+func languageOfFiles(files []string) string {
+	counts := map[string]int{}
+	for _, f := range files {
+		if lang := extLanguages[strings.ToLower(filepath.Ext(f))]; lang != "" {
+			counts[lang]++
+		}
+	}
+	return mostCommon(counts)
+}
+
+// languageOfAnswers returns the most used code-fence language of the
+// newest answer that has any.
+func languageOfAnswers(messages []model.Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if lang := fenceLanguage(messages[i]); lang != "" {
+			return lang
+		}
+	}
+	return ""
+}
+
+func fenceLanguage(m model.Message) string {
+	if m.Role != model.RoleAssistant {
+		return ""
+	}
+	counts := map[string]int{}
+	for _, tag := range fenceTag.FindAllStringSubmatch(m.Text(), -1) {
+		if lang := fenceLanguages[strings.ToLower(tag[1])]; lang != "" {
+			counts[lang]++
+		}
+	}
+	return mostCommon(counts)
+}
+
+// classifyLanguage asks the model which language the screenshots show.
+// The call is stateless and runs without thinking.
+func classifyLanguage(ctx context.Context, p model.Provider, images [][]byte) string {
+	if len(images) == 0 {
+		return ""
+	}
+	user := solveMessage(images, nil, classifyPrompt, "", "")
+	answer, err := p.Reply(model.WithThinking(ctx, model.Thinking{}), nil, user, nil)
+	if err != nil {
+		applog.AppLog.Warn("language: classification failed: %v", err)
+		return ""
+	}
+	word := strings.ToLower(strings.Trim(strings.TrimSpace(answer), ".`*\"'"))
+	applog.AppLog.Info("language: screenshots classified as %q", word)
+	return fenceLanguages[word]
+}
+
+// mostCommon returns the key with the highest count, ties broken by name.
+func mostCommon(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) == 0 {
+		return ""
+	}
+	return keys[0]
+}
@@ -0,0 +1,271 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"second-nature/internal/model"
+	"second-nature/internal/usage"
+)
+
+const (
+	DefaultLocalBaseURL = "http://localhost:8080/v1"
+	DefaultLocalModel   = "local"
+)
+
+// LocalProvider talks to any server exposing the OpenAI-compatible
+// /v1/chat/completions streaming endpoint (llama.cpp, Ollama, vLLM).
+type LocalProvider struct {
+	client  *http.Client
+	baseURL string
+	apiKey  string
+	model   string
+	session
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"` // string or []chatPart
+}
+
+type chatPart struct {
+	Type     string        `json:"type"`
+	Text     string        `json:"text,omitempty"`
+	ImageURL *chatImageURL `json:"image_url,omitempty"`
+}
+
+type chatImageURL struct {
+	URL string `json:"url"`
+}
+
+type chatRequest struct {
+	Model           string              `json:"model"`
+	Messages        []chatMessage       `json:"messages"`
+	MaxTokens       int64               `json:"max_tokens"`
+	Stream          bool                `json:"stream"`
+	StreamOptions   *chatStreamOptions  `json:"stream_options,omitempty"`
+	ReasoningEffort string              `json:"reasoning_effort,omitempty"`
+	ResponseFormat  *chatResponseFormat `json:"response_format,omitempty"`
+}
+
+type chatResponseFormat struct {
+	Type       string         `json:"type"` // "json_schema"
+	JSONSchema chatJSONSchema `json:"json_schema"`
+}
+
+type chatJSONSchema struct {
+	Name   string         `json:"name"`
+	Schema map[string]any `json:"schema"`
+	Strict bool           `json:"strict"`
+}
+
+type chatStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type chatChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content          string `json:"content"`
+			ReasoningContent string `json:"reasoning_content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *chatUsage `json:"usage"`
+}
+
+type chatUsage struct {
+	PromptTokens        int64 `json:"prompt_tokens"`
+	CompletionTokens    int64 `json:"completion_tokens"`
+	PromptTokensDetails struct {
+		CachedTokens int64 `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+func (cu *chatUsage) usage() model.Usage {
+	if cu == nil {
+		return model.Usage{}
+	}
+	cached := cu.PromptTokensDetails.CachedTokens
+	return model.Usage{InputTokens: cu.PromptTokens - cached, OutputTokens: cu.CompletionTokens, CachedTokens: cached}
+}
+
+// NewLocalProvider creates a provider for baseURL (e.g. http://localhost:11434/v1).
+// Empty arguments fall back to the llama.cpp server defaults. LOCAL_API_KEY,
+// if set, is sent as a bearer token.
+func NewLocalProvider(baseURL, modelName string) *LocalProvider {
+	if baseURL == "" {
+		baseURL = DefaultLocalBaseURL
+	}
+	if modelName == "" {
+		modelName = DefaultLocalModel
+	}
+	usage.Session.PriceAs(modelName, model.ProviderLocal)
+	return &LocalProvider{
+		client:  &http.Client{},
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  os.Getenv("LOCAL_API_KEY"),
+		model:   modelName,
+		session: newSession(),
+	}
+}
+
+func (p *LocalProvider) ModelName() string {
+	return p.model
+}
+
+// toChatMessages converts neutral history to chat-completions messages.
+func toChatMessages(messages []model.Message) []chatMessage {
+	out := make([]chatMessage, 0, len(messages))
+	for _, m := range messages {
+		out = append(out, chatMessageOf(m))
+	}
+	return out
+}
+
+func chatMessageOf(m model.Message) chatMessage {
+	if m.Role == model.RoleAssistant {
+		return chatMessage{Role: "assistant", Content: m.Text()}
+	}
+	// Plain string content for text-only turns keeps text-only servers happy.
+	if len(m.Parts) == 1 && m.Parts[0].Image == nil {
+		return chatMessage{Role: "user", Content: m.Parts[0].Text}
+	}
+	parts := make([]chatPart, 0, len(m.Parts))
+	for _, part := range m.Parts {
+		parts = append(parts, chatPartOf(part))
+	}
+	return chatMessage{Role: "user", Content: parts}
+}
+
+func chatPartOf(part model.Part) chatPart {
+	if part.Image == nil {
+		return chatPart{Type: "text", Text: part.Text}
+	}
+	return chatPart{
+		Type:     "image_url",
+		ImageURL: &chatImageURL{URL: "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(part.Image)},
+	}
+}
+
+// streamChat streams one chat completion for cr, filling in the model and
+// streaming options. Servers that expose a model's thinking send it as
+// reasoning_content deltas, forwarded to onReasoning (may be nil).
+func (p *LocalProvider) streamChat(ctx context.Context, cr chatRequest, onDelta, onReasoning func(string)) (string, model.Usage, error) {
+	cr.Model = p.model
+	cr.Stream = true
+	cr.StreamOptions = &chatStreamOptions{IncludeUsage: true}
+	body, err := json.Marshal(cr)
+	if err != nil {
+		return "", model.Usage{}, fmt.Errorf("encode request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", model.Usage{}, fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", model.Usage{}, fmt.Errorf("api call failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return "", model.Usage{}, fmt.Errorf("api call failed: %w", &statusError{Status: resp.StatusCode, Header: resp.Header, Body: strings.TrimSpace(string(b))})
+	}
+
+	var buf strings.Builder
+	var u model.Usage
+	err = readSSE(resp.Body, func(data []byte) error {
+		var chunk chatChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("decode chunk: %w", err)
+		}
+		for _, c := range chunk.Choices {
+			emitDelta(&buf, c.Delta.Content, onDelta)
+			if c.Delta.ReasoningContent != "" && onReasoning != nil {
+				onReasoning(c.Delta.ReasoningContent)
+			}
+		}
+		if chunk.Usage != nil {
+			u = chunk.Usage.usage()
+		}
+		return nil
+	})
+	if err != nil {
+		return buf.String(), u, fmt.Errorf("api call failed: %w", err)
+	}
+	return buf.String(), u, nil
+}
+
+func emitDelta(buf *strings.Builder, delta string, onDelta func(string)) {
+	if delta == "" {
+		return
+	}
+	buf.WriteString(delta)
+	if onDelta != nil {
+		onDelta(delta)
+	}
+}
+
+// stream asks servers that support it (llama.cpp, vLLM, Ollama) for the
+// answer schema on structured Solve calls. It has no tool loop.
+func (p *LocalProvider) stream(ctx context.Context, messages []model.Message, onDelta func(string)) (string, model.Usage, error) {
+	if err := toolsUnsupported(p.ModelName()); err != nil {
+		return "", model.Usage{}, err
+	}
+	cr := chatRequest{Messages: toChatMessages(messages), MaxTokens: model.MaxTokensFrom(ctx, 4096), ReasoningEffort: model.ThinkingFrom(ctx).Effort}
+	if wantsAnswerSchema(ctx) {
+		cr.ResponseFormat = &chatResponseFormat{Type: "json_schema", JSONSchema: chatJSONSchema{Name: answerSchemaName, Schema: answerSchema(), Strict: true}}
+	}
+	return p.streamChat(ctx, cr, onDelta, model.StreamHooksFrom(ctx).ReasoningDelta)
+}
+
+func (p *LocalProvider) Solve(ctx context.Context, images [][]byte, transcript string, onDelta func(string)) (string, error) {
+	lang, roots, conv := p.snapshot()
+	ctxParts, hasContext := contextParts(ctx, conv, roots, transcript, images)
+	ctx, user := solveTurn(ctx, images, ctxParts, hasContext, transcript, lang)
+	return exchange(ctx, conv, p.ModelName(), user, p.stream, p.Summarize, onDelta)
+}
+
+func (p *LocalProvider) FollowUp(ctx context.Context, text string, onDelta func(string)) (string, error) {
+	_, roots, conv := p.snapshot()
+	ctxParts, _ := followUpContextParts(ctx, conv, roots, text)
+	return exchange(ctx, conv, p.ModelName(), followUpMessage(ctxParts, text), p.stream, p.Summarize, onDelta)
+}
+
+func (p *LocalProvider) Action(ctx context.Context, a model.HotkeyAction, images [][]byte, transcript string, onDelta func(string)) (string, error) {
+	_, roots, conv := p.snapshot()
+	user, err := actionMessage(ctx, conv, roots, a, images, transcript)
+	if err != nil {
+		return "", err
+	}
+	return exchange(ctx, conv, p.ModelName(), user, p.stream, p.Summarize, onDelta)
+}
+
+func (p *LocalProvider) Reply(ctx context.Context, history []model.Message, user model.Message, onDelta func(string)) (string, error) {
+	return reply(ctx, p.ModelName(), history, user, p.stream, onDelta)
+}
+
+func (p *LocalProvider) Summarize(ctx context.Context, text string) (string, error) {
+	cr := chatRequest{Messages: toChatMessages([]model.Message{textMessage(text)}), MaxTokens: model.MaxTokensFrom(ctx, 2048)}
+	result, err := oneShot(ctx, p.ModelName(), func(func(string)) (string, model.Usage, error) {
+		return p.streamChat(ctx, cr, nil, nil)
+	})
+	if err != nil {
+		return "", fmt.Errorf("summarize failed: %w", err)
+	}
+	return result, nil
+}
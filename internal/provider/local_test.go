@@ -0,0 +1,140 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"second-nature/internal/model"
+)
+
+// localServer stands in for an OpenAI-compatible server. handle sees each
+// decoded request; the number of requests served is returned alongside.
+func localServer(t *testing.T, handle func(w http.ResponseWriter, cr chatRequest)) (*LocalProvider, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("path = %s, want /v1/chat/completions", r.URL.Path)
+		}
+		var cr chatRequest
+		if err := json.NewDecoder(r.Body).Decode(&cr); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		handle(w, cr)
+	}))
+	t.Cleanup(srv.Close)
+	return NewLocalProvider(srv.URL+"/v1", "qwen2.5-coder"), &calls
+}
+
+// writeSSE writes each payload as one "data:" event and flushes it.
+func writeSSE(w http.ResponseWriter, payloads ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, p := range payloads {
+		fmt.Fprintf(w, "data: %s\n\n", p)
+		w.(http.Flusher).Flush()
+	}
+}
+
+func TestLocalStreamsDeltas(t *testing.T) {
+	p, _ := localServer(t, func(w http.ResponseWriter, cr chatRequest) {
+		if cr.Model != "qwen2.5-coder" || !cr.Stream || cr.StreamOptions == nil || !cr.StreamOptions.IncludeUsage {
+			t.Errorf("request = model %q stream %v options %+v", cr.Model, cr.Stream, cr.StreamOptions)
+		}
+		writeSSE(w,
+			`{"choices":[{"delta":{"role":"assistant"}}]}`,
+			`{"choices":[{"delta":{"reasoning_content":"hmm"}}]}`,
+			`{"choices":[{"delta":{"content":"Hello"}}]}`,
+			`{"choices":[{"delta":{"content":", world"}}]}`,
+			`{"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":3,"prompt_tokens_details":{"cached_tokens":2}}}`,
+			`[DONE]`)
+	})
+	var deltas, reasoning []string
+	var u model.Usage
+	ctx := model.WithStreamHooks(context.Background(), model.StreamHooks{
+		Reasoning: func(d string) { reasoning = append(reasoning, d) },
+		Usage:     func(got model.Usage) { u = got },
+	})
+	answer, err := p.FollowUp(ctx, "hi", func(d string) { deltas = append(deltas, d) })
+	if err != nil {
+		t.Fatalf("FollowUp: %v", err)
+	}
+	if answer != "Hello, world" {
+		t.Errorf("answer = %q", answer)
+	}
+	if strings.Join(deltas, "|") != "Hello|, world" {
+		t.Errorf("deltas = %q", deltas)
+	}
+	if strings.Join(reasoning, "") != "hmm" {
+		t.Errorf("reasoning = %q", reasoning)
+	}
+	if u.InputTokens != 10 || u.CachedTokens != 2 || u.OutputTokens != 3 {
+		t.Errorf("usage = %+v", u)
+	}
+	if n := p.HistoryLen(); n != 2 {
+		t.Errorf("history has %d messages, want the user turn and the answer", n)
+	}
+}
+
+func TestLocalStopsAtDone(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	p, _ := localServer(t, func(w http.ResponseWriter, cr chatRequest) {
+		writeSSE(w, `{"choices":[{"delta":{"content":"done"}}]}`, `[DONE]`, `{"choices":[{"delta":{"content":" and more"}}]}`)
+		// Keep the connection open: the client must not wait for EOF.
+		<-release
+	})
+	summary, err := p.Summarize(context.Background(), "text")
+	if err != nil {
+		t.Fatalf("Summarize: %v", err)
+	}
+	if summary != "done" {
+		t.Errorf("summary = %q, want the text before [DONE] only", summary)
+	}
+}
+
+func TestLocalErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		kind   model.ErrorKind
+	}{
+		{"bad request", http.StatusBadRequest, `{"error":{"message":"max_tokens must be positive"}}`, model.ErrKindUnknown},
+		{"model not found", http.StatusNotFound, `{"error":"model 'qwen2.5-coder' not found, try pulling it first"}`, model.ErrKindModelNotFound},
+		{"auth", http.StatusUnauthorized, `{"error":{"message":"invalid api key"}}`, model.ErrKindAuth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, calls := localServer(t, func(w http.ResponseWriter, cr chatRequest) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				fmt.Fprintln(w, tt.body)
+			})
+			_, err := p.FollowUp(context.Background(), "hi", nil)
+			var apiErr *model.APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("err = %v, want an APIError", err)
+			}
+			if apiErr.Kind != tt.kind || apiErr.Status != tt.status {
+				t.Errorf("kind %v status %d, want %v %d", apiErr.Kind, apiErr.Status, tt.kind, tt.status)
+			}
+			if !strings.Contains(err.Error(), strings.TrimSpace(tt.body)) {
+				t.Errorf("err = %q, want the response body in it", err)
+			}
+			if n := calls.Load(); n != 1 {
+				t.Errorf("%d requests, want no retries", n)
+			}
+			if n := p.HistoryLen(); n != 0 {
+				t.Errorf("history has %d messages, want the user turn rolled back", n)
+			}
+		})
+	}
+}
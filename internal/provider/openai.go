@@ -3,71 +3,39 @@ package provider
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"strings"
 
 	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
 	"github.com/openai/openai-go/packages/ssestream"
 	"github.com/openai/openai-go/responses"
 	"github.com/openai/openai-go/shared"
-	"github.com/openai/openai-go/shared/constant"
 
-	appctx "second-nature/internal/context"
+	"second-nature/internal/model"
 )
 
 type OpenAIProvider struct {
-	client     openai.Client
-	model      shared.ResponsesModel
-	lang       string
-	contextDir string
-	history    responses.ResponseInputParam
+	client openai.Client
+	model  shared.ResponsesModel
+	session
 }
 
-func NewOpenAIProvider(model shared.ResponsesModel) *OpenAIProvider {
-	return &OpenAIProvider{client: openai.NewClient(), model: model, lang: "Python"}
-}
-
-func (p *OpenAIProvider) SetLanguage(lang string) {
-	p.lang = lang
-}
-
-func (p *OpenAIProvider) SetContextDir(dir string) {
-	p.contextDir = dir
-}
-
-func (p *OpenAIProvider) ContextDir() string {
-	return p.contextDir
-}
-
-func (p *OpenAIProvider) ClearHistory() {
-	p.history = nil
+func NewOpenAIProvider(m shared.ResponsesModel) *OpenAIProvider {
+	return &OpenAIProvider{client: openai.NewClient(option.WithMaxRetries(0)), model: m, session: newSession()}
 }
 
 func (p *OpenAIProvider) ModelName() string {
 	return string(p.model)
 }
 
-func (p *OpenAIProvider) HistoryLen() int { return len(p.history) }
-
-func (p *OpenAIProvider) RemoveHistoryPair(userIndex int) {
-	if userIndex < 0 || userIndex+2 > len(p.history) {
-		return
-	}
-	p.history = append(p.history[:userIndex], p.history[userIndex+2:]...)
-}
-
-func firstOutputMessageID(output []responses.ResponseOutputItemUnion) string {
-	for _, item := range output {
-		if item.Type == "message" {
-			return item.ID
-		}
-	}
-	return ""
-}
-
-func streamResponses(stream *ssestream.Stream[responses.ResponseStreamEventUnion], onDelta func(string)) (string, string, error) {
+// streamResponses collects output text and forwards reasoning summary
+// deltas to onReasoning. If done is non-nil the completed response, with
+// any function calls, is stored in it. done and onReasoning may be nil.
+func streamResponses(stream *ssestream.Stream[responses.ResponseStreamEventUnion], done *responses.Response, onDelta, onReasoning func(string)) (string, model.Usage, error) {
 	var buf strings.Builder
-	var messageID string
+	var u model.Usage
 	for stream.Next() {
 		evt := stream.Current()
 		if evt.Type == "response.output_text.delta" {
@@ -76,20 +44,58 @@ func streamResponses(stream *ssestream.Stream[responses.ResponseStreamEventUnion
 				onDelta(evt.Delta.OfString)
 			}
 		}
+		if evt.Type == "response.reasoning_summary_text.delta" && onReasoning != nil {
+			onReasoning(evt.Delta.OfString)
+		}
+		if evt.Type == "response.reasoning_summary_part.added" && evt.SummaryIndex > 0 && onReasoning != nil {
+			onReasoning("\n\n")
+		}
 		if evt.Type == "response.completed" {
-			messageID = firstOutputMessageID(evt.Response.Output)
+			u = responsesUsage(evt.Response.Usage)
+		}
+		if evt.Type == "response.completed" && done != nil {
+			*done = evt.Response
 		}
 	}
 	if err := stream.Err(); err != nil {
-		return "", "", fmt.Errorf("api call failed: %w", err)
+		return buf.String(), u, fmt.Errorf("api call failed: %w", err)
+	}
+	return buf.String(), u, nil
+}
+
+// responsesUsage normalizes usage; OpenAI counts cached tokens inside input.
+func responsesUsage(ru responses.ResponseUsage) model.Usage {
+	cached := ru.InputTokensDetails.CachedTokens
+	return model.Usage{InputTokens: ru.InputTokens - cached, OutputTokens: ru.OutputTokens, CachedTokens: cached}
+}
+
+// toResponsesInput converts neutral history to Responses API input items.
+func toResponsesInput(messages []model.Message) responses.ResponseInputParam {
+	out := make(responses.ResponseInputParam, 0, len(messages))
+	for _, m := range messages {
+		out = append(out, responsesItem(m))
 	}
-	return buf.String(), messageID, nil
+	return out
 }
 
-func (p *OpenAIProvider) buildUserItem(contentList responses.ResponseInputMessageContentListParam) responses.ResponseInputItemUnionParam {
+func responsesItem(m model.Message) responses.ResponseInputItemUnionParam {
+	if m.Role == model.RoleAssistant {
+		return responses.ResponseInputItemUnionParam{
+			OfMessage: &responses.EasyInputMessageParam{
+				Role: responses.EasyInputMessageRoleAssistant,
+				Content: responses.EasyInputMessageContentUnionParam{
+					OfString: openai.String(m.Text()),
+				},
+			},
+		}
+	}
+	var contentList responses.ResponseInputMessageContentListParam
+	for _, part := range m.Parts {
+		contentList = append(contentList, responsesContent(part))
+	}
 	return responses.ResponseInputItemUnionParam{
 		OfMessage: &responses.EasyInputMessageParam{
-			Role: "user",
+			Role: responses.EasyInputMessageRoleUser,
 			Content: responses.EasyInputMessageContentUnionParam{
 				OfInputItemContentList: contentList,
 			},
@@ -97,111 +103,128 @@ func (p *OpenAIProvider) buildUserItem(contentList responses.ResponseInputMessag
 	}
 }
 
-func (p *OpenAIProvider) buildAssistantItem(text, id string) responses.ResponseInputItemUnionParam {
-	return responses.ResponseInputItemUnionParam{
-		OfOutputMessage: &responses.ResponseOutputMessageParam{
-			ID: id,
-			Content: []responses.ResponseOutputMessageContentUnionParam{{
-				OfOutputText: &responses.ResponseOutputTextParam{
-					Text: text,
-					Type: constant.ValueOf[constant.OutputText](),
-				},
-			}},
-			Status: responses.ResponseOutputMessageStatusCompleted,
-			Role:   constant.ValueOf[constant.Assistant](),
-			Type:   constant.ValueOf[constant.Message](),
+func responsesContent(part model.Part) responses.ResponseInputContentUnionParam {
+	if part.Image == nil {
+		return responses.ResponseInputContentParamOfInputText(part.Text)
+	}
+	dataURL := "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(part.Image)
+	return responses.ResponseInputContentUnionParam{
+		OfInputImage: &responses.ResponseInputImageParam{
+			ImageURL: openai.String(dataURL),
+			Detail:   "high",
 		},
 	}
 }
 
-func (p *OpenAIProvider) sendHistory(maxTokens int64, onDelta func(string)) (string, string, error) {
-	params := responses.ResponseNewParams{
+func (p *OpenAIProvider) newParams(input responses.ResponseInputParam, maxTokens int64) responses.ResponseNewParams {
+	return responses.ResponseNewParams{
 		Model:           p.model,
 		MaxOutputTokens: openai.Int(maxTokens),
 		Input: responses.ResponseNewParamsInputUnion{
-			OfInputItemList: p.history,
+			OfInputItemList: input,
 		},
 	}
-	stream := p.client.Responses.NewStreaming(context.Background(), params)
-	return streamResponses(stream, onDelta)
 }
 
-func (p *OpenAIProvider) Solve(images [][]byte, transcript string, onDelta func(string)) (string, error) {
-	contentList := responses.ResponseInputMessageContentListParam{
-		responses.ResponseInputContentParamOfInputText(BuildSolvePrompt(p.lang, appctx.ReadContextPath(p.contextDir), transcript, len(images))),
-	}
-	for _, img := range images {
-		dataURL := "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(img)
-		contentList = append(contentList, responses.ResponseInputContentUnionParam{
-			OfInputImage: &responses.ResponseInputImageParam{
-				ImageURL: openai.String(dataURL),
-				Detail:   "high",
-			},
-		})
+// stream relies on OpenAI's automatic prefix caching; keeping the context
+// block in one early turn instead of every turn keeps that prefix stable.
+// A thinking effort requests reasoning with an auto summary, streamed to
+// the context's hooks. With tools enabled it runs the tool loop, chaining
+// rounds by previous_response_id so only the answer text reaches history.
+// Structured Solve calls request a strict JSON schema response.
+func (p *OpenAIProvider) stream(ctx context.Context, messages []model.Message, onDelta func(string)) (string, model.Usage, error) {
+	params := p.newParams(toResponsesInput(messages), model.MaxTokensFrom(ctx, 4096))
+	params.Tools = openaiTools(activeTools())
+	var onReasoning func(string)
+	if effort := model.ThinkingFrom(ctx).Effort; effort != "" {
+		params.Reasoning = shared.ReasoningParam{Effort: shared.ReasoningEffort(effort), Summary: shared.ReasoningSummaryAuto}
+		onReasoning = model.StreamHooksFrom(ctx).ReasoningDelta
+	}
+	if wantsAnswerSchema(ctx) {
+		format := responses.ResponseFormatTextConfigParamOfJSONSchema(answerSchemaName, answerSchema())
+		format.OfJSONSchema.Strict = openai.Bool(true)
+		params.Text = responses.ResponseTextConfigParam{Format: format}
+	}
+	answer := newAnswerStream(ctx, onDelta)
+	var total model.Usage
+	for iteration := 0; ; iteration++ {
+		if len(params.Tools) > 0 && toolsExhausted(iteration) {
+			params.ToolChoice = responses.ResponseNewParamsToolChoiceUnion{OfToolChoiceMode: openai.Opt(responses.ToolChoiceOptionsNone)}
+		}
+		var resp responses.Response
+		answer.nextRound()
+		_, u, err := streamResponses(p.client.Responses.NewStreaming(ctx, params), &resp, answer.delta, onReasoning)
+		logCache(p.ModelName(), u)
+		total = total.Add(u)
+		if err != nil {
+			return answer.buf.String(), total, roundErr(err, iteration)
+		}
+		outputs := p.runTools(ctx, resp)
+		if len(outputs) == 0 {
+			return answer.buf.String(), total, nil
+		}
+		params.PreviousResponseID = openai.String(resp.ID)
+		params.Input = responses.ResponseNewParamsInputUnion{OfInputItemList: outputs}
 	}
+}
 
-	userItem := p.buildUserItem(contentList)
-	p.history = append(p.history, userItem)
-
-	text, respID, err := p.sendHistory(4096, onDelta)
-	if err != nil {
-		p.history = p.history[:len(p.history)-1]
-		return "", err
+func openaiTools(tools []tool) []responses.ToolUnionParam {
+	var out []responses.ToolUnionParam
+	for _, t := range tools {
+		fn := responses.ToolParamOfFunction(t.name, t.jsonSchema(), false)
+		fn.OfFunction.Description = openai.String(t.description)
+		out = append(out, fn)
 	}
-	if text == "" {
-		p.history = p.history[:len(p.history)-1]
-		return "", fmt.Errorf("no text in response")
+	return out
+}
+
+// runTools executes the response's function calls and returns their
+// outputs as the next round's input.
+func (p *OpenAIProvider) runTools(ctx context.Context, resp responses.Response) responses.ResponseInputParam {
+	var outputs responses.ResponseInputParam
+	for _, item := range resp.Output {
+		if item.Type == "function_call" {
+			out, _ := runTool(ctx, p.ContextRoots(), item.CallID, item.Name, json.RawMessage(item.Arguments))
+			outputs = append(outputs, responses.ResponseInputItemParamOfFunctionCallOutput(item.CallID, out))
+		}
 	}
+	return outputs
+}
 
-	p.history = append(p.history, p.buildAssistantItem(text, respID))
-	return text, nil
+func (p *OpenAIProvider) Solve(ctx context.Context, images [][]byte, transcript string, onDelta func(string)) (string, error) {
+	lang, roots, conv := p.snapshot()
+	ctxParts, hasContext := contextParts(ctx, conv, roots, transcript, images)
+	ctx, user := solveTurn(ctx, images, ctxParts, hasContext, transcript, lang)
+	return exchange(ctx, conv, p.ModelName(), user, p.stream, p.Summarize, onDelta)
 }
 
-func (p *OpenAIProvider) Summarize(text string) (string, error) {
-	params := responses.ResponseNewParams{
-		Model:           p.model,
-		MaxOutputTokens: openai.Int(2048),
-		Input: responses.ResponseNewParamsInputUnion{
-			OfInputItemList: responses.ResponseInputParam{
-				{OfMessage: &responses.EasyInputMessageParam{
-					Role: "user",
-					Content: responses.EasyInputMessageContentUnionParam{
-						OfInputItemContentList: responses.ResponseInputMessageContentListParam{
-							responses.ResponseInputContentParamOfInputText(text),
-						},
-					},
-				}},
-			},
-		},
-	}
+func (p *OpenAIProvider) Reply(ctx context.Context, history []model.Message, user model.Message, onDelta func(string)) (string, error) {
+	return reply(ctx, p.ModelName(), history, user, p.stream, onDelta)
+}
 
-	stream := p.client.Responses.NewStreaming(context.Background(), params)
-	result, _, err := streamResponses(stream, nil)
+func (p *OpenAIProvider) Summarize(ctx context.Context, text string) (string, error) {
+	input := toResponsesInput([]model.Message{textMessage(text)})
+	result, err := oneShot(ctx, p.ModelName(), func(func(string)) (string, model.Usage, error) {
+		stream := p.client.Responses.NewStreaming(ctx, p.newParams(input, model.MaxTokensFrom(ctx, 2048)))
+		return streamResponses(stream, nil, nil, nil)
+	})
 	if err != nil {
 		return "", fmt.Errorf("summarize failed: %w", err)
 	}
 	return result, nil
 }
 
-func (p *OpenAIProvider) FollowUp(text string, onDelta func(string)) (string, error) {
-	msg := appctx.ReadContextPath(p.contextDir) + text
-	contentList := responses.ResponseInputMessageContentListParam{
-		responses.ResponseInputContentParamOfInputText(msg),
-	}
-
-	userItem := p.buildUserItem(contentList)
-	p.history = append(p.history, userItem)
+func (p *OpenAIProvider) FollowUp(ctx context.Context, text string, onDelta func(string)) (string, error) {
+	_, roots, conv := p.snapshot()
+	ctxParts, _ := followUpContextParts(ctx, conv, roots, text)
+	return exchange(ctx, conv, p.ModelName(), followUpMessage(ctxParts, text), p.stream, p.Summarize, onDelta)
+}
 
-	reply, respID, err := p.sendHistory(4096, onDelta)
+func (p *OpenAIProvider) Action(ctx context.Context, a model.HotkeyAction, images [][]byte, transcript string, onDelta func(string)) (string, error) {
+	_, roots, conv := p.snapshot()
+	user, err := actionMessage(ctx, conv, roots, a, images, transcript)
 	if err != nil {
-		p.history = p.history[:len(p.history)-1]
 		return "", err
 	}
-	if reply == "" {
-		p.history = p.history[:len(p.history)-1]
-		return "", fmt.Errorf("no text in response")
-	}
-
-	p.history = append(p.history, p.buildAssistantItem(reply, respID))
-	return reply, nil
+	return exchange(ctx, conv, p.ModelName(), user, p.stream, p.Summarize, onDelta)
 }
@@ -0,0 +1,205 @@
+package provider
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"second-nature/internal/applog"
+)
+
+// DefaultProfile is the built-in prompt (BuildSolvePrompt). It is always
+// available and cannot be overridden by a template file.
+const DefaultProfile = "interview-solve"
+
+// DefaultPromptDir is where profile templates are loaded from when
+// AppConfig.PromptDir is empty.
+const DefaultPromptDir = "prompts"
+
+// PromptData is what a profile template is executed with.
+type PromptData struct {
+	Lang       string // answer language, e.g. "Python"
+	HasContext bool   // a Source files block is in the conversation
+	Transcript string
+	ImageCount int
+	Receipt    string // the built-in context receipt instruction
+	CodeRules  string
+}
+
+// ProfileSet holds the named prompt profiles and which one is active.
+//
+// Profiles are *.tmpl files in the prompt directory, named by file name
+// without extension. A per-language variant "<name>.<lang>.tmpl" (lang is
+// the lowercased first word of the language, e.g. "javascript") is used
+// instead of "<name>.tmpl" when answering in that language.
+type ProfileSet struct {
+	mu     sync.Mutex
+	tmpls  map[string]*template.Template // "<name>" and "<name>.<lang>"
+	names  []string
+	active string
+}
+
+// Profiles is the session's profile set; the active profile drives Solve.
+var Profiles = &ProfileSet{tmpls: map[string]*template.Template{}, names: []string{DefaultProfile}, active: DefaultProfile}
+
+// Load replaces the template profiles with those found in dir. A missing
+// directory just leaves the built-in profile; templates that fail to parse,
+// and language variants without their "<name>.tmpl", are logged and
+// skipped.
+func (s *ProfileSet) Load(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return fmt.Errorf("list prompt templates: %w", err)
+	}
+	tmpls := make(map[string]*template.Template, len(paths))
+	for _, path := range paths {
+		key, t, err := loadProfile(path)
+		if err != nil {
+			applog.AppLog.Warn("prompts: %v", err)
+		}
+		if t != nil {
+			tmpls[key] = t
+		}
+	}
+	for _, key := range orphanVariants(tmpls) {
+		applog.AppLog.Warn("prompts: %s.tmpl has no %s.tmpl to fall back on for other languages, skipped", key, profileName(key))
+		delete(tmpls, key)
+	}
+	names := profileNames(tmpls)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tmpls = tmpls
+	s.names = names
+	if !slices.Contains(names, s.active) {
+		s.active = DefaultProfile
+	}
+	applog.AppLog.Info("prompts: %d profile(s) from %s, active %q", len(names), dir, s.active)
+	return nil
+}
+
+// loadProfile parses one template file, keyed by its file name without
+// extension.
+func loadProfile(path string) (string, *template.Template, error) {
+	key := strings.TrimSuffix(filepath.Base(path), ".tmpl")
+	if profileName(key) == DefaultProfile {
+		return key, nil, fmt.Errorf("%s shadows the built-in profile, skipped", path)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return key, nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	t, err := template.New(key).Option("missingkey=error").Parse(string(b))
+	if err != nil {
+		return key, nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return key, t, nil
+}
+
+// profileName strips a ".<lang>" variant suffix from a template key.
+func profileName(key string) string {
+	name, _, _ := strings.Cut(key, ".")
+	return name
+}
+
+// orphanVariants lists the language variants whose profile has no base
+// template. Such a profile would silently answer with the built-in prompt
+// in every other language.
+func orphanVariants(tmpls map[string]*template.Template) []string {
+	var keys []string
+	for key := range tmpls {
+		if _, ok := tmpls[profileName(key)]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// profileNames lists the built-in profile followed by the distinct
+// template profiles in alphabetical order.
+func profileNames(tmpls map[string]*template.Template) []string {
+	var names []string
+	for key := range tmpls {
+		if !slices.Contains(names, profileName(key)) {
+			names = append(names, profileName(key))
+		}
+	}
+	sort.Strings(names)
+	return append([]string{DefaultProfile}, names...)
+}
+
+// Names lists the available profiles, built-in first.
+func (s *ProfileSet) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.names...)
+}
+
+func (s *ProfileSet) Active() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active
+}
+
+// SetActive switches profile; an empty name selects the built-in one.
+func (s *ProfileSet) SetActive(name string) error {
+	if name == "" {
+		name = DefaultProfile
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !slices.Contains(s.names, name) {
+		return fmt.Errorf("unknown prompt profile %q", name)
+	}
+	s.active = name
+	applog.AppLog.Info("prompts: profile %q active", name)
+	return nil
+}
+
+// SolvePrompt renders profile, falling back to the built-in prompt if the
+// template fails or the profile is no longer loaded.
+func (s *ProfileSet) SolvePrompt(profile, lang string, hasContext bool, transcript string, imageCount int) string {
+	t := s.template(profile, lang)
+	if t == nil {
+		return BuildSolvePrompt(lang, hasContext, transcript, imageCount)
+	}
+	data := PromptData{
+		Lang:       lang,
+		HasContext: hasContext,
+		Transcript: transcript,
+		ImageCount: imageCount,
+		Receipt:    BuildContextReceipt(imageCount, hasContext, transcript != ""),
+		CodeRules:  CodeRules,
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		applog.AppLog.Error("prompts: %s: %v — using built-in prompt", t.Name(), err)
+		return BuildSolvePrompt(lang, hasContext, transcript, imageCount)
+	}
+	return b.String()
+}
+
+// template returns profile's template for lang, or nil for the built-in
+// profile (also named by "").
+func (s *ProfileSet) template(profile, lang string) *template.Template {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if profile == DefaultProfile || profile == "" {
+		return nil
+	}
+	first, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(lang)), " ")
+	if t, ok := s.tmpls[profile+"."+first]; ok {
+		return t
+	}
+	t, ok := s.tmpls[profile] // Load drops variants without a base
+	if !ok {
+		applog.AppLog.Warn("prompts: profile %q is no longer loaded — using built-in prompt", profile)
+	}
+	return t
+}
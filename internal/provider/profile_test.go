@@ -0,0 +1,46 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"text/template"
+)
+
+func TestProfileLoad(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"review.tmpl":            "review in {{.Lang}}",
+		"review.javascript.tmpl": "review js",
+		"notes.go.tmpl":          "notes go only",
+		"broken.tmpl":            "{{.Lang",
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &ProfileSet{tmpls: map[string]*template.Template{}, active: DefaultProfile}
+	if err := s.Load(dir); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got, want := s.Names(), []string{DefaultProfile, "review"}; !slices.Equal(got, want) {
+		t.Errorf("Names() = %q, want %q", got, want)
+	}
+	if err := s.SetActive("notes"); err == nil {
+		t.Error(`SetActive("notes") succeeded for a profile with only a language variant`)
+	}
+	if err := s.SetActive("review"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct{ lang, want string }{
+		{"JavaScript", "review js"},
+		{"Python", "review in Python"},
+	}
+	for _, tt := range tests {
+		if got := s.SolvePrompt(s.Active(), tt.lang, false, "", 0); got != tt.want {
+			t.Errorf("SolvePrompt(%q) = %q, want %q", tt.lang, got, tt.want)
+		}
+	}
+}
@@ -34,14 +34,16 @@ func BuildContextReceipt(imageCount int, hasContext bool, hasTranscript bool) st
 		".** Begin your response with a `> Context:` line confirming each piece of context you received (e.g. number of screenshots, source file names, transcript presence). Then proceed with your answer.\n\n"
 }
 
-func BuildSolvePrompt(lang, contextText, transcript string, imageCount int) string {
+// BuildSolvePrompt builds the Solve instructions. Source files are not
+// inlined; they travel in a separate, cacheable context block.
+func BuildSolvePrompt(lang string, hasContext bool, transcript string, imageCount int) string {
 	// 1. Context receipt
-	prompt := BuildContextReceipt(imageCount, contextText != "", transcript != "")
+	prompt := BuildContextReceipt(imageCount, hasContext, transcript != "")
 
 	// 2. All user context together — equal weight
 	prompt += "Analyze all of the following inputs together. Each input modality (screenshots, source files, audio transcript) carries equal weight. The user's instructions from any modality always take priority over default code rules or formatting guidelines — never refuse or override what the user asks for.\n\n"
-	if contextText != "" {
-		prompt += "**Source files:**\n\n" + contextText + "\n\n"
+	if hasContext {
+		prompt += "**Source files:** See the most recent **Source files** block in this conversation.\n\n"
 	}
 	if transcript != "" {
 		prompt += "**Audio transcript:**\n\n" + transcript + "\n\n"
@@ -0,0 +1,157 @@
+package provider
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"second-nature/internal/applog"
+	"second-nature/internal/model"
+)
+
+// Queued wraps the active provider so conversation calls and summaries run
+// one at a time, in order, on a single worker. Calls whose context carries
+// model.PriorityBackground only run when no interactive call is waiting.
+// The wrapped provider can be swapped mid-session; queued calls go to
+// whichever provider is current when they start.
+type Queued struct {
+	mu      sync.Mutex
+	p       model.Provider
+	high    chan *job
+	low     chan *job
+	waiting atomic.Int32
+	onDepth func(int)
+}
+
+type job struct {
+	run  func()
+	done chan struct{}
+}
+
+// NewQueued starts the queue worker for p. onDepth, if set, is called with
+// the number of waiting calls whenever it changes.
+func NewQueued(p model.Provider, onDepth func(int)) *Queued {
+	q := &Queued{p: p, high: make(chan *job), low: make(chan *job), onDepth: onDepth}
+	go q.loop()
+	return q
+}
+
+func (q *Queued) loop() {
+	for {
+		j := q.next()
+		j.run()
+		close(j.done)
+	}
+}
+
+// next takes a waiting interactive call if there is one, otherwise
+// whichever call arrives first.
+func (q *Queued) next() *job {
+	select {
+	case j := <-q.high:
+		return j
+	default:
+	}
+	select {
+	case j := <-q.high:
+		return j
+	case j := <-q.low:
+		return j
+	}
+}
+
+// submit queues run and waits for it to finish. It gives up with ctx's
+// error if ctx ends before the call is taken off the queue.
+func (q *Queued) submit(ctx context.Context, run func()) error {
+	ch := q.high
+	if model.PriorityFrom(ctx) == model.PriorityBackground {
+		ch = q.low
+	}
+	j := &job{run: run, done: make(chan struct{})}
+	q.report(q.waiting.Add(1))
+	select {
+	case ch <- j:
+		q.report(q.waiting.Add(-1))
+	case <-ctx.Done():
+		q.report(q.waiting.Add(-1))
+		applog.AppLog.Info("queue: call cancelled while waiting")
+		return ctx.Err()
+	}
+	<-j.done
+	return nil
+}
+
+func (q *Queued) report(depth int32) {
+	if q.onDepth != nil {
+		q.onDepth(int(depth))
+	}
+}
+
+// Provider returns the wrapped provider.
+func (q *Queued) Provider() model.Provider {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.p
+}
+
+// SetProvider swaps the wrapped provider; a call already running finishes
+// on the old one.
+func (q *Queued) SetProvider(p model.Provider) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.p = p
+}
+
+func (q *Queued) Solve(ctx context.Context, images [][]byte, transcript string, onDelta func(string)) (string, error) {
+	var answer string
+	var err error
+	if qerr := q.submit(ctx, func() { answer, err = q.Provider().Solve(ctx, images, transcript, onDelta) }); qerr != nil {
+		return "", model.ErrInterrupted
+	}
+	return answer, err
+}
+
+func (q *Queued) FollowUp(ctx context.Context, text string, onDelta func(string)) (string, error) {
+	var answer string
+	var err error
+	if qerr := q.submit(ctx, func() { answer, err = q.Provider().FollowUp(ctx, text, onDelta) }); qerr != nil {
+		return "", model.ErrInterrupted
+	}
+	return answer, err
+}
+
+func (q *Queued) Reply(ctx context.Context, history []model.Message, user model.Message, onDelta func(string)) (string, error) {
+	var answer string
+	var err error
+	if qerr := q.submit(ctx, func() { answer, err = q.Provider().Reply(ctx, history, user, onDelta) }); qerr != nil {
+		return "", model.ErrInterrupted
+	}
+	return answer, err
+}
+
+func (q *Queued) Action(ctx context.Context, a model.HotkeyAction, images [][]byte, transcript string, onDelta func(string)) (string, error) {
+	var answer string
+	var err error
+	if qerr := q.submit(ctx, func() { answer, err = q.Provider().Action(ctx, a, images, transcript, onDelta) }); qerr != nil {
+		return "", model.ErrInterrupted
+	}
+	return answer, err
+}
+
+func (q *Queued) Summarize(ctx context.Context, text string) (string, error) {
+	var summary string
+	var err error
+	if qerr := q.submit(ctx, func() { summary, err = q.Provider().Summarize(ctx, text) }); qerr != nil {
+		return "", qerr
+	}
+	return summary, err
+}
+
+func (q *Queued) ModelName() string                     { return q.Provider().ModelName() }
+func (q *Queued) SetLanguage(lang string)               { q.Provider().SetLanguage(lang) }
+func (q *Queued) SetContextRoots(roots []string)        { q.Provider().SetContextRoots(roots) }
+func (q *Queued) ContextRoots() []string                { return q.Provider().ContextRoots() }
+func (q *Queued) ClearHistory()                         { q.Provider().ClearHistory() }
+func (q *Queued) HistoryLen() int                       { return q.Provider().HistoryLen() }
+func (q *Queued) Conversation() *model.Conversation     { return q.Provider().Conversation() }
+func (q *Queued) SetConversation(c *model.Conversation) { q.Provider().SetConversation(c) }
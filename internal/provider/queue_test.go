@@ -0,0 +1,289 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"second-nature/internal/model"
+)
+
+// slowProvider streams each answer a word at a time. It records the order
+// calls start in and how many ever overlap. While gate is open (non-nil),
+// calls wait on it before streaming.
+type slowProvider struct {
+	model.Provider
+	gate    chan struct{}
+	mu      sync.Mutex
+	started []string
+	running atomic.Int32
+	overlap atomic.Int32
+}
+
+func (p *slowProvider) call(ctx context.Context, name string, onDelta func(string)) (string, error) {
+	if n := p.running.Add(1); n > 1 {
+		p.overlap.Add(1)
+	}
+	defer p.running.Add(-1)
+	p.mu.Lock()
+	p.started = append(p.started, name)
+	p.mu.Unlock()
+	if p.gate != nil {
+		<-p.gate
+	}
+	words := []string{name, " streamed", " slowly"}
+	var b strings.Builder
+	for _, w := range words {
+		select {
+		case <-ctx.Done():
+			return b.String(), model.ErrInterrupted
+		case <-time.After(time.Millisecond):
+		}
+		b.WriteString(w)
+		if onDelta != nil {
+			onDelta(w)
+		}
+	}
+	return b.String(), nil
+}
+
+func (p *slowProvider) Solve(ctx context.Context, _ [][]byte, transcript string, onDelta func(string)) (string, error) {
+	return p.call(ctx, "solve:"+transcript, onDelta)
+}
+
+func (p *slowProvider) FollowUp(ctx context.Context, text string, onDelta func(string)) (string, error) {
+	return p.call(ctx, "followup:"+text, onDelta)
+}
+
+func (p *slowProvider) Summarize(ctx context.Context, text string) (string, error) {
+	return p.call(ctx, "summarize:"+text, nil)
+}
+
+func (p *slowProvider) order() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.started...)
+}
+
+// waitQueued waits until n calls are waiting in q.
+func waitQueued(t *testing.T, q *Queued, n int32) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for q.waiting.Load() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d calls waiting, want %d", q.waiting.Load(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitStarted waits until p has started n calls.
+func waitStarted(t *testing.T, p *slowProvider, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(p.order()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d calls started, want %d", len(p.order()), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueuedConcurrentCalls(t *testing.T) {
+	p := &slowProvider{}
+	var depth atomic.Int32
+	q := NewQueued(p, func(n int) { depth.Store(int32(n)) })
+	bg := model.WithPriority(context.Background(), model.PriorityBackground)
+
+	const n = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, 3*n)
+	for i := range n {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			var deltas strings.Builder
+			answer, err := q.Solve(context.Background(), nil, fmt.Sprint(i), func(d string) { deltas.WriteString(d) })
+			if err != nil || answer != deltas.String() || !strings.HasPrefix(answer, fmt.Sprintf("solve:%d ", i)) {
+				errs <- fmt.Errorf("Solve %d = %q (deltas %q), %v", i, answer, deltas.String(), err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if answer, err := q.FollowUp(context.Background(), fmt.Sprint(i), nil); err != nil || !strings.HasPrefix(answer, fmt.Sprintf("followup:%d ", i)) {
+				errs <- fmt.Errorf("FollowUp %d = %q, %v", i, answer, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if summary, err := q.Summarize(bg, fmt.Sprint(i)); err != nil || !strings.HasPrefix(summary, fmt.Sprintf("summarize:%d ", i)) {
+				errs <- fmt.Errorf("Summarize %d = %q, %v", i, summary, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if got := len(p.order()); got != 3*n {
+		t.Errorf("%d calls ran, want %d", got, 3*n)
+	}
+	if o := p.overlap.Load(); o != 0 {
+		t.Errorf("%d calls overlapped another, want them one at a time", o)
+	}
+	if d := depth.Load(); d != 0 {
+		t.Errorf("final depth %d, want 0", d)
+	}
+}
+
+func TestQueuedPriority(t *testing.T) {
+	p := &slowProvider{gate: make(chan struct{})}
+	q := NewQueued(p, nil)
+	bg := model.WithPriority(context.Background(), model.PriorityBackground)
+
+	var wg sync.WaitGroup
+	run := func(f func()) {
+		wg.Add(1)
+		go func() { defer wg.Done(); f() }()
+	}
+	// The first call holds the worker while the others queue up behind it.
+	run(func() { q.Solve(context.Background(), nil, "first", nil) })
+	waitStarted(t, p, 1)
+	run(func() { q.Summarize(bg, "background") })
+	waitQueued(t, q, 1)
+	run(func() { q.FollowUp(context.Background(), "interactive", nil) })
+	waitQueued(t, q, 2)
+	close(p.gate)
+	wg.Wait()
+
+	want := []string{"solve:first", "followup:interactive", "summarize:background"}
+	if got := p.order(); !slices.Equal(got, want) {
+		t.Errorf("order = %q, want %q", got, want)
+	}
+}
+
+func TestQueuedCancelWhileWaiting(t *testing.T) {
+	p := &slowProvider{gate: make(chan struct{})}
+	q := NewQueued(p, nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.Solve(context.Background(), nil, "first", nil)
+	}()
+	waitStarted(t, p, 1)
+
+	tests := []struct {
+		name string
+		call func(ctx context.Context) error
+		want error
+	}{
+		{"solve", func(ctx context.Context) error {
+			_, err := q.Solve(ctx, nil, "cancelled", nil)
+			return err
+		}, model.ErrInterrupted},
+		{"followup", func(ctx context.Context) error {
+			_, err := q.FollowUp(ctx, "cancelled", nil)
+			return err
+		}, model.ErrInterrupted},
+		{"summarize", func(ctx context.Context) error {
+			_, err := q.Summarize(ctx, "cancelled")
+			return err
+		}, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			errc := make(chan error, 1)
+			go func() { errc <- tt.call(ctx) }()
+			waitQueued(t, q, 1)
+			cancel()
+			select {
+			case err := <-errc:
+				if !errors.Is(err, tt.want) {
+					t.Errorf("err = %v, want %v", err, tt.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("cancelled call still waiting")
+			}
+			waitQueued(t, q, 0)
+		})
+	}
+
+	close(p.gate)
+	<-done
+	// Let the worker take anything that slipped through before checking.
+	if _, err := q.FollowUp(context.Background(), "last", nil); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"solve:first", "followup:last"}
+	if got := p.order(); !slices.Equal(got, want) {
+		t.Errorf("calls run = %q, want %q: cancelled calls must not run", got, want)
+	}
+}
+
+// streamRenderer counts how Stream ends each stream.
+type streamRenderer struct {
+	model.Renderer
+	mu          sync.Mutex
+	interrupted int
+	done        int
+}
+
+func (r *streamRenderer) StreamInterrupted() {
+	r.mu.Lock()
+	r.interrupted++
+	r.mu.Unlock()
+}
+
+func (r *streamRenderer) StreamDone() {
+	r.mu.Lock()
+	r.done++
+	r.mu.Unlock()
+}
+
+func TestStreamControlStopsOnlyTheRunningCall(t *testing.T) {
+	p := &slowProvider{gate: make(chan struct{})}
+	q := NewQueued(p, nil)
+	var sc model.StreamControl
+	r := &streamRenderer{}
+	type result struct {
+		text string
+		err  error
+	}
+	stream := func(text string, out chan<- result) {
+		answer, err := sc.Stream(context.Background(), r, func(ctx context.Context) (string, error) {
+			return q.FollowUp(ctx, text, nil)
+		})
+		out <- result{answer, err}
+	}
+	first, second := make(chan result, 1), make(chan result, 1)
+	go stream("first", first)
+	waitStarted(t, p, 1)
+	go stream("second", second)
+	waitQueued(t, q, 1)
+
+	if !sc.HandleHotkey(model.HotkeyStop) {
+		t.Fatal("HandleHotkey did not consume stop")
+	}
+	close(p.gate)
+	if res := <-first; !errors.Is(res.err, model.ErrInterrupted) {
+		t.Errorf("running call = %q, %v; want it interrupted", res.text, res.err)
+	}
+	if res := <-second; res.err != nil || res.text != "followup:second streamed slowly" {
+		t.Errorf("queued call = %q, %v; want it answered in full", res.text, res.err)
+	}
+	if sc.Active() || sc.Stop() {
+		t.Error("a call is still in flight")
+	}
+	if r.interrupted != 1 || r.done != 1 {
+		t.Errorf("streams ended %d interrupted, %d done; want 1 of each", r.interrupted, r.done)
+	}
+}
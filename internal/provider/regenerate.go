@@ -0,0 +1,85 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"second-nature/internal/model"
+)
+
+// Regenerate answers the user turn with sequence number seq again, from the
+// history before it, and replaces that turn's reply in place. p may be a
+// different provider than the one that owns conv, to retry on another
+// model. A failed run leaves the original pair untouched; an interrupted
+// one keeps its partial answer, marked interrupted, as Solve does.
+func Regenerate(ctx context.Context, p model.Provider, conv *model.Conversation, seq int, onDelta func(string)) (string, error) {
+	history, user, err := turnAt(conv, seq)
+	if err != nil {
+		return "", err
+	}
+	if user.Lang != "" {
+		ctx, _ = structure(ctx, "")
+	}
+	return resend(ctx, p, conv, seq, history, user, onDelta)
+}
+
+// EditAndResend replaces the text a user typed or spoke for the most recent
+// turn with input, rebuilding a solve turn's prompt around it with the
+// profile and language it was sent with, then answers it again in place of
+// the old reply.
+func EditAndResend(ctx context.Context, p model.Provider, conv *model.Conversation, seq int, input string, onDelta func(string)) (string, error) {
+	history, user, err := turnAt(conv, seq)
+	if err != nil {
+		return "", err
+	}
+	if len(history)+2 != conv.Len() {
+		return "", fmt.Errorf("only the latest turn can be edited")
+	}
+	prompt := input
+	if user.Lang != "" {
+		ci, _ := lastContextPart(append(history, user))
+		ctx, prompt = structure(ctx, Profiles.SolvePrompt(user.Profile, user.Lang, ci >= 0, input, imageCount(user)))
+	}
+	parts := append([]model.Part(nil), user.Parts...)
+	parts[len(parts)-1] = model.TextPart(prompt)
+	user.Parts, user.Input = parts, input
+	return resend(ctx, p, conv, seq, history, user, onDelta)
+}
+
+// turnAt returns the history before the user turn seq and the turn itself.
+// The turn must already have a reply.
+func turnAt(conv *model.Conversation, seq int) ([]model.Message, model.Message, error) {
+	messages := conv.Messages()
+	i := conv.IndexOfSeq(seq)
+	if i < 0 || messages[i].Role != model.RoleUser || i+1 >= len(messages) {
+		return nil, model.Message{}, fmt.Errorf("turn %d is no longer in the history", seq)
+	}
+	if messages[i].Summary {
+		return nil, model.Message{}, fmt.Errorf("turn %d was compacted", seq)
+	}
+	return messages[:i:i], messages[i], nil
+}
+
+func resend(ctx context.Context, p model.Provider, conv *model.Conversation, seq int, history []model.Message, user model.Message, onDelta func(string)) (string, error) {
+	answer, err := p.Reply(ctx, history, user, onDelta)
+	interrupted := errors.Is(err, model.ErrInterrupted)
+	if err != nil && (!interrupted || answer == "") {
+		return answer, err
+	}
+	reply := model.Message{Role: model.RoleAssistant, Parts: []model.Part{model.TextPart(answer)}, Model: p.ModelName(), Interrupted: interrupted}
+	if !conv.ReplacePair(seq, user, reply) {
+		return answer, fmt.Errorf("turn %d is no longer in the history", seq)
+	}
+	return answer, err
+}
+
+func imageCount(m model.Message) int {
+	n := 0
+	for _, part := range m.Parts {
+		if part.Image != nil {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,54 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+)
+
+func TestEditAndResendKeepsProfile(t *testing.T) {
+	dir := t.TempDir()
+	for name, body := range map[string]string{"terse.tmpl": "terse: {{.Transcript}}", "verbose.tmpl": "verbose: {{.Transcript}}"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := Profiles
+	t.Cleanup(func() { Profiles = saved })
+	Profiles = &ProfileSet{tmpls: map[string]*template.Template{}, active: DefaultProfile}
+	if err := Profiles.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := Profiles.SetActive("terse"); err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []string
+	p, _ := localServer(t, func(w http.ResponseWriter, cr chatRequest) {
+		sent = append(sent, fmt.Sprint(cr.Messages[len(cr.Messages)-1].Content))
+		writeSSE(w, `{"choices":[{"delta":{"content":"answer"}}]}`, `[DONE]`)
+	})
+	if _, err := p.Solve(context.Background(), nil, "two sum", nil); err != nil {
+		t.Fatal(err)
+	}
+	// Switching profile must not change the prompt of a turn already sent.
+	if err := Profiles.SetActive("verbose"); err != nil {
+		t.Fatal(err)
+	}
+	seq := p.Conversation().Messages()[0].Seq
+	if _, err := EditAndResend(context.Background(), p, p.Conversation(), seq, "three sum", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"terse: two sum", "terse: three sum"}
+	if fmt.Sprint(sent) != fmt.Sprint(want) {
+		t.Errorf("prompts sent = %q, want %q", sent, want)
+	}
+	if user := p.Conversation().Messages()[0]; user.Profile != "terse" || user.Input != "three sum" {
+		t.Errorf("edited turn has profile %q input %q", user.Profile, user.Input)
+	}
+}
@@ -0,0 +1,551 @@
+package provider
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"second-nature/internal/applog"
+	"second-nature/internal/model"
+)
+
+// Fixture is one recorded provider call: what was sent, the streamed deltas
+// with their timing, and the outcome. A session file holds one fixture per
+// line, in call order.
+type Fixture struct {
+	Kind    string           `json:"kind"` // "solve", "followup", "reply", "action" or "summarize"
+	Model   string           `json:"model"`
+	Time    time.Time        `json:"time"`
+	Request FixtureRequest   `json:"request"`
+	Deltas  []FixtureDelta   `json:"deltas,omitempty"`
+	Answer  string           `json:"answer"` // partial when Err is an interruption
+	Err     *FixtureError    `json:"error,omitempty"`
+	Usage   model.Usage      `json:"usage"`
+	History []FixtureMessage `json:"history,omitempty"` // conversation before the call
+}
+
+// FixtureRequest holds the call's inputs. Prompt, Images, Context, Lang and
+// Profile describe the user turn the provider actually sent (empty for summarize).
+type FixtureRequest struct {
+	ContextRoots []string     `json:"context_roots,omitempty"`
+	Transcript   string       `json:"transcript,omitempty"`
+	Text         string       `json:"text,omitempty"` // follow-up, reply or summarize input, or the action's name
+	Prompt       string       `json:"prompt,omitempty"`
+	Images       []string     `json:"images,omitempty"` // sha256 of each JPEG
+	Context      *FixturePart `json:"context,omitempty"`
+	Lang         string       `json:"lang,omitempty"`
+	Profile      string       `json:"profile,omitempty"`
+}
+
+// FixturePart is the context block sent with a turn, kept in full so the
+// replayed history matches the recorded one.
+type FixturePart struct {
+	Text string `json:"text"`
+	Hash string `json:"hash"`
+	Base string `json:"base,omitempty"`
+}
+
+// FixtureError is a recorded call's error, typed so replay returns the same
+// sentinel or *model.APIError the real provider did.
+type FixtureError struct {
+	Message string          `json:"message"`
+	Kind    string          `json:"kind,omitempty"` // one of the fixtureErr* kinds, "" for any other error
+	APIKind model.ErrorKind `json:"api_kind,omitempty"`
+	Status  int             `json:"status,omitempty"`
+}
+
+const (
+	fixtureErrInterrupted = "interrupted"
+	fixtureErrCancelled   = "cancelled"
+	fixtureErrSpendingCap = "spending_cap"
+	fixtureErrAPI         = "api"
+)
+
+// fixtureSentinels are the errors recorded by kind alone, in the order
+// fixtureError tries them.
+var fixtureSentinels = []struct {
+	kind string
+	err  error
+}{
+	{fixtureErrInterrupted, model.ErrInterrupted},
+	{fixtureErrCancelled, context.Canceled},
+	{fixtureErrSpendingCap, model.ErrSpendingCap},
+}
+
+// fixtureErrors rebuilds a recorded error of each kind.
+var fixtureErrors = map[string]func(fe *FixtureError) error{
+	fixtureErrInterrupted: func(*FixtureError) error { return model.ErrInterrupted },
+	fixtureErrCancelled:   func(*FixtureError) error { return context.Canceled },
+	fixtureErrSpendingCap: func(fe *FixtureError) error { return replayedError{fe.Message, model.ErrSpendingCap} },
+	fixtureErrAPI: func(fe *FixtureError) error {
+		return &model.APIError{Kind: fe.APIKind, Status: fe.Status, Err: errors.New(fe.Message)}
+	},
+}
+
+func fixtureError(err error) *FixtureError {
+	fe := &FixtureError{Message: err.Error()}
+	for _, s := range fixtureSentinels {
+		if fe.Kind == "" && errors.Is(err, s.err) {
+			fe.Kind = s.kind
+		}
+	}
+	var apiErr *model.APIError
+	if fe.Kind == "" && errors.As(err, &apiErr) {
+		fe.Kind, fe.APIKind, fe.Status = fixtureErrAPI, apiErr.Kind, apiErr.Status
+		fe.Message = apiErr.Err.Error()
+	}
+	return fe
+}
+
+// err rebuilds the recorded error, matching the original with errors.Is
+// and errors.As.
+func (fe *FixtureError) err() error {
+	build, ok := fixtureErrors[fe.Kind]
+	if !ok {
+		return errors.New(fe.Message)
+	}
+	return build(fe)
+}
+
+// replayedError keeps a recorded message while wrapping its sentinel.
+type replayedError struct {
+	msg      string
+	sentinel error
+}
+
+func (e replayedError) Error() string { return e.msg }
+func (e replayedError) Unwrap() error { return e.sentinel }
+
+// FixtureDelta is one streamed chunk, At milliseconds after the call
+// started. Reset marks a dropped stream restarting.
+type FixtureDelta struct {
+	At    int64  `json:"at"`
+	Text  string `json:"text,omitempty"`
+	Reset bool   `json:"reset,omitempty"`
+}
+
+// FixtureMessage is a history turn with images and context blocks reduced
+// to hashes so session files stay small.
+type FixtureMessage struct {
+	Role    model.Role `json:"role"`
+	Text    string     `json:"text,omitempty"`
+	Images  []string   `json:"images,omitempty"`
+	Context string     `json:"context,omitempty"`
+}
+
+func imageHash(jpeg []byte) string {
+	sum := sha256.Sum256(jpeg)
+	return hex.EncodeToString(sum[:8])
+}
+
+func fixtureMessage(m model.Message) FixtureMessage {
+	fm := FixtureMessage{Role: m.Role}
+	for _, p := range m.Parts {
+		if p.Image != nil {
+			fm.Images = append(fm.Images, imageHash(p.Image))
+		}
+		if p.ContextHash != "" {
+			fm.Context = p.ContextHash
+		}
+		if p.Image == nil && p.ContextHash == "" {
+			fm.Text += p.Text
+		}
+	}
+	return fm
+}
+
+func fixtureHistory(messages []model.Message) []FixtureMessage {
+	out := make([]FixtureMessage, 0, len(messages))
+	for _, m := range messages {
+		out = append(out, fixtureMessage(m))
+	}
+	return out
+}
+
+// --- Recording ---
+
+// Recorder wraps a provider and appends every Solve, FollowUp, Reply,
+// Action and Summarize call to a session file. Everything else passes
+// through.
+type Recorder struct {
+	model.Provider
+	mu   sync.Mutex
+	path string
+}
+
+// NewRecorder records p's calls into a new session file in dir.
+func NewRecorder(p model.Provider, dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create fixture dir: %w", err)
+	}
+	path := filepath.Join(dir, "session-"+time.Now().Format("20060102-150405")+".jsonl")
+	applog.AppLog.Info("replay: recording to %s", path)
+	return &Recorder{Provider: p, path: path}, nil
+}
+
+// Path is the session file being written.
+func (r *Recorder) Path() string { return r.path }
+
+func (r *Recorder) Solve(ctx context.Context, images [][]byte, transcript string, onDelta func(string)) (string, error) {
+	f := r.begin("solve")
+	f.Request.Transcript = transcript
+	return r.record(ctx, f, onDelta, func(ctx context.Context, onDelta func(string)) (string, error) {
+		return r.Provider.Solve(ctx, images, transcript, onDelta)
+	})
+}
+
+func (r *Recorder) FollowUp(ctx context.Context, text string, onDelta func(string)) (string, error) {
+	f := r.begin("followup")
+	f.Request.Text = text
+	return r.record(ctx, f, onDelta, func(ctx context.Context, onDelta func(string)) (string, error) {
+		return r.Provider.FollowUp(ctx, text, onDelta)
+	})
+}
+
+func (r *Recorder) Reply(ctx context.Context, history []model.Message, user model.Message, onDelta func(string)) (string, error) {
+	f := r.begin("reply")
+	f.History = fixtureHistory(history)
+	sent := fixtureMessage(user)
+	f.Request.Text, f.Request.Images = sent.Text, sent.Images
+	return r.record(ctx, f, onDelta, func(ctx context.Context, onDelta func(string)) (string, error) {
+		return r.Provider.Reply(ctx, history, user, onDelta)
+	})
+}
+
+func (r *Recorder) Action(ctx context.Context, a model.HotkeyAction, images [][]byte, transcript string, onDelta func(string)) (string, error) {
+	f := r.begin("action")
+	f.Request.Text, f.Request.Transcript = model.ActionNames[a], transcript
+	return r.record(ctx, f, onDelta, func(ctx context.Context, onDelta func(string)) (string, error) {
+		return r.Provider.Action(ctx, a, images, transcript, onDelta)
+	})
+}
+
+func (r *Recorder) Summarize(ctx context.Context, text string) (string, error) {
+	f := r.begin("summarize")
+	f.Request.Text = text
+	return r.record(ctx, f, nil, func(ctx context.Context, _ func(string)) (string, error) {
+		return r.Provider.Summarize(ctx, text)
+	})
+}
+
+// begin snapshots the provider state a call starts from.
+func (r *Recorder) begin(kind string) *Fixture {
+	messages := r.Conversation().Messages()
+	return &Fixture{
+		Kind:    kind,
+		Model:   r.ModelName(),
+		Time:    time.Now(),
+		Request: FixtureRequest{ContextRoots: r.ContextRoots()},
+		History: fixtureHistory(messages),
+	}
+}
+
+// record runs call with its deltas, stream resets and usage captured, then
+// appends the fixture. A write failure is logged; the call's result is
+// returned either way.
+func (r *Recorder) record(ctx context.Context, f *Fixture, onDelta func(string), call func(context.Context, func(string)) (string, error)) (string, error) {
+	start := time.Now()
+	var mu sync.Mutex
+	capture := func(d FixtureDelta) {
+		mu.Lock()
+		defer mu.Unlock()
+		d.At = time.Since(start).Milliseconds()
+		f.Deltas = append(f.Deltas, d)
+	}
+	parent := model.StreamHooksFrom(ctx)
+	hooks := parent
+	hooks.Reset = func() {
+		capture(FixtureDelta{Reset: true})
+		parent.ResetStream()
+	}
+	hooks.Usage = func(u model.Usage) {
+		mu.Lock()
+		f.Usage = f.Usage.Add(u)
+		mu.Unlock()
+		parent.ReportUsage(u)
+	}
+	answer, err := call(model.WithStreamHooks(ctx, hooks), func(d string) {
+		capture(FixtureDelta{Text: d})
+		if onDelta != nil {
+			onDelta(d)
+		}
+	})
+	f.Answer = answer
+	if err != nil {
+		f.Err = fixtureError(err)
+	}
+	if f.Kind != "reply" && f.Kind != "summarize" && (err == nil || answer != "") {
+		recordTurn(&f.Request, lastUserTurn(r.Conversation().Messages()))
+	}
+	if werr := r.write(f); werr != nil {
+		applog.AppLog.Error("replay: %v", werr)
+	}
+	return answer, err
+}
+
+// lastUserTurn is the turn a call just sent. A call that failed without
+// any answer rolls its turn back, so it is only looked up when one exists.
+func lastUserTurn(messages []model.Message) model.Message {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == model.RoleUser {
+			return messages[i]
+		}
+	}
+	return model.Message{}
+}
+
+// recordTurn fills in the parts of the sent user turn that replay needs to
+// rebuild it.
+func recordTurn(req *FixtureRequest, m model.Message) {
+	sent := fixtureMessage(m)
+	req.Prompt, req.Images, req.Lang, req.Profile = sent.Text, sent.Images, m.Lang, m.Profile
+	for _, part := range m.Parts {
+		if part.ContextHash != "" {
+			req.Context = &FixturePart{Text: part.Text, Hash: part.ContextHash, Base: part.ContextBase}
+		}
+	}
+}
+
+// contextParts rebuilds the context block the recorded turn carried.
+func (req FixtureRequest) contextParts() []model.Part {
+	if req.Context == nil {
+		return nil
+	}
+	return []model.Part{{Text: req.Context.Text, ContextHash: req.Context.Hash, ContextBase: req.Context.Base}}
+}
+
+func (r *Recorder) write(f *Fixture) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("encode fixture: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	file, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open session file: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("write session file: %w", err)
+	}
+	return nil
+}
+
+// --- Replay ---
+
+// ErrReplayExhausted is returned once every recorded call has been played.
+var ErrReplayExhausted = errors.New("replay: no more recorded calls")
+
+// ReplayProvider plays a recorded session back without network access.
+// Calls must arrive in the recorded order; each streams its recorded deltas
+// with the original timing scaled by Speed and updates the conversation as
+// the real provider would. Requests that differ from the recording are
+// logged, not rejected, so a session can be replayed against changed code.
+type ReplayProvider struct {
+	session
+	Speed    float64 // 1 = recorded timing, 0.5 = twice as fast, <= 0 = no delays
+	mu       sync.Mutex
+	fixtures []Fixture
+	next     int
+}
+
+// LoadReplay reads a session file written by Recorder.
+func LoadReplay(path string) (*ReplayProvider, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open session file: %w", err)
+	}
+	defer file.Close()
+	var fixtures []Fixture
+	sc := bufio.NewScanner(file)
+	sc.Buffer(make([]byte, 0, 1<<20), 64<<20)
+	for line := 1; sc.Scan(); line++ {
+		var f Fixture
+		if err := json.Unmarshal(sc.Bytes(), &f); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		fixtures = append(fixtures, f)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read session file: %w", err)
+	}
+	applog.AppLog.Info("replay: %d call(s) from %s", len(fixtures), path)
+	return &ReplayProvider{session: newSession(), Speed: 1, fixtures: fixtures}, nil
+}
+
+// ModelName reports the recorded model, so traces and pricing match the
+// original session.
+func (p *ReplayProvider) ModelName() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.next < len(p.fixtures) {
+		return p.fixtures[p.next].Model
+	}
+	if len(p.fixtures) > 0 {
+		return p.fixtures[len(p.fixtures)-1].Model
+	}
+	return "replay"
+}
+
+// Remaining is the number of recorded calls not yet played.
+func (p *ReplayProvider) Remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.fixtures) - p.next
+}
+
+// take returns the next fixture, which must be of kind.
+func (p *ReplayProvider) take(kind string) (Fixture, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.next >= len(p.fixtures) {
+		return Fixture{}, ErrReplayExhausted
+	}
+	f := p.fixtures[p.next]
+	if f.Kind != kind {
+		return Fixture{}, fmt.Errorf("replay: call %d was %s, got %s", p.next+1, f.Kind, kind)
+	}
+	p.next++
+	return f, nil
+}
+
+func (p *ReplayProvider) Solve(ctx context.Context, images [][]byte, transcript string, onDelta func(string)) (string, error) {
+	f, err := p.take("solve")
+	if err != nil {
+		return "", err
+	}
+	_, _, conv := p.snapshot()
+	var hashes []string
+	for _, img := range images {
+		hashes = append(hashes, imageHash(img))
+	}
+	checkReplay(f, "transcript", f.Request.Transcript, transcript)
+	checkReplay(f, "images", fmt.Sprint(f.Request.Images), fmt.Sprint(hashes))
+	user := solveMessage(images, f.Request.contextParts(), f.Request.Prompt, transcript, f.Request.Lang)
+	user.Profile = f.Request.Profile
+	return p.play(ctx, f, conv, user, onDelta)
+}
+
+func (p *ReplayProvider) FollowUp(ctx context.Context, text string, onDelta func(string)) (string, error) {
+	f, err := p.take("followup")
+	if err != nil {
+		return "", err
+	}
+	_, _, conv := p.snapshot()
+	checkReplay(f, "follow-up", f.Request.Text, text)
+	return p.play(ctx, f, conv, followUpMessage(f.Request.contextParts(), text), onDelta)
+}
+
+func (p *ReplayProvider) Reply(ctx context.Context, history []model.Message, user model.Message, onDelta func(string)) (string, error) {
+	f, err := p.take("reply")
+	if err != nil {
+		return "", err
+	}
+	checkReplay(f, "turn", f.Request.Text, fixtureMessage(user).Text)
+	return p.play(ctx, f, nil, model.Message{}, onDelta)
+}
+
+func (p *ReplayProvider) Action(ctx context.Context, a model.HotkeyAction, images [][]byte, transcript string, onDelta func(string)) (string, error) {
+	f, err := p.take("action")
+	if err != nil {
+		return "", err
+	}
+	_, _, conv := p.snapshot()
+	checkReplay(f, "action", f.Request.Text, model.ActionNames[a])
+	checkReplay(f, "transcript", f.Request.Transcript, transcript)
+	user := solveMessage(images, f.Request.contextParts(), f.Request.Prompt, "", "")
+	user.Input = f.Request.Prompt
+	return p.play(ctx, f, conv, user, onDelta)
+}
+
+func (p *ReplayProvider) Summarize(ctx context.Context, text string) (string, error) {
+	f, err := p.take("summarize")
+	if err != nil {
+		return "", err
+	}
+	checkReplay(f, "summarize input", f.Request.Text, text)
+	return p.play(ctx, f, nil, model.Message{}, nil)
+}
+
+func checkReplay(f Fixture, what, recorded, got string) {
+	if recorded != got {
+		applog.AppLog.Warn("replay: %s %s differs from the recording", f.Kind, what)
+	}
+}
+
+// play streams f's deltas and outcome. With a conversation, the user turn
+// and reply are recorded the way exchange does, including a partial reply
+// when ctx is cancelled mid-stream or the recorded call was interrupted.
+func (p *ReplayProvider) play(ctx context.Context, f Fixture, conv *model.Conversation, user model.Message, onDelta func(string)) (string, error) {
+	idx := -1
+	if conv != nil {
+		idx = conv.Append(user)
+	}
+	hooks := model.StreamHooksFrom(ctx)
+	start := time.Now()
+	var streamed string
+	for _, d := range f.Deltas {
+		if err := p.wait(ctx, start, d.At); err != nil {
+			return p.interrupted(conv, idx, f.Model, streamed)
+		}
+		if d.Reset {
+			streamed = ""
+			hooks.ResetStream()
+		}
+		streamed += d.Text
+		if d.Text != "" && onDelta != nil {
+			onDelta(d.Text)
+		}
+	}
+	record(ctx, f.Model, f.Usage)
+	if f.Err != nil && f.Err.Kind == fixtureErrInterrupted {
+		return p.interrupted(conv, idx, f.Model, f.Answer)
+	}
+	if f.Err != nil {
+		if conv != nil {
+			conv.Truncate(idx)
+		}
+		return "", f.Err.err()
+	}
+	if conv != nil {
+		conv.Append(model.Message{Role: model.RoleAssistant, Parts: []model.Part{model.TextPart(f.Answer)}, Model: f.Model})
+	}
+	return f.Answer, nil
+}
+
+// wait sleeps until at milliseconds (scaled by Speed) after start.
+func (p *ReplayProvider) wait(ctx context.Context, start time.Time, at int64) error {
+	delay := time.Until(start.Add(time.Duration(float64(at)*p.Speed) * time.Millisecond))
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *ReplayProvider) interrupted(conv *model.Conversation, idx int, modelName, partial string) (string, error) {
+	if conv == nil {
+		return partial, model.ErrInterrupted
+	}
+	if partial == "" {
+		conv.Truncate(idx)
+		return "", model.ErrInterrupted
+	}
+	conv.Append(model.Message{Role: model.RoleAssistant, Parts: []model.Part{model.TextPart(partial)}, Model: modelName, Interrupted: true})
+	return partial, model.ErrInterrupted
+}
@@ -0,0 +1,145 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"second-nature/internal/model"
+)
+
+// call is one step of a recorded session, run against the recorder and
+// then against its replay.
+type call struct {
+	name string
+	run  func(p model.Provider) (string, error)
+}
+
+// turn is the part of a message a replay must reproduce.
+type turn struct {
+	Role        model.Role
+	Parts       []model.Part
+	Model       string
+	Interrupted bool
+	Input, Lang string
+	Profile     string
+}
+
+func turns(messages []model.Message) []turn {
+	out := make([]turn, 0, len(messages))
+	for _, m := range messages {
+		out = append(out, turn{m.Role, m.Parts, m.Model, m.Interrupted, m.Input, m.Lang, m.Profile})
+	}
+	return out
+}
+
+func TestRecordReplayRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	responses := []func(w http.ResponseWriter, r *http.Request){
+		func(w http.ResponseWriter, r *http.Request) {
+			writeSSE(w, `{"choices":[{"delta":{"content":"Use a map."}}]}`, `{"choices":[],"usage":{"prompt_tokens":50,"completion_tokens":4}}`, `[DONE]`)
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			writeSSE(w, `{"choices":[{"delta":{"content":"Because "}}]}`, `{"choices":[{"delta":{"content":"lookups are O(1)."}}]}`, `[DONE]`)
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			writeSSE(w, `{"choices":[{"delta":{"content":"Partial"}}]}`)
+			<-r.Context().Done() // the client stops the answer here
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			writeSSE(w, `{"choices":[{"delta":{"content":"A summary."}}]}`, `[DONE]`)
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"model not found"}`)
+		},
+	}
+	n := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responses[n](w, r)
+		n++
+	}))
+	defer srv.Close()
+	local := NewLocalProvider(srv.URL+"/v1", "qwen2.5-coder")
+	local.SetContextRoots([]string{root})
+	local.SetLanguage("Go")
+
+	rec, err := NewRecorder(local, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := []call{
+		{"solve", func(p model.Provider) (string, error) {
+			return p.Solve(context.Background(), nil, "two sum", nil)
+		}},
+		{"followup", func(p model.Provider) (string, error) {
+			return p.FollowUp(context.Background(), "why?", nil)
+		}},
+		{"interrupted", func(p model.Provider) (string, error) {
+			ctx, stop := context.WithCancel(context.Background())
+			defer stop()
+			return p.FollowUp(ctx, "and then?", func(string) { stop() })
+		}},
+		{"summarize", func(p model.Provider) (string, error) {
+			return p.Summarize(context.Background(), "long text")
+		}},
+		{"api error", func(p model.Provider) (string, error) {
+			return p.FollowUp(context.Background(), "again", nil)
+		}},
+	}
+	type result struct {
+		answer string
+		err    error
+	}
+	var recorded []result
+	for _, c := range calls {
+		answer, err := c.run(rec)
+		recorded = append(recorded, result{answer, err})
+	}
+	want := turns(rec.Conversation().Messages())
+
+	if len(want) != 6 || want[0].Parts[0].ContextHash == "" || !want[5].Interrupted {
+		t.Fatalf("recorded history = %+v, want a context block in the solve turn and an interrupted last answer", want)
+	}
+	if !errors.Is(recorded[2].err, model.ErrInterrupted) || recorded[2].answer != "Partial" {
+		t.Fatalf("recorded interruption = %q, %v", recorded[2].answer, recorded[2].err)
+	}
+
+	rp, err := LoadReplay(rec.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp.Speed = 0
+	for i, c := range calls {
+		answer, err := c.run(rp)
+		if answer != recorded[i].answer {
+			t.Errorf("%s: answer %q, recorded %q", c.name, answer, recorded[i].answer)
+		}
+		if fmt.Sprint(err) != fmt.Sprint(recorded[i].err) {
+			t.Errorf("%s: error %v, recorded %v", c.name, err, recorded[i].err)
+		}
+		if i == 2 && !errors.Is(err, model.ErrInterrupted) {
+			t.Errorf("%s: error %v is not ErrInterrupted", c.name, err)
+		}
+		var apiErr *model.APIError
+		if i == 4 && (!errors.As(err, &apiErr) || apiErr.Kind != model.ErrKindModelNotFound || apiErr.Status != http.StatusNotFound) {
+			t.Errorf("%s: error %#v, want the recorded APIError", c.name, err)
+		}
+	}
+	if _, err := calls[2].run(rp); !errors.Is(err, ErrReplayExhausted) {
+		t.Errorf("call past the end: %v, want ErrReplayExhausted", err)
+	}
+
+	if got := turns(rp.Conversation().Messages()); !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed history\n%+v\nrecorded\n%+v", got, want)
+	}
+}
@@ -0,0 +1,184 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go"
+
+	"second-nature/internal/model"
+)
+
+const (
+	maxRetries    = 3
+	retryBaseWait = 2 * time.Second
+	retryMaxWait  = 30 * time.Second
+)
+
+// statusError is a non-2xx response from a provider spoken to over plain HTTP.
+type statusError struct {
+	Status int
+	Header http.Header
+	Body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), e.Body)
+}
+
+var statusKinds = map[int]model.ErrorKind{
+	http.StatusTooManyRequests:       model.ErrKindRateLimited,
+	529:                              model.ErrKindOverloaded,
+	http.StatusServiceUnavailable:    model.ErrKindOverloaded,
+	http.StatusBadGateway:            model.ErrKindOverloaded,
+	http.StatusGatewayTimeout:        model.ErrKindOverloaded,
+	http.StatusInternalServerError:   model.ErrKindOverloaded,
+	http.StatusUnauthorized:          model.ErrKindAuth,
+	http.StatusForbidden:             model.ErrKindAuth,
+	http.StatusRequestEntityTooLarge: model.ErrKindContextTooLong,
+	http.StatusNotFound:              model.ErrKindModelNotFound, // unknown model, or a wrong base URL
+}
+
+// messageKinds classifies errors that carry no usable status code, such as
+// error events received mid-stream. Checked in order.
+var messageKinds = []struct {
+	substr string
+	kind   model.ErrorKind
+}{
+	{"overloaded", model.ErrKindOverloaded},
+	{"rate_limit", model.ErrKindRateLimited},
+	{"rate limit", model.ErrKindRateLimited},
+	{"prompt is too long", model.ErrKindContextTooLong},
+	{"context_length_exceeded", model.ErrKindContextTooLong},
+	{"maximum context length", model.ErrKindContextTooLong},
+	{"context window", model.ErrKindContextTooLong},
+	{"authentication_error", model.ErrKindAuth},
+	{"invalid_api_key", model.ErrKindAuth},
+	{"model_not_found", model.ErrKindModelNotFound},
+	{"connection reset", model.ErrKindNetwork},
+	{"stream error", model.ErrKindNetwork},
+	{"unexpected eof", model.ErrKindNetwork},
+}
+
+// classify wraps err in a *model.APIError describing what went wrong.
+func classify(err error) *model.APIError {
+	var apiErr *model.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	status, header := httpStatus(err)
+	kind := kindOf(err, status)
+	return &model.APIError{Kind: kind, Status: status, RetryAfter: retryAfter(header), Err: err}
+}
+
+func kindOf(err error, status int) model.ErrorKind {
+	if kind, ok := statusKinds[status]; ok {
+		return kind
+	}
+	msg := strings.ToLower(err.Error())
+	for _, mk := range messageKinds {
+		if strings.Contains(msg, mk.substr) {
+			return mk.kind
+		}
+	}
+	if isNetworkError(err) {
+		return model.ErrKindNetwork
+	}
+	return model.ErrKindUnknown
+}
+
+func httpStatus(err error) (int, http.Header) {
+	var ae *anthropic.Error
+	if errors.As(err, &ae) && ae.Response != nil {
+		return ae.StatusCode, ae.Response.Header
+	}
+	var oe *openai.Error
+	if errors.As(err, &oe) && oe.Response != nil {
+		return oe.StatusCode, oe.Response.Header
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.Status, se.Header
+	}
+	return 0, nil
+}
+
+func isNetworkError(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// retryAfter parses a Retry-After header given in seconds.
+func retryAfter(h http.Header) time.Duration {
+	if h == nil {
+		return 0
+	}
+	secs, err := strconv.Atoi(h.Get("Retry-After"))
+	if err != nil || secs <= 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// backoff returns the wait before retry number attempt (0-based):
+// 2s, 4s, 8s… capped at retryMaxWait, or the server's Retry-After if longer.
+func backoff(attempt int, hint time.Duration) time.Duration {
+	wait := retryBaseWait << attempt
+	if hint > wait {
+		wait = hint
+	}
+	if wait > retryMaxWait {
+		wait = retryMaxWait
+	}
+	return wait
+}
+
+// withRetry runs call, retrying retryable failures with exponential backoff.
+// Retries are announced through the context's StreamHooks, and the renderer
+// is reset so the restarted answer does not duplicate text or reasoning
+// deltas from the failed attempt.
+// Usage is summed over all attempts since failed ones may still be billed.
+func withRetry(ctx context.Context, onDelta func(string), call attemptFn) (string, model.Usage, error) {
+	hooks := model.StreamHooksFrom(ctx)
+	var total model.Usage
+	for attempt := 0; ; attempt++ {
+		text, u, err := call(onDelta)
+		total = total.Add(u)
+		if err == nil || ctx.Err() != nil {
+			return text, total, err
+		}
+		apiErr := classify(err)
+		if !apiErr.Retryable() || attempt >= maxRetries || errors.As(err, new(afterTools)) {
+			return text, total, apiErr
+		}
+		wait := backoff(attempt, apiErr.RetryAfter)
+		hooks.SetStatus(fmt.Sprintf("%s — retrying %d/%d in %s", model.ErrorKindNames[apiErr.Kind], attempt+1, maxRetries, wait))
+		hooks.ResetStream()
+		if !sleepCtx(ctx, wait) {
+			return "", total, ctx.Err()
+		}
+	}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,127 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"second-nature/internal/applog"
+	"second-nature/internal/model"
+)
+
+// Router sends each call to the provider configured for its route (see
+// model.WithRoute) and everything else to the default provider. Routed
+// providers share the default's conversation, context directory and
+// language, so a follow-up answered by another model still sees the whole
+// history.
+type Router struct {
+	model.Provider
+	routes map[string]routed
+}
+
+type routed struct {
+	p         model.Provider
+	maxTokens int64
+}
+
+// NewRouter wraps def with one dedicated provider per route. A route with
+// no provider only sets the token limit for def, so it must not name a
+// model or base URL either.
+func NewRouter(def model.Provider, routes map[string]model.Route) (*Router, error) {
+	r := &Router{Provider: def, routes: make(map[string]routed, len(routes))}
+	for name, route := range routes {
+		p, err := routeProvider(def, route)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", name, err)
+		}
+		p.SetConversation(def.Conversation())
+		p.SetContextRoots(def.ContextRoots())
+		r.routes[name] = routed{p: p, maxTokens: route.MaxTokens}
+	}
+	return r, nil
+}
+
+func routeProvider(def model.Provider, route model.Route) (model.Provider, error) {
+	if route.Provider == "" && (route.Model != "" || route.BaseURL != "") {
+		return nil, fmt.Errorf("model and base_url need a provider; without one a route only sets max_tokens")
+	}
+	if route.Provider == "" {
+		return def, nil
+	}
+	return NewForTarget(model.CompareTarget{Provider: route.Provider, Model: route.Model, BaseURL: route.BaseURL})
+}
+
+// For returns the provider that serves route.
+func (r *Router) For(route string) model.Provider {
+	if rt, ok := r.routes[route]; ok {
+		return rt.p
+	}
+	return r.Provider
+}
+
+// route picks the provider for a call, by the context's route or else
+// fallback, applies the route's token limit and reports the model.
+func (r *Router) route(ctx context.Context, fallback string) (context.Context, model.Provider) {
+	name := model.RouteFrom(ctx)
+	if name == "" {
+		name = fallback
+	}
+	rt, ok := r.routes[name]
+	if !ok {
+		model.StreamHooksFrom(ctx).ReportServed(r.Provider.ModelName())
+		return ctx, r.Provider
+	}
+	if rt.maxTokens > 0 {
+		ctx = model.WithMaxTokens(ctx, rt.maxTokens)
+	}
+	applog.AppLog.Info("route: %s → %s", name, rt.p.ModelName())
+	model.StreamHooksFrom(ctx).ReportServed(rt.p.ModelName())
+	return ctx, rt.p
+}
+
+func (r *Router) Solve(ctx context.Context, images [][]byte, transcript string, onDelta func(string)) (string, error) {
+	ctx, p := r.route(ctx, model.RouteSolve)
+	return p.Solve(ctx, images, transcript, onDelta)
+}
+
+func (r *Router) FollowUp(ctx context.Context, text string, onDelta func(string)) (string, error) {
+	ctx, p := r.route(ctx, model.RouteFollowUp)
+	return p.FollowUp(ctx, text, onDelta)
+}
+
+func (r *Router) Reply(ctx context.Context, history []model.Message, user model.Message, onDelta func(string)) (string, error) {
+	ctx, p := r.route(ctx, model.RouteFollowUp)
+	return p.Reply(ctx, history, user, onDelta)
+}
+
+// Action routes by the context's route, which the dispatcher sets to the
+// action's name, falling back to the follow-up route.
+func (r *Router) Action(ctx context.Context, a model.HotkeyAction, images [][]byte, transcript string, onDelta func(string)) (string, error) {
+	ctx, p := r.route(ctx, model.RouteFollowUp)
+	return p.Action(ctx, a, images, transcript, onDelta)
+}
+
+func (r *Router) Summarize(ctx context.Context, text string) (string, error) {
+	ctx, p := r.route(ctx, "")
+	return p.Summarize(ctx, text)
+}
+
+func (r *Router) SetLanguage(lang string) {
+	r.Provider.SetLanguage(lang)
+	for _, rt := range r.routes {
+		rt.p.SetLanguage(lang)
+	}
+}
+
+func (r *Router) SetContextRoots(roots []string) {
+	r.Provider.SetContextRoots(roots)
+	for _, rt := range r.routes {
+		rt.p.SetContextRoots(roots)
+	}
+}
+
+func (r *Router) SetConversation(c *model.Conversation) {
+	r.Provider.SetConversation(c)
+	for _, rt := range r.routes {
+		rt.p.SetConversation(c)
+	}
+}
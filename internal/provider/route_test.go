@@ -0,0 +1,42 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+
+	"second-nature/internal/model"
+)
+
+func TestNewRouter(t *testing.T) {
+	def := NewLocalProvider("", "default-model")
+	tests := []struct {
+		name    string
+		route   model.Route
+		model   string // served model, "" when NewRouter must fail
+		wantErr string
+	}{
+		{"token limit only", model.Route{MaxTokens: 1024}, "default-model", ""},
+		{"own provider", model.Route{Provider: model.ProviderLocal, Model: "small-model"}, "small-model", ""},
+		{"model without provider", model.Route{Model: "small-model"}, "", "need a provider"},
+		{"base url without provider", model.Route{BaseURL: "http://localhost:11434/v1"}, "", "need a provider"},
+		{"unknown provider", model.Route{Provider: "mistral", Model: "x"}, "", "unknown compare provider"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRouter(def, map[string]model.Route{model.RouteSummary: tt.route})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), `route "summary"`) {
+					t.Errorf("err = %v, want one naming the route and %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := r.For(model.RouteSummary)
+			if p.ModelName() != tt.model || p.Conversation() != def.Conversation() {
+				t.Errorf("route serves %q on its own conversation: %v", p.ModelName(), p.Conversation() != def.Conversation())
+			}
+		})
+	}
+}
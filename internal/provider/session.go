@@ -0,0 +1,72 @@
+package provider
+
+import (
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"second-nature/internal/model"
+)
+
+// session is the per-provider state that UI callbacks change while calls
+// may be in flight. Each provider embeds it; calls take a snapshot up front
+// so a language or context root switch mid-stream applies to the next call.
+type session struct {
+	mu    sync.Mutex
+	lang  string
+	roots []string // context roots: directories and single files
+	conv  *model.Conversation
+}
+
+func newSession() session {
+	return session{lang: "Python", conv: model.NewConversation()}
+}
+
+func (s *session) SetLanguage(lang string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lang = lang
+}
+
+// SetContextRoots replaces the context roots, dropping empty and repeated
+// paths.
+func (s *session) SetContextRoots(roots []string) {
+	var clean []string
+	for _, r := range roots {
+		if r != "" && !slices.Contains(clean, filepath.Clean(r)) {
+			clean = append(clean, filepath.Clean(r))
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roots = clean
+}
+
+func (s *session) ContextRoots() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.roots)
+}
+
+func (s *session) Conversation() *model.Conversation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conv
+}
+
+func (s *session) SetConversation(c *model.Conversation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conv = c
+}
+
+func (s *session) ClearHistory() { s.Conversation().Clear() }
+
+func (s *session) HistoryLen() int { return s.Conversation().Len() }
+
+// snapshot returns the settings for one call.
+func (s *session) snapshot() (lang string, roots []string, conv *model.Conversation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lang, slices.Clone(s.roots), s.conv
+}
@@ -0,0 +1,43 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+const sseMaxLine = 4 * 1024 * 1024
+
+var sseDone = []byte("[DONE]")
+
+// readSSE reads a server-sent event stream and calls onData with the
+// payload of every "data:" line. Stops at the "[DONE]" sentinel or EOF.
+func readSSE(r io.Reader, onData func([]byte) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), sseMaxLine)
+	for sc.Scan() {
+		data := sseData(sc.Bytes())
+		if bytes.Equal(data, sseDone) {
+			return nil
+		}
+		if err := emitSSE(data, onData); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// sseData returns the payload of a "data:" line, or nil for any other line.
+func sseData(line []byte) []byte {
+	if !bytes.HasPrefix(line, []byte("data:")) {
+		return nil
+	}
+	return bytes.TrimSpace(line[len("data:"):])
+}
+
+func emitSSE(data []byte, onData func([]byte) error) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return onData(data)
+}
@@ -0,0 +1,246 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"second-nature/internal/applog"
+	appctx "second-nature/internal/context"
+	"second-nature/internal/model"
+	"second-nature/internal/sandbox"
+)
+
+// ToolConfig controls the agentic tool loop. Allowed names the tools the
+// model may call; empty disables tool use. After MaxIterations rounds of
+// tool calls the model must answer without tools.
+type ToolConfig struct {
+	Allowed       []string
+	MaxIterations int
+	Transcript    func() []model.TranscriptEntry // source for search_transcript
+}
+
+// Tools is the active tool policy; set from AppConfig at startup.
+var Tools = ToolConfig{MaxIterations: 6}
+
+const toolOutputLimit = 20 * 1024
+
+// tool is a provider-neutral tool definition. schema holds the JSON schema
+// properties of the input object.
+type tool struct {
+	name        string
+	description string
+	schema      map[string]any
+	required    []string
+	run         func(ctx context.Context, contextRoots []string, input json.RawMessage) (string, error)
+}
+
+var toolDefs = []tool{
+	{
+		name:        "list_files",
+		description: "List the files in the user's context directories (paths relative to each; with several directories, prefixed by the directory's name).",
+		schema:      map[string]any{},
+		run:         listFilesTool,
+	},
+	{
+		name:        "read_file",
+		description: "Read one file from the user's context directories. Use a path returned by list_files.",
+		schema: map[string]any{
+			"path": map[string]any{"type": "string", "description": "path as returned by list_files"},
+		},
+		required: []string{"path"},
+		run:      readFileTool,
+	},
+	{
+		name:        "run_code",
+		description: "Run a complete program in a local sandbox (10s timeout) and return stdout, stderr and the exit code. Use it to verify code before answering.",
+		schema: map[string]any{
+			"language": map[string]any{"type": "string", "enum": []string{"python", "go", "javascript", "typescript", "cpp", "rust", "java"}},
+			"code":     map[string]any{"type": "string", "description": "full source; Java must declare class Main"},
+		},
+		required: []string{"language", "code"},
+		run:      runCodeTool,
+	},
+	{
+		name:        "search_transcript",
+		description: "Search the captured audio transcript for a word or phrase (case-insensitive) and return matching entries with timestamps.",
+		schema: map[string]any{
+			"query": map[string]any{"type": "string"},
+		},
+		required: []string{"query"},
+		run:      searchTranscriptTool,
+	},
+}
+
+// activeTools returns the tool definitions enabled by Tools.Allowed.
+func activeTools() []tool {
+	var out []tool
+	for _, t := range toolDefs {
+		if slices.Contains(Tools.Allowed, t.name) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// toolsUnsupported is returned by providers without a tool loop while
+// tools are enabled, rather than answering as if none were.
+func toolsUnsupported(modelName string) error {
+	if len(activeTools()) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s cannot call tools; remove \"tools\" from config.json or use a Claude or OpenAI model", modelName)
+}
+
+// afterTools marks an error from a round that followed tool calls.
+// withRetry does not repeat such a call: its tools would run again.
+type afterTools struct{ err error }
+
+func (e afterTools) Error() string { return e.err.Error() }
+func (e afterTools) Unwrap() error { return e.err }
+
+// roundErr wraps err as afterTools once iteration rounds have run tools.
+func roundErr(err error, iteration int) error {
+	if err == nil || iteration == 0 {
+		return err
+	}
+	return afterTools{err}
+}
+
+// toolsExhausted reports whether the loop has used up its iterations.
+func toolsExhausted(iteration int) bool {
+	return iteration >= max(Tools.MaxIterations, 1)
+}
+
+// answerStream joins the text of successive tool-loop rounds with a blank
+// line, both in the final answer and in the streamed deltas. A structured
+// answer keeps only the final round instead, since text from a round that
+// ended in a tool call would break the JSON.
+type answerStream struct {
+	buf     strings.Builder
+	onDelta func(string)
+	onReset func() // set for structured answers
+	pending bool
+}
+
+func newAnswerStream(ctx context.Context, onDelta func(string)) *answerStream {
+	a := &answerStream{onDelta: onDelta}
+	if wantsAnswerSchema(ctx) {
+		a.onReset = model.StreamHooksFrom(ctx).ResetStream
+	}
+	return a
+}
+
+func (a *answerStream) delta(d string) {
+	if a.pending {
+		a.pending = false
+		a.write("\n\n")
+	}
+	a.write(d)
+}
+
+func (a *answerStream) write(d string) {
+	a.buf.WriteString(d)
+	if a.onDelta != nil {
+		a.onDelta(d)
+	}
+}
+
+// nextRound starts a new round: its first delta gets a separator, or, for a
+// structured answer, the earlier rounds' text is discarded.
+func (a *answerStream) nextRound() {
+	if a.onReset != nil && a.buf.Len() > 0 {
+		a.buf.Reset()
+		a.onReset()
+		return
+	}
+	a.pending = a.buf.Len() > 0
+}
+
+// jsonSchema builds the input schema object shared by both SDKs.
+func (t tool) jsonSchema() map[string]any {
+	return map[string]any{"type": "object", "properties": t.schema, "required": append([]string{}, t.required...)}
+}
+
+// runTool executes one tool call and reports start and result through the
+// context's hooks so the renderer can show it inline.
+func runTool(ctx context.Context, contextRoots []string, id, name string, input json.RawMessage) (string, bool) {
+	hooks := model.StreamHooksFrom(ctx)
+	ev := model.ToolEvent{ID: id, Name: name, Input: string(input)}
+	hooks.ReportTool(ev)
+
+	idx := slices.IndexFunc(activeTools(), func(t tool) bool { return t.name == name })
+	out, err := "", fmt.Errorf("tool %q is not enabled", name)
+	if idx >= 0 {
+		out, err = activeTools()[idx].run(ctx, contextRoots, input)
+	}
+	if err != nil {
+		out = "error: " + err.Error()
+	}
+	if len(out) > toolOutputLimit {
+		out = out[:toolOutputLimit] + "\n[…truncated]"
+	}
+	applog.AppLog.Info("tools: %s %s → %d bytes, error=%v", name, input, len(out), err != nil)
+
+	ev.Output, ev.IsError, ev.Done = out, err != nil, true
+	hooks.ReportTool(ev)
+	return out, err != nil
+}
+
+func listFilesTool(_ context.Context, contextRoots []string, _ json.RawMessage) (string, error) {
+	if len(contextRoots) == 0 {
+		return "", fmt.Errorf("no context directory selected")
+	}
+	return strings.Join(appctx.ListContextFiles(contextRoots), "\n"), nil
+}
+
+func readFileTool(_ context.Context, contextRoots []string, input json.RawMessage) (string, error) {
+	var args struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(input, &args); err != nil {
+		return "", fmt.Errorf("bad arguments: %w", err)
+	}
+	return appctx.ReadContextFile(contextRoots, args.Path)
+}
+
+func runCodeTool(_ context.Context, _ []string, input json.RawMessage) (string, error) {
+	var args struct {
+		Language string `json:"language"`
+		Code     string `json:"code"`
+	}
+	if err := json.Unmarshal(input, &args); err != nil {
+		return "", fmt.Errorf("bad arguments: %w", err)
+	}
+	res := sandbox.RunSandbox(args.Code, args.Language)
+	out := fmt.Sprintf("exit code: %d\nstdout:\n%s\nstderr:\n%s", res.ExitCode, res.Stdout, res.Stderr)
+	if res.Error != "" {
+		out += "\nerror: " + res.Error
+	}
+	return out, nil
+}
+
+func searchTranscriptTool(_ context.Context, _ []string, input json.RawMessage) (string, error) {
+	var args struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal(input, &args); err != nil {
+		return "", fmt.Errorf("bad arguments: %w", err)
+	}
+	if Tools.Transcript == nil {
+		return "", fmt.Errorf("no transcript available")
+	}
+	q := strings.ToLower(args.Query)
+	var b strings.Builder
+	for _, e := range Tools.Transcript() {
+		if strings.Contains(strings.ToLower(e.Text), q) {
+			fmt.Fprintf(&b, "[%s %s] %s\n", e.Time.Format("15:04:05"), e.Source, e.Text)
+		}
+	}
+	if b.Len() == 0 {
+		return "no matches", nil
+	}
+	return b.String(), nil
+}
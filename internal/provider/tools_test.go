@@ -0,0 +1,95 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"second-nature/internal/model"
+)
+
+// withTools enables the named tools for the duration of the test.
+func withTools(t *testing.T, names ...string) {
+	t.Helper()
+	saved := Tools
+	Tools.Allowed = names
+	t.Cleanup(func() { Tools = saved })
+}
+
+func TestToolsRejectedWithoutToolLoop(t *testing.T) {
+	withTools(t, "run_code")
+	p, calls := localServer(t, func(w http.ResponseWriter, cr chatRequest) {
+		writeSSE(w, `{"choices":[{"delta":{"content":"answer"}}]}`, `[DONE]`)
+	})
+	_, err := p.FollowUp(context.Background(), "hi", nil)
+	if err == nil || !strings.Contains(err.Error(), "cannot call tools") {
+		t.Fatalf("err = %v, want tools rejected", err)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("%d requests sent, want none", n)
+	}
+	if n := p.HistoryLen(); n != 0 {
+		t.Errorf("history has %d messages, want the user turn rolled back", n)
+	}
+
+	// Summaries never offer tools, so they still work.
+	if _, err := p.Summarize(context.Background(), "text"); err != nil {
+		t.Errorf("Summarize: %v", err)
+	}
+}
+
+func TestNoRetryAfterTools(t *testing.T) {
+	overloaded := &statusError{Status: http.StatusServiceUnavailable, Body: "overloaded"}
+	attempts := 0
+	_, _, err := withRetry(context.Background(), nil, func(func(string)) (string, model.Usage, error) {
+		attempts++
+		return "", model.Usage{}, roundErr(overloaded, 1)
+	})
+	if attempts != 1 {
+		t.Errorf("%d attempts, want 1: the tools must not run again", attempts)
+	}
+	if classify(err).Kind != model.ErrKindOverloaded {
+		t.Errorf("err = %v, want the overloaded error kept", err)
+	}
+	if roundErr(overloaded, 0) != error(overloaded) {
+		t.Error("roundErr wrapped an error from the first round, before any tool ran")
+	}
+}
+
+// TestStructuredAnswerKeepsFinalRound checks that prose streamed before a
+// tool call does not end up in front of the JSON answer.
+func TestStructuredAnswerKeepsFinalRound(t *testing.T) {
+	saved := StructuredAnswers
+	t.Cleanup(func() { StructuredAnswers = saved })
+	StructuredAnswers = true
+
+	var shown strings.Builder
+	resets := 0
+	ctx := model.WithStreamHooks(context.Background(), model.StreamHooks{Reset: func() {
+		resets++
+		shown.Reset()
+	}})
+	ctx, _ = structure(ctx, "")
+	answer := newAnswerStream(ctx, func(d string) { shown.WriteString(d) })
+	answer.nextRound()
+	answer.delta("Let me run the code first.")
+	answer.nextRound()
+	answer.delta(`{"goal":"g","tldr":"t","approach":"a","artifacts":[],"complexity":"c","edge_cases":[]}`)
+
+	if _, err := model.ParseAnswer(answer.buf.String()); err != nil {
+		t.Errorf("ParseAnswer(%q): %v", answer.buf.String(), err)
+	}
+	if shown.String() != answer.buf.String() || resets != 1 {
+		t.Errorf("renderer shows %q after %d resets, want the final round after 1", shown.String(), resets)
+	}
+
+	plain := newAnswerStream(context.Background(), nil)
+	plain.nextRound()
+	plain.delta("first")
+	plain.nextRound()
+	plain.delta("second")
+	if got := plain.buf.String(); got != "first\n\nsecond" {
+		t.Errorf("free-form answer = %q, want the rounds joined", got)
+	}
+}
@@ -112,6 +112,7 @@ import "C"
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
 	"encoding/base64"
 	"encoding/json"
@@ -119,6 +120,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -139,6 +141,7 @@ import (
 	"second-nature/internal/model"
 	"second-nature/internal/sandbox"
 	"second-nature/internal/system"
+	"second-nature/internal/usage"
 )
 
 //go:embed overlay.css
@@ -150,28 +153,44 @@ var overlayJS string
 var ClearOnProcess atomic.Bool
 
 type OverlayRenderer struct {
-	w         webview.WebView
-	gtkWin    unsafe.Pointer
-	md        goldmark.Markdown
-	chromaCS  string
-	streamBuf strings.Builder
-	pendingMu sync.Mutex
-	pendingJS strings.Builder
-	closed    atomic.Bool
+	w                  webview.WebView
+	gtkWin             unsafe.Pointer
+	md                 goldmark.Markdown
+	chromaCS           string
+	streamBuf          strings.Builder
+	reasoningBuf       strings.Builder
+	toolsBuf           strings.Builder
+	artifactsMu        sync.Mutex
+	artifacts          []model.Artifact
+	compareModels      []string
+	streamSeq          int // user turn a regenerated answer replaces
+	compareTraceID     int
+	comparePending     int
+	pendingMu          sync.Mutex
+	pendingJS          strings.Builder
+	closed             atomic.Bool
 	currentTraceID     int
+	interrupted        bool
 	onAction           func(model.HotkeyAction)
 	onToggleChunk      func(int, bool)
 	onToggleScreenshot func(int, bool)
 	onRemoveScreenshot func(int)
 	onRemoveTraces     func([]int)
 	onChatMessage      func(string)
+	onProfile          func(string)
+	onLanguage         func(string)
+	onComparePick      func(int)
+	onFork             func(int)
+	onSwitchBranch     func(int)
+	onRegenerate       func(int, string)
+	onEditResend       func(int, string)
 	appState           *model.AppState
 	ac                 *audio.AudioCapture
 	provider           model.Provider
-	vuJS   atomic.Pointer[string]
-	fsGeom atomic.Pointer[[4]int]
-	isFS       atomic.Bool
-	needsRaise atomic.Bool
+	vuJS               atomic.Pointer[string]
+	fsGeom             atomic.Pointer[[4]int]
+	isFS               atomic.Bool
+	needsRaise         atomic.Bool
 
 	sandboxMu   sync.Mutex
 	sandboxCode string
@@ -201,15 +220,11 @@ func NewOverlayRenderer() *OverlayRenderer {
 	}
 
 	// Bind action handler for clickable overlay buttons.
-	nameToAction := make(map[string]model.HotkeyAction, len(model.ActionNames))
-	for a, n := range model.ActionNames {
-		nameToAction[n] = a
-	}
 	w.Bind("_action", func(name string) {
 		if o.onAction == nil {
 			return
 		}
-		a, ok := nameToAction[name]
+		a, ok := model.ActionByName(name)
 		if !ok {
 			return
 		}
@@ -296,23 +311,35 @@ func NewOverlayRenderer() *OverlayRenderer {
 		go func() {
 			defer o.needsRaise.Store(true)
 			path := pickPath(mode)
-			if path == "" {
+			if path == "" || o.provider == nil {
 				return
 			}
-			if o.provider != nil {
-				o.provider.SetContextDir(path)
-			}
-			appctx.CtxFileSelection.Clear()
-			o.SetFileSysLabel(path)
+			appctx.CtxFileSelection.Forget(path)
+			o.setContextRoots(append(o.provider.ContextRoots(), path))
 		}()
 	})
 
+	w.Bind("_removeContextRoot", func(root string) {
+		if o.provider == nil {
+			return
+		}
+		appctx.CtxFileSelection.Forget(root)
+		o.setContextRoots(slices.DeleteFunc(o.provider.ContextRoots(), func(r string) bool { return r == root }))
+	})
+
 	w.Bind("_getContextState", func() string {
 		return o.buildContextStateJSON()
 	})
+	appctx.CtxWatcher.SetChangeHandler(func() {
+		o.eval(`_refreshContext();`)
+	})
+
+	w.Bind("_toggleContextFile", func(root, path string, excluded bool) {
+		appctx.CtxFileSelection.SetExcluded(root, path, excluded)
+	})
 
-	w.Bind("_toggleContextFile", func(path string, excluded bool) {
-		appctx.CtxFileSelection.SetExcluded(path, excluded)
+	w.Bind("_setContextMode", func(root, path, mode string) {
+		appctx.CtxFileSelection.SetMode(root, path, mode)
 	})
 
 	w.Bind("_removeTranscriptEntry", func(id int) {
@@ -327,10 +354,9 @@ func NewOverlayRenderer() *OverlayRenderer {
 		if t == nil {
 			return
 		}
-		if t.ContextDir != "" && o.provider != nil {
-			o.provider.SetContextDir(t.ContextDir)
+		if len(t.ContextRoots) > 0 && o.provider != nil {
 			appctx.CtxFileSelection.Clear()
-			o.SetFileSysLabel(t.ContextDir)
+			o.setContextRoots(t.ContextRoots)
 		}
 		o.restoreTraceScreenshots(t)
 	})
@@ -391,29 +417,17 @@ func NewOverlayRenderer() *OverlayRenderer {
 		return string(b)
 	})
 
-	w.Bind("_sendToSandbox", func(code, lang string) {
-		applog.AppLog.Info("overlay: sendToSandbox lang=%s code=%d bytes", lang, len(code))
-		o.sandboxMu.Lock()
-		o.sandboxCode = code
-		o.sandboxLang = lang
-		o.sandboxMu.Unlock()
+	w.Bind("_sendToSandbox", o.sendToSandbox)
 
-		js := "document.getElementById('sandbox-editor').value=" + jsString(code) + ";" +
-			"document.getElementById('sandbox-lang').textContent=" + jsString(lang) + ";" +
-			"document.getElementById('sandbox-tests').value='';" +
-			"document.getElementById('sandbox-tests-hl').innerHTML='';" +
-			"document.getElementById('sandbox-tests-wrap').style.display='none';" +
-			"document.getElementById('sandbox-tests-label').style.display='none';" +
-			"switchTab('sandbox');" +
-			"_autoSize(document.getElementById('sandbox-editor'));" +
-			"_syncHighlight('sandbox-editor','sandbox-editor-hl');" +
-			"document.getElementById('sandbox-output').innerHTML='<span style=\"color:#888\">running...</span>';"
-		o.eval(js)
-
-		go func() {
-			res := sandbox.RunSandbox(code, lang)
-			o.renderSandboxResult(res)
-		}()
+	w.Bind("_artifact", func(action string, idx int) {
+		o.artifactsMu.Lock()
+		if idx < 0 || idx >= len(o.artifacts) {
+			o.artifactsMu.Unlock()
+			return
+		}
+		a := o.artifacts[idx]
+		o.artifactsMu.Unlock()
+		o.artifactAction(action, a)
 	})
 
 	w.Bind("_runSandbox", func(code, tests, lang string) {
@@ -454,7 +468,13 @@ func NewOverlayRenderer() *OverlayRenderer {
 				"Use assertions with expected values (e.g. console.assert, assert, if/throw) that print a failure message when wrong and print nothing when correct. " +
 				"At the end, print a summary line like 'N/N tests passed'. " +
 				"Only output raw executable code — no markdown fences, no explanation.\n\n" + code
-			result, err := o.provider.Summarize(prompt)
+			ctx := model.WithStreamHooks(model.WithRoute(context.Background(), model.RouteTests), model.StreamHooks{
+				Status: o.SetStatus,
+				Usage: func(u model.Usage) {
+					o.UpdateUsage(0, model.Usage{}, usage.Session.Total())
+				},
+			})
+			result, err := o.provider.Summarize(ctx, prompt)
 			if err != nil {
 				o.eval("document.getElementById('sandbox-output').innerHTML=" + jsString(`<span class="sandbox-fail">error: `+err.Error()+`</span>`) + ";")
 				return
@@ -496,6 +516,56 @@ func NewOverlayRenderer() *OverlayRenderer {
 
 	w.Bind("_setClearOnProcess", func(on bool) { ClearOnProcess.Store(on) })
 
+	w.Bind("_setProfile", func(name string) {
+		if o.onProfile != nil {
+			o.onProfile(name)
+		}
+	})
+
+	w.Bind("_setLanguage", func(lang string) {
+		if o.onLanguage != nil {
+			o.onLanguage(lang)
+		}
+	})
+
+	w.Bind("_forkTrace", func(traceID int) {
+		if o.onFork != nil {
+			o.onFork(traceID)
+		}
+	})
+
+	w.Bind("_switchBranch", func(id int) {
+		if o.onSwitchBranch != nil {
+			o.onSwitchBranch(id)
+		}
+	})
+
+	w.Bind("_regenerate", func(seq int, modelName string) {
+		if o.onRegenerate != nil {
+			o.onRegenerate(seq, modelName)
+		}
+	})
+
+	w.Bind("_turnInput", func(seq int) string {
+		m, ok := o.turn(seq)
+		if !ok {
+			return ""
+		}
+		return m.Input
+	})
+
+	w.Bind("_editResend", func(seq int, text string) {
+		if o.onEditResend != nil && strings.TrimSpace(text) != "" {
+			o.onEditResend(seq, text)
+		}
+	})
+
+	w.Bind("_pickCompare", func(col int) {
+		if o.onComparePick != nil {
+			o.onComparePick(col)
+		}
+	})
+
 	w.SetHtml(o.buildShell())
 	C.show_window(gtkWin)
 	return o
@@ -525,13 +595,128 @@ func (o *OverlayRenderer) SetRemoveTracesHandler(fn func([]int)) {
 	o.onRemoveTraces = fn
 }
 
-func (o *OverlayRenderer) SetProvider(p model.Provider)            { o.provider = p }
-func (o *OverlayRenderer) SetAppState(s *model.AppState)           { o.appState = s }
-func (o *OverlayRenderer) SetAudioCapture(ac *audio.AudioCapture)  { o.ac = ac }
+func (o *OverlayRenderer) SetProfileHandler(fn func(string)) {
+	o.onProfile = fn
+}
+
+// SetLanguageHandler is called with the language picked next to the chat
+// input, or "auto" to go back to detection.
+func (o *OverlayRenderer) SetLanguageHandler(fn func(string)) {
+	o.onLanguage = fn
+}
 
-func (o *OverlayRenderer) SetFileSysLabel(path string) {
-	label := filepath.Base(path)
-	o.eval(`document.getElementById('btn-context').textContent=` + jsString("file sys: "+label) + `;` +
+// SetComparePickHandler is called with the column of the compare answer
+// the user chose to continue with.
+func (o *OverlayRenderer) SetComparePickHandler(fn func(int)) {
+	o.onComparePick = fn
+}
+
+// SetForkHandler is called with the trace a new branch should fork from.
+func (o *OverlayRenderer) SetForkHandler(fn func(int)) {
+	o.onFork = fn
+}
+
+func (o *OverlayRenderer) SetSwitchBranchHandler(fn func(int)) {
+	o.onSwitchBranch = fn
+}
+
+// SetRegenerateHandler is called with the user turn to answer again and
+// the compare model to answer it with, or "" for the active provider.
+func (o *OverlayRenderer) SetRegenerateHandler(fn func(int, string)) {
+	o.onRegenerate = fn
+}
+
+// SetEditResendHandler is called with the latest user turn and its edited
+// transcript or follow-up text.
+func (o *OverlayRenderer) SetEditResendHandler(fn func(int, string)) {
+	o.onEditResend = fn
+}
+
+// SetRegenerateModels lists the models offered by a response's regenerate
+// menu besides the active one.
+func (o *OverlayRenderer) SetRegenerateModels(names []string) {
+	b, _ := json.Marshal(names)
+	o.eval(`window._regenModels=` + string(b) + `;`)
+}
+
+func (o *OverlayRenderer) SetProvider(p model.Provider)           { o.provider = p }
+func (o *OverlayRenderer) SetAppState(s *model.AppState)          { o.appState = s }
+func (o *OverlayRenderer) SetAudioCapture(ac *audio.AudioCapture) { o.ac = ac }
+
+// SetProfiles fills the prompt profile picker next to the chat input.
+func (o *OverlayRenderer) SetProfiles(names []string, active string) {
+	var opts strings.Builder
+	for _, n := range names {
+		sel := ""
+		if n == active {
+			sel = " selected"
+		}
+		fmt.Fprintf(&opts, `<option value="%s"%s>%s</option>`, escapeHTML(n), sel, escapeHTML(n))
+	}
+	o.eval(`document.getElementById('profile-select').innerHTML=` + jsString(opts.String()) + `;`)
+}
+
+// SetLanguage fills the language picker next to the chat input. The first
+// entry is auto mode, labelled with the language it resolved to and why;
+// it stays selected unless source is "override".
+func (o *OverlayRenderer) SetLanguage(options []string, lang, source string) {
+	var opts strings.Builder
+	auto := " selected"
+	if source == "override" {
+		auto = ""
+	}
+	fmt.Fprintf(&opts, `<option value="auto"%s>auto · %s (%s)</option>`, auto, escapeHTML(lang), escapeHTML(source))
+	for _, n := range options {
+		sel := ""
+		if source == "override" && n == lang {
+			sel = " selected"
+		}
+		fmt.Fprintf(&opts, `<option value="%s"%s>%s</option>`, escapeHTML(n), sel, escapeHTML(n))
+	}
+	o.eval(`document.getElementById('lang-select').innerHTML=` + jsString(opts.String()) + `;`)
+}
+
+// SetBranches fills the branch picker next to the chat input; it stays
+// hidden until there is more than one branch.
+func (o *OverlayRenderer) SetBranches(branches []model.BranchInfo) {
+	var opts strings.Builder
+	for _, b := range branches {
+		sel := ""
+		if b.Active {
+			sel = " selected"
+		}
+		fmt.Fprintf(&opts, `<option value="%d"%s>%s (%d turns)</option>`, b.ID, sel, escapeHTML(b.Name), b.Turns)
+	}
+	display := "none"
+	if len(branches) > 1 {
+		display = "inline-block"
+	}
+	o.eval(`var bs=document.getElementById('branch-select');bs.innerHTML=` + jsString(opts.String()) + `;bs.style.display='` + display + `';`)
+}
+
+// SwitchBranch stashes the Chat and Trace tabs under from and restores
+// to's, or leaves them empty for a branch not shown before.
+func (o *OverlayRenderer) SwitchBranch(from, to int) {
+	o.eval(fmt.Sprintf(`_switchBranchView(%d,%d);`, from, to))
+}
+
+// setContextRoots gives the provider roots and shows them.
+func (o *OverlayRenderer) setContextRoots(roots []string) {
+	o.provider.SetContextRoots(roots)
+	o.SetFileSysLabel(o.provider.ContextRoots())
+}
+
+// SetFileSysLabel names the context roots on the file sys button: the
+// first, and how many more.
+func (o *OverlayRenderer) SetFileSysLabel(roots []string) {
+	label := "file sys"
+	if len(roots) > 0 {
+		label += ": " + filepath.Base(roots[0])
+	}
+	if len(roots) > 1 {
+		label += fmt.Sprintf(" +%d", len(roots)-1)
+	}
+	o.eval(`document.getElementById('btn-context').textContent=` + jsString(label) + `;` +
 		`_refreshContext();`)
 }
 
@@ -595,10 +780,13 @@ func (o *OverlayRenderer) SetStatus(status string) {
 
 func (o *OverlayRenderer) StreamStart() {
 	o.streamBuf.Reset()
+	o.reasoningBuf.Reset()
+	o.toolsBuf.Reset()
 	js := "window._autoScroll=true;" +
 		"document.getElementById('chat-content').innerHTML='<pre id=\"stream\"></pre>';" +
 		"document.getElementById('footer-status').textContent='';" +
-		"document.getElementById('tab-chat').classList.add('streaming');"
+		"document.getElementById('tab-chat').classList.add('streaming');" +
+		"document.getElementById('chat-stop-btn').style.display='inline-block';"
 	o.eval(js)
 }
 
@@ -610,18 +798,60 @@ func (o *OverlayRenderer) streamDelta(delta string) {
 
 func (o *OverlayRenderer) StreamDelta(delta string) { o.streamDelta(delta) }
 
-func (o *OverlayRenderer) wrapResponse() string {
+// ReasoningDelta streams thinking text into an open "reasoning" section
+// above the answer; wrapResponse folds it once the answer is done.
+func (o *OverlayRenderer) ReasoningDelta(delta string) {
+	js := ""
+	if o.reasoningBuf.Len() == 0 {
+		js = `var s=document.getElementById('stream');if(s)s.insertAdjacentHTML('beforebegin','<details id="reasoning" class="reasoning" open><summary>reasoning</summary><pre id="reasoning-stream"></pre></details>');`
+	}
+	o.reasoningBuf.WriteString(delta)
+	js += "var r=document.getElementById('reasoning-stream');if(r){r.textContent+=" + jsString(delta) + ";if(window._autoScroll)r.scrollIntoView(false);}"
+	o.eval(js)
+}
+
+// streamHTML renders the streamed markdown answer.
+func (o *OverlayRenderer) streamHTML() string {
 	html, err := o.markdownToHTML(o.streamBuf.String())
 	if err != nil {
 		html = "<pre>" + escapeHTML(o.streamBuf.String()) + "</pre>"
 	}
-	inner := `<div class="response-block">` + html +
-		`<div class="response-actions">` +
+	return html
+}
+
+// wrapResponse adds the answer's reasoning, tool calls, action buttons and
+// trace header around its rendered html.
+func (o *OverlayRenderer) wrapResponse(html string) string {
+	if o.interrupted {
+		html += `<div class="interrupted-tag">interrupted</div>`
+		o.interrupted = false
+	}
+	if o.reasoningBuf.Len() > 0 {
+		html = `<details class="reasoning"><summary>reasoning</summary><pre>` + escapeHTML(strings.TrimSpace(o.reasoningBuf.String())) + `</pre></details>` + html
+		o.reasoningBuf.Reset()
+	}
+	if o.toolsBuf.Len() > 0 {
+		html = `<div class="tool-calls">` + o.toolsBuf.String() + `</div>` + html
+		o.toolsBuf.Reset()
+	}
+	seq, latest := o.answeredTurn()
+	turn := ""
+	if seq > 0 {
+		turn = fmt.Sprintf(`<button class="action-btn regen-btn" onclick="_regenMenu(event,%d)" title="Regenerate">&#8635;</button>`, seq)
+	}
+	if seq > 0 && latest {
+		turn += fmt.Sprintf(`<button class="action-btn edit-btn" onclick="_editTurn(this,%d)" title="Edit &amp; resend">&#9998;</button>`, seq)
+	}
+	inner := fmt.Sprintf(`<div class="response-block" data-seq="%d">`, seq) + html +
+		`<div class="response-actions">` + turn +
 		`<button class="action-btn simplify-btn" onclick="_action('simplify')" title="Simplify">&#8722;</button>` +
 		`<button class="action-btn optimize-btn" onclick="_action('optimize')" title="Optimize">&#43;</button>` +
 		`<button class="action-btn explain-btn" onclick="_action('explain')" title="Explain further">?</button>` +
 		`</div></div>`
 	tid := o.currentTraceID
+	if m, ok := o.turn(seq); ok && m.Traced {
+		tid = m.TraceID
+	}
 	return fmt.Sprintf(
 		`<div class="trace-group" data-trace-id="%d">`+
 			`<div class="row row-center trace-header">`+
@@ -630,34 +860,166 @@ func (o *OverlayRenderer) wrapResponse() string {
 		tid, tid, tid, inner)
 }
 
+// answeredTurn returns the sequence number of the user turn the finished
+// answer belongs to, and whether it is the newest one. It is 0 when the
+// turn is not in the history, e.g. after a failed call.
+func (o *OverlayRenderer) answeredTurn() (int, bool) {
+	if o.provider == nil {
+		return 0, false
+	}
+	last := 0
+	for _, m := range o.provider.Conversation().Messages() {
+		if m.Role == model.RoleUser && !m.Summary {
+			last = m.Seq
+		}
+	}
+	seq := o.streamSeq
+	o.streamSeq = 0
+	if seq == 0 {
+		seq = last
+	}
+	return seq, seq == last
+}
+
+// turn looks up a user turn in the active conversation.
+func (o *OverlayRenderer) turn(seq int) (model.Message, bool) {
+	if o.provider == nil {
+		return model.Message{}, false
+	}
+	conv := o.provider.Conversation()
+	i := conv.IndexOfSeq(seq)
+	if i < 0 {
+		return model.Message{}, false
+	}
+	return conv.Messages()[i], true
+}
+
 func (o *OverlayRenderer) StreamDone() {
-	wrapped := o.wrapResponse()
+	o.finishStream(o.wrapResponse(o.streamHTML()))
+}
+
+// StreamAnswerDone replaces the streamed JSON with the rendered structured
+// answer. Its code artifacts get run, copy and save buttons that act on the
+// artifact itself rather than on text scraped from the page.
+func (o *OverlayRenderer) StreamAnswerDone(a model.Answer) {
+	o.artifactsMu.Lock()
+	o.artifacts = a.Artifacts
+	o.artifactsMu.Unlock()
+	head, _ := o.markdownToHTML(model.Answer{Goal: a.Goal, TLDR: a.TLDR, Approach: a.Approach}.Markdown())
+	tail, _ := o.markdownToHTML(model.Answer{Complexity: a.Complexity, EdgeCases: a.EdgeCases}.Markdown())
+	var arts strings.Builder
+	for i, art := range a.Artifacts {
+		code, err := o.markdownToHTML("```" + art.Language + "\n" + strings.TrimRight(art.Content, "\n") + "\n```")
+		if err != nil {
+			code = "<pre>" + escapeHTML(art.Content) + "</pre>"
+		}
+		fmt.Fprintf(&arts, `<div class="artifact"><div class="row row-center artifact-head">`+
+			`<span class="row-fill artifact-name">%s <span class="artifact-lang">%s</span></span>`+
+			`<button class="artifact-btn" onclick="_artifact('run',%d)">&#9654; sandbox</button>`+
+			`<button class="artifact-btn" onclick="_artifact('copy',%d)">copy</button>`+
+			`<button class="artifact-btn" onclick="_artifact('save',%d)">save</button></div>%s</div>`,
+			escapeHTML(art.Filename), escapeHTML(art.Language), i, i, i, code)
+	}
+	o.finishStream(o.wrapResponse(head + arts.String() + tail))
+}
+
+// finishStream swaps the streaming view for the wrapped answer.
+func (o *OverlayRenderer) finishStream(wrapped string) {
 	js := "var c=document.getElementById('chat-content'),ca=document.getElementById('content-area'),st=ca.scrollTop;" +
 		"c.innerHTML=" + jsString(wrapped) + ";_injectSandboxButtons();" +
 		"if(!window._autoScroll)ca.scrollTop=st;" +
-		"document.getElementById('tab-chat').classList.remove('streaming');"
+		"document.getElementById('tab-chat').classList.remove('streaming');" +
+		"document.getElementById('chat-stop-btn').style.display='none';"
 	o.eval(js)
 }
 
+// StreamReset clears the partial answer when a dropped stream restarts.
+func (o *OverlayRenderer) StreamReset() {
+	o.streamBuf.Reset()
+	o.reasoningBuf.Reset()
+	o.toolsBuf.Reset()
+	o.eval("var s=document.getElementById('stream');if(s)s.textContent='';" +
+		"var r=document.getElementById('reasoning');if(r)r.remove();" +
+		clearLiveToolsJS)
+}
+
+// clearLiveToolsJS removes the streaming-time tool blocks and the answer
+// fragments split around them; the finished answer re-renders both.
+const clearLiveToolsJS = "document.querySelectorAll('#chat-content .stream-part,#chat-content .tool-live').forEach(function(e){e.remove()});"
+
+// ToolActivity shows a tool call inline: the answer streamed so far is
+// closed off, the call is inserted below it and streaming continues after.
+// The result fills in when the call finishes.
+func (o *OverlayRenderer) ToolActivity(ev model.ToolEvent) {
+	sel := "document.querySelector('.tool-live[data-tool-id=\"'+" + jsString(ev.ID) + "+'\"]')"
+	if !ev.Done {
+		block := fmt.Sprintf(`<details class="tool-call tool-live" data-tool-id="%s"><summary>%s <span class="tool-state">running…</span></summary><pre class="tool-output"></pre></details>`,
+			escapeHTML(ev.ID), escapeHTML(toolLabel(ev)))
+		o.eval(`var s=document.getElementById('stream');if(s){s.removeAttribute('id');s.classList.add('stream-part');` +
+			`s.insertAdjacentHTML('afterend',` + jsString(block+`<pre id="stream"></pre>`) + `);}`)
+		return
+	}
+	state, cls := "done", "tool-call"
+	if ev.IsError {
+		state, cls = "error", "tool-call tool-error"
+	}
+	fmt.Fprintf(&o.toolsBuf, `<details class="%s"><summary>%s <span class="tool-state">%s</span></summary><pre class="tool-output">%s</pre></details>`,
+		cls, escapeHTML(toolLabel(ev)), state, escapeHTML(ev.Output))
+	o.eval(`var t=` + sel + `;if(t){t.className=` + jsString(cls+" tool-live") + `;` +
+		`t.querySelector('.tool-state').textContent=` + jsString(state) + `;` +
+		`t.querySelector('.tool-output').textContent=` + jsString(ev.Output) + `;}`)
+}
+
+func (o *OverlayRenderer) StreamInterrupted() {
+	o.interrupted = true
+}
+
 func (o *OverlayRenderer) AppendStreamStart() {
 	o.streamBuf.Reset()
+	o.reasoningBuf.Reset()
+	o.toolsBuf.Reset()
 	js := `window._autoScroll=true;var c=document.getElementById('chat-content');` +
 		`c.innerHTML+='<hr><h3 style="color:#7ec8e3">▼ follow-up</h3><pre id="stream"></pre>';` +
 		`document.getElementById('footer-status').textContent='';` +
 		`document.getElementById('content-area').scrollTop=document.getElementById('content-area').scrollHeight;` +
-		`document.getElementById('tab-chat').classList.add('streaming');`
+		`document.getElementById('tab-chat').classList.add('streaming');` +
+		`document.getElementById('chat-stop-btn').style.display='inline-block';`
+	o.eval(js)
+}
+
+// ReplaceStreamStart streams a regenerated answer in place of the response
+// block for user turn seq; AppendStreamDelta and AppendStreamDone finish
+// it. If that block is no longer shown, the answer is appended instead.
+func (o *OverlayRenderer) ReplaceStreamStart(seq int) {
+	o.streamBuf.Reset()
+	o.reasoningBuf.Reset()
+	o.toolsBuf.Reset()
+	o.streamSeq = seq
+	js := fmt.Sprintf(`window._autoScroll=false;var b=document.querySelector('#chat-content .response-block[data-seq="%d"]');`, seq) +
+		`var g=b&&(b.closest('.trace-group')||b);` +
+		`if(g){g.outerHTML='<pre id="stream"></pre>';}else{window._autoScroll=true;` +
+		`document.getElementById('chat-content').innerHTML+='<hr><h3 style="color:#7ec8e3">▼ regenerated</h3><pre id="stream"></pre>';}` +
+		`document.getElementById('footer-status').textContent='';` +
+		`document.getElementById('tab-chat').classList.add('streaming');` +
+		`document.getElementById('chat-stop-btn').style.display='inline-block';`
 	o.eval(js)
 }
 
 func (o *OverlayRenderer) AppendStreamDelta(delta string) { o.streamDelta(delta) }
 
 func (o *OverlayRenderer) AppendStreamDone() {
-	wrapped := o.wrapResponse()
-	js := `var s=document.getElementById('stream');` +
+	wrapped := o.wrapResponse(o.streamHTML())
+	js := `var r=document.getElementById('reasoning');if(r)r.remove();` + clearLiveToolsJS
+	if strings.Contains(wrapped, "edit-btn") {
+		// Only the newest turn can be edited.
+		js += `document.querySelectorAll('#chat-content .edit-btn').forEach(function(e){e.remove()});`
+	}
+	js += `var s=document.getElementById('stream');` +
 		`if(s){var ca=document.getElementById('content-area'),st=ca.scrollTop;` +
 		`var d=document.createElement('div');d.innerHTML=` + jsString(wrapped) + `;s.replaceWith(d);` +
 		`if(window._autoScroll)d.scrollIntoView(false);else ca.scrollTop=st;}_injectSandboxButtons();` +
-		`document.getElementById('tab-chat').classList.remove('streaming');`
+		`document.getElementById('tab-chat').classList.remove('streaming');` +
+		`document.getElementById('chat-stop-btn').style.display='none';`
 	o.eval(js)
 }
 
@@ -795,32 +1157,158 @@ func (o *OverlayRenderer) AddObserveTrace(trace model.Trace) {
 		}
 	}
 	if trace.HasContext {
-		info, err := os.Stat(trace.ContextDir)
-		label := escapeHTML(trace.ContextDir)
-		if err == nil && !info.IsDir() {
-			label = escapeHTML(strings.Join(trace.ContextFiles, ", "))
-		}
-		detail += "<div><b>File sys:</b> " + label + "</div>"
+		detail += "<div><b>File sys:</b> " + escapeHTML(strings.Join(trace.ContextRoots, ", ")) + "</div>"
 	}
+	detail += fmt.Sprintf(`<div class="trace-model"%s><b>Model:</b> <span>%s</span></div>`, hiddenIf(trace.Model == ""), escapeHTML(trace.Model))
 	if trace.TranscriptSnippet != "" {
 		detail += "<div><b>Transcript:</b></div><pre style=\"font-size:11px;color:#aaa;margin:2px 0;white-space:pre-wrap\">" + escapeHTML(trace.TranscriptSnippet) + "</pre>"
 	}
 	restoreBtn := fmt.Sprintf(
-		` <button class="row-end trace-restore" onclick="event.stopPropagation();_restoreArtifactContext(%d)" title="Restore all context">&#8635;</button>`,
-		trace.ID)
+		` <button class="row-end trace-restore" onclick="event.stopPropagation();_forkTrace(%d)" title="Branch from here">&#9282;</button>`+
+			`<button class="row-end trace-restore" onclick="event.stopPropagation();_restoreArtifactContext(%d)" title="Restore all context">&#8635;</button>`,
+		trace.ID, trace.ID)
 	html := fmt.Sprintf(
 		`<div class="observe-trace" data-trace-id="%d">`+
 			`<div class="row row-center observe-header" onclick="_toggleObserveTrace(%d)">`+
 			`<input type="checkbox" class="row-ctrl trace-cb" value="%d" onclick="event.stopPropagation();_updateDeleteBtn()">`+
 			`<span class="row-ctrl observe-chevron">&#9654;</span>`+
-			`<span class="row-fill">[%s] #%d — %s</span>%s</div>`+
+			`<span class="row-fill">[%s] #%d — %s</span><span class="trace-usage">%s</span>%s</div>`+
 			`<div class="observe-detail">%s</div></div>`,
-		trace.ID, trace.ID, trace.ID, escapeHTML(ts), trace.ID, escapeHTML(summary), restoreBtn, detail)
+		trace.ID, trace.ID, trace.ID, escapeHTML(ts), trace.ID, escapeHTML(summary), escapeHTML(traceUsageLabel(trace.Usage)), restoreBtn, detail)
 	js := `var oc=document.getElementById('trace-content');` +
 		`oc.insertAdjacentHTML('afterbegin',` + jsString(html) + `);`
 	o.eval(js)
 }
 
+// SetTraceModel shows which model answered a trace, once routing has
+// picked it.
+func (o *OverlayRenderer) SetTraceModel(traceID int, modelName string) {
+	o.eval(fmt.Sprintf(`var tm=document.querySelector('.observe-trace[data-trace-id="%d"] .trace-model');`, traceID) +
+		`if(tm){tm.style.display='';tm.querySelector('span').textContent=` + jsString(modelName) + `;}`)
+}
+
+// UpdateUsage refreshes a trace's token/cost badge and the session total in the footer.
+func (o *OverlayRenderer) UpdateUsage(traceID int, trace, session model.Usage) {
+	js := fmt.Sprintf(
+		`var tu=document.querySelector('.observe-trace[data-trace-id="%d"] .trace-usage');if(tu){tu.textContent=%s;tu.title=%s;}`+
+			`document.getElementById('footer-usage').textContent=%s;`,
+		traceID, jsString(traceUsageLabel(trace)), jsString(usage.Format(trace)), jsString("session: "+usage.Format(session)))
+	o.eval(js)
+}
+
+// SetQueueDepth shows how many provider calls are waiting behind the one
+// in flight.
+func (o *OverlayRenderer) SetQueueDepth(n int) {
+	label := ""
+	if n > 0 {
+		label = fmt.Sprintf("%d call(s) queued", n)
+	}
+	o.eval(`document.getElementById('footer-queue').textContent=` + jsString(label) + `;`)
+}
+
+// traceUsageLabel is the short badge shown on a trace header; the full
+// breakdown goes in its tooltip.
+func traceUsageLabel(u model.Usage) string {
+	if u.Calls == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d tok · $%.4f", u.InputTokens+u.OutputTokens+u.CachedTokens+u.CacheWriteTokens, u.Cost)
+}
+
+// AddCompactionMarker marks where older turns were summarized: above the
+// answer being streamed in Chat, and between traces in the Trace tab.
+func (o *OverlayRenderer) AddCompactionMarker(c model.Compaction) {
+	html := `<div class="compaction-marker">` + escapeHTML(compactionLabel(c)) + `</div>`
+	js := `var s=document.getElementById('stream');if(s)s.insertAdjacentHTML('beforebegin',` + jsString(html) + `);` +
+		`document.getElementById('trace-content').insertAdjacentHTML('afterbegin',` + jsString(html) + `);`
+	o.eval(js)
+}
+
+// CompareStart replaces the chat view with one sub-tab per model; answers
+// stream into their own panel.
+func (o *OverlayRenderer) CompareStart(models []string) {
+	o.compareModels = models
+	o.compareTraceID = o.currentTraceID
+	o.comparePending = len(models)
+	var tabs, panels strings.Builder
+	for i, m := range models {
+		active := ""
+		if i == 0 {
+			active = " active"
+		}
+		fmt.Fprintf(&tabs, `<button class="compare-tab%s" data-col="%d" onclick="_compareTab(%d)">%s <span class="compare-state">streaming…</span></button>`,
+			active, i, i, escapeHTML(m))
+		fmt.Fprintf(&panels, `<div class="compare-panel%s" data-col="%d"><pre class="compare-stream"></pre></div>`, active, i)
+	}
+	html := fmt.Sprintf(`<div class="compare" data-trace-id="%d"><div class="compare-tabs">%s</div>%s</div>`,
+		o.compareTraceID, tabs.String(), panels.String())
+	o.eval("window._autoScroll=true;" +
+		"document.getElementById('chat-content').innerHTML=" + jsString(html) + ";" +
+		"document.getElementById('footer-status').textContent='';" +
+		"document.getElementById('tab-chat').classList.add('streaming');" +
+		"document.getElementById('chat-stop-btn').style.display='inline-block';")
+}
+
+// comparePanelJS selects a compare panel or its tab by column.
+func comparePanelJS(sel string, col int) string {
+	return fmt.Sprintf(`document.querySelector('.compare %s[data-col="%d"]')`, sel, col)
+}
+
+func (o *OverlayRenderer) CompareDelta(col int, delta string) {
+	if col >= len(o.compareModels) {
+		return
+	}
+	o.eval("var s=" + comparePanelJS(".compare-panel", col) + ";if(s){s=s.querySelector('.compare-stream');" +
+		"if(s){s.textContent+=" + jsString(delta) + ";if(window._autoScroll&&s.offsetParent)s.scrollIntoView(false);}}")
+}
+
+func (o *OverlayRenderer) CompareReset(col int) {
+	if col >= len(o.compareModels) {
+		return
+	}
+	o.eval("var s=" + comparePanelJS(".compare-panel", col) + ";if(s){s=s.querySelector('.compare-stream');if(s)s.textContent='';}")
+}
+
+// CompareDone renders a finished answer with a button to continue with it
+// and records it under the trace in the Trace tab.
+func (o *OverlayRenderer) CompareDone(col int, answer, errMsg string) {
+	if col >= len(o.compareModels) {
+		return
+	}
+	html, err := o.markdownToHTML(answerMarkdown(answer))
+	if err != nil {
+		html = "<pre>" + escapeHTML(answer) + "</pre>"
+	}
+	state := "done"
+	if errMsg != "" {
+		html += `<div class="interrupted-tag">` + escapeHTML(errMsg) + `</div>`
+		state = "error"
+	}
+	if errMsg == "" {
+		html += fmt.Sprintf(`<div class="response-actions"><button class="compare-pick" onclick="_pickCompare(%d)">continue with this</button></div>`, col)
+	}
+	traced := fmt.Sprintf(`<details class="compare-answer"><summary>%s <span class="tool-state">%s</span></summary><pre class="tool-output">%s</pre></details>`,
+		escapeHTML(o.compareModels[col]), state, escapeHTML(answer+errMsg))
+	js := "var p=" + comparePanelJS(".compare-panel", col) + ";if(p)p.innerHTML=" + jsString(html) + ";" +
+		"var t=" + comparePanelJS(".compare-tab", col) + ";if(t)t.querySelector('.compare-state').textContent=" + jsString(state) + ";" +
+		fmt.Sprintf(`var od=document.querySelector('.observe-trace[data-trace-id="%d"] .observe-detail');`, o.compareTraceID) +
+		"if(od)od.insertAdjacentHTML('beforeend'," + jsString(traced) + ");_injectSandboxButtons();"
+	o.comparePending--
+	if o.comparePending == 0 {
+		js += "document.getElementById('tab-chat').classList.remove('streaming');" +
+			"document.getElementById('chat-stop-btn').style.display='none';"
+	}
+	o.eval(js)
+}
+
+// CompareChosen marks the answer that continues the conversation and
+// retires the other pick buttons.
+func (o *OverlayRenderer) CompareChosen(col int) {
+	o.eval("document.querySelectorAll('.compare .compare-pick').forEach(function(b){b.remove()});" +
+		"var t=" + comparePanelJS(".compare-tab", col) + ";if(t){t.classList.add('chosen');t.querySelector('.compare-state').textContent='continued';}" +
+		fmt.Sprintf(`var a=document.querySelectorAll('.observe-trace[data-trace-id="%d"] .compare-answer')[%d];if(a)a.classList.add('chosen');`, o.compareTraceID, col))
+}
+
 func (o *OverlayRenderer) RemoveObserveTrace(traceID int) {
 	js := fmt.Sprintf(
 		`var ot=document.querySelector('.observe-trace[data-trace-id="%d"]');if(ot)ot.remove();`+
@@ -836,6 +1324,7 @@ func (o *OverlayRenderer) Clear() {
 		"document.getElementById('screenshot-grid').innerHTML='';" +
 		"document.getElementById('trace-content').innerHTML='';" +
 		"document.getElementById('delete-traces-btn').style.display='none';" +
+		"window._branchViews={};" +
 		"document.getElementById('ctx-screenshots').innerHTML='';" +
 		"document.getElementById('ctx-transcript').innerHTML='';" +
 		"document.getElementById('ctx-files').innerHTML='';" +
@@ -855,6 +1344,81 @@ func (o *OverlayRenderer) Close() {
 	o.w.Terminate()
 }
 
+// artifactActions are the buttons under each code artifact of a
+// structured answer.
+func (o *OverlayRenderer) artifactActions() map[string]func(model.Artifact) {
+	return map[string]func(model.Artifact){
+		"run":  o.runArtifact,
+		"copy": o.copyArtifact,
+		"save": o.saveArtifact,
+	}
+}
+
+// artifactAction runs, copies or saves one code artifact of a structured
+// answer.
+func (o *OverlayRenderer) artifactAction(action string, a model.Artifact) {
+	do, ok := o.artifactActions()[action]
+	if !ok {
+		return
+	}
+	do(a)
+}
+
+func (o *OverlayRenderer) runArtifact(a model.Artifact) {
+	o.sendToSandbox(a.Content, a.Language)
+}
+
+func (o *OverlayRenderer) copyArtifact(a model.Artifact) {
+	cmd := exec.Command("xclip", "-selection", "clipboard")
+	cmd.Stdin = strings.NewReader(a.Content)
+	if err := cmd.Run(); err != nil {
+		applog.AppLog.Error("overlay: copy %s: %v", a.Filename, err)
+		o.SetStatus("copy failed: " + err.Error())
+		return
+	}
+	o.SetStatus("copied " + a.Filename)
+}
+
+func (o *OverlayRenderer) saveArtifact(a model.Artifact) {
+	path := pickSavePath(filepath.Base(a.Filename))
+	if path == "" {
+		return
+	}
+	if err := os.WriteFile(path, []byte(a.Content), 0o644); err != nil {
+		applog.AppLog.Error("overlay: save %s: %v", path, err)
+		o.SetStatus("save failed: " + err.Error())
+		return
+	}
+	applog.AppLog.Info("overlay: saved artifact to %s", path)
+	o.SetStatus("saved " + path)
+}
+
+// sendToSandbox loads code into the Sandbox tab and runs it.
+func (o *OverlayRenderer) sendToSandbox(code, lang string) {
+	applog.AppLog.Info("overlay: sendToSandbox lang=%s code=%d bytes", lang, len(code))
+	o.sandboxMu.Lock()
+	o.sandboxCode = code
+	o.sandboxLang = lang
+	o.sandboxMu.Unlock()
+
+	js := "document.getElementById('sandbox-editor').value=" + jsString(code) + ";" +
+		"document.getElementById('sandbox-lang').textContent=" + jsString(lang) + ";" +
+		"document.getElementById('sandbox-tests').value='';" +
+		"document.getElementById('sandbox-tests-hl').innerHTML='';" +
+		"document.getElementById('sandbox-tests-wrap').style.display='none';" +
+		"document.getElementById('sandbox-tests-label').style.display='none';" +
+		"switchTab('sandbox');" +
+		"_autoSize(document.getElementById('sandbox-editor'));" +
+		"_syncHighlight('sandbox-editor','sandbox-editor-hl');" +
+		"document.getElementById('sandbox-output').innerHTML='<span style=\"color:#888\">running...</span>';"
+	o.eval(js)
+
+	go func() {
+		res := sandbox.RunSandbox(code, lang)
+		o.renderSandboxResult(res)
+	}()
+}
+
 func (o *OverlayRenderer) renderSandboxResult(r model.SandboxResult) {
 	applog.AppLog.Info("overlay: rendering sandbox result exit=%d", r.ExitCode)
 	var parts []string
@@ -893,15 +1457,29 @@ type ctxTranscript struct {
 }
 
 type ctxFile struct {
-	Path     string `json:"path"`
-	Excluded bool   `json:"excluded"`
+	Path     string  `json:"path"`
+	Size     int64   `json:"size"`
+	Excluded bool    `json:"excluded"`
+	Skip     string  `json:"skip,omitempty"`
+	Score    float64 `json:"score"`
+	Change   string  `json:"change,omitempty"`
+	Mode     string  `json:"mode,omitempty"`
+	Outline  bool    `json:"outline,omitempty"`
+}
+
+// ctxRoot is one context root's tree in the Context tab.
+type ctxRoot struct {
+	Root    string    `json:"root"`
+	Label   string    `json:"label,omitempty"`
+	File    bool      `json:"file,omitempty"`
+	Missing bool      `json:"missing,omitempty"`
+	Files   []ctxFile `json:"files"`
 }
 
 type ctxState struct {
 	Screenshots []ctxScreenshot `json:"screenshots"`
 	Transcript  []ctxTranscript `json:"transcript"`
-	Files       []ctxFile       `json:"files"`
-	ContextDir  string          `json:"contextDir"`
+	Roots       []ctxRoot       `json:"roots"`
 }
 
 func (o *OverlayRenderer) buildContextStateJSON() string {
@@ -933,19 +1511,43 @@ func (o *OverlayRenderer) buildContextStateJSON() string {
 		}
 	}
 
+	var roots []string
 	if o.provider != nil {
-		st.ContextDir = o.provider.ContextDir()
+		roots = o.provider.ContextRoots()
 	}
-	excluded := appctx.CtxFileSelection.ExcludedSet()
-	files := appctx.ListContextFiles(st.ContextDir)
-	for _, f := range files {
-		st.Files = append(st.Files, ctxFile{Path: f, Excluded: excluded[f]})
+	for _, t := range appctx.Scan(roots, o.contextSignals()) {
+		r := ctxRoot{Root: t.Root, Label: t.Label, File: t.File, Missing: t.Missing}
+		for _, f := range t.Files {
+			r.Files = append(r.Files, ctxFile{Path: f.Rel, Size: f.Size, Excluded: f.Excluded, Skip: f.Skip, Score: f.Score, Change: f.Change, Mode: f.Mode, Outline: f.Outline})
+		}
+		st.Roots = append(st.Roots, r)
 	}
 
 	b, _ := json.Marshal(st)
 	return string(b)
 }
 
+// contextSignals are what the next capture ranks context files against:
+// the selected transcript and screenshots and the recent answers. Only
+// screenshots already read count; the rest are read in the background and
+// the Context tab refreshed once they are.
+func (o *OverlayRenderer) contextSignals() appctx.Signals {
+	var text string
+	var images [][]byte
+	var history []model.Message
+	if o.ac != nil {
+		text = o.ac.BuildSelectedContext()
+	}
+	if o.appState != nil {
+		images = o.appState.SelectedScreenshots()
+	}
+	if o.provider != nil {
+		history = o.provider.Conversation().Messages()
+	}
+	screenText := system.CachedScreenText(images, func() { o.eval(`_refreshContext();`) })
+	return appctx.NewSignals(text, screenText, history)
+}
+
 func (o *OverlayRenderer) markdownToHTML(md string) (string, error) {
 	var buf bytes.Buffer
 	err := o.md.Convert([]byte(md), &buf)
@@ -973,7 +1575,7 @@ func (o *OverlayRenderer) buildHTML() string {
 </div>
 <div id="content-area">
 <div id="chat-content" class="tab-content active"><div style="text-align:right;padding:4px 8px"><button class="ctx-clear-btn" onclick="document.getElementById('chat-content').querySelectorAll('.trace-group').forEach(function(e){e.remove()})">Clear Chat</button></div></div>
-<div id="chat-input-bar" class="visible"><input id="chat-input" type="text" placeholder="Send a message..." onkeydown="if(event.key==='Enter'){_chatSend(this.value);this.value=''}"><button id="chat-send-btn" onclick="_chatSend(document.getElementById('chat-input').value);document.getElementById('chat-input').value=''">Send</button></div>
+<div id="chat-input-bar" class="visible"><select id="branch-select" title="Conversation branch" style="display:none" onchange="_switchBranch(parseInt(this.value))"></select><select id="lang-select" title="Answer language" onchange="_setLanguage(this.value)"></select><select id="profile-select" title="Prompt profile" onchange="_setProfile(this.value)"></select><input id="chat-input" type="text" placeholder="Send a message..." onkeydown="if(event.key==='Enter'){_chatSend(this.value);this.value=''}"><button id="chat-send-btn" onclick="_chatSend(document.getElementById('chat-input').value);document.getElementById('chat-input').value=''">Send</button><button id="chat-stop-btn" style="display:none" onclick="_action('stop')">&#9632; Stop</button></div>
 <div id="transcript-content" class="tab-content"><div id="transcript-controls" style="text-align:right;padding:4px 8px"><button class="ctx-clear-btn" style="color:#7ec8e3;border-color:rgba(126,200,227,0.3)" onclick="_selectAllTranscript()">Select All</button></div></div>
 <div id="screenshots-content" class="tab-content"><div id="screenshot-grid"></div></div>
 <div id="sandbox-content" class="tab-content">
@@ -1006,7 +1608,7 @@ func (o *OverlayRenderer) buildHTML() string {
 <button id="delete-traces-btn" style="display:none" onclick="_deleteTraces()">Delete selected</button>
 <div id="ss-lightbox" onclick="this.classList.remove('active')"><img></div>
 </div>
-<div id="footer"><div id="footer-status"></div>
+<div id="footer"><div id="footer-status"></div><div id="footer-usage"></div><div id="footer-queue"></div>
 <div id="vu-meters">
   <span class="vu-label">mic</span>
   <div class="vu-track"><div id="vu-mic" class="vu-fill"></div></div>
@@ -1083,6 +1685,14 @@ func buildFooterButtons() string {
 	return buf.String()
 }
 
+// hiddenIf returns a style attribute hiding an element when hide is set.
+func hiddenIf(hide bool) string {
+	if hide {
+		return ` style="display:none"`
+	}
+	return ""
+}
+
 func escapeHTML(s string) string {
 	s = strings.ReplaceAll(s, "&", "&amp;")
 	s = strings.ReplaceAll(s, "<", "&lt;")
@@ -1095,6 +1705,15 @@ func jsString(s string) string {
 	return string(b)
 }
 
+// pickSavePath asks where to save a file, suggesting name.
+func pickSavePath(name string) string {
+	out, err := exec.Command("zenity", "--file-selection", "--save", "--confirm-overwrite", "--title=Save artifact", "--filename="+name).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
 func pickPath(mode string) string {
 	args := []string{"--file-selection", "--title=Select context"}
 	if mode == "dir" {
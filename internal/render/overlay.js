@@ -152,7 +152,7 @@ window._refreshContext = function () {
     var st = JSON.parse(raw);
     st.screenshots = st.screenshots || [];
     st.transcript = st.transcript || [];
-    st.files = st.files || [];
+    st.roots = st.roots || [];
     var sh = '<div class="ctx-section-title">Screenshots</div>';
     if (!st.screenshots.length) {
       sh += '<div style="color:#666">none</div>';
@@ -188,35 +188,80 @@ window._refreshContext = function () {
       }
     }
     document.getElementById("ctx-transcript").innerHTML = th;
-    var fh = '<div class="ctx-section-title">Source Files</div>';
-    if (st.contextDir) {
-      fh +=
-        '<div style="color:#666;font-size:11px;margin-bottom:4px">' +
-        st.contextDir +
-        "</div>";
-    }
-    if (!st.files.length) {
+    window._ctxRoots = st.roots;
+    var fh =
+      '<div class="ctx-section-title row row-center"><span class="row-fill">Source Files</span>' +
+      '<button class="ctx-add" onclick="_selectContext(\'dir\')" title="Add a directory">+ dir</button>' +
+      '<button class="ctx-add" onclick="_selectContext(\'file\')" title="Add a file">+ file</button></div>';
+    if (!st.roots.length) {
       fh += '<div style="color:#666">none</div>';
     }
-    for (var i = 0; i < st.files.length; i++) {
-      var f = st.files[i];
-      fh +=
-        '<div class="row row-center ctx-file-entry"><input type="checkbox" class="row-ctrl ctx-file-cb" ' +
-        (f.excluded ? "" : "checked") +
-        " onchange=\"_toggleContextFile('" +
-        f.path.replace(/\x27/g, "\\'") +
-        "',!this.checked);_updateCtxReceipt()\"><span class=\"row-fill\"" +
-        (f.excluded ? ' style="color:#555"' : "") +
-        ">" +
-        f.path +
-        "</span><button class=\"row-end ctx-rm\" onclick=\"_toggleContextFile('" +
-        f.path.replace(/\x27/g, "\\'") +
-        "',true);_refreshContext()\">\u00d7</button></div>";
+    for (var r = 0; r < st.roots.length; r++) {
+      fh += _contextRootTree(st.roots[r], r);
     }
     document.getElementById("ctx-files").innerHTML = fh;
     _updateCtxReceipt();
   });
 };
+// _ctxCollapsed remembers which root trees are folded across refreshes.
+window._ctxCollapsed = {};
+// _contextRootTree renders root r of _ctxRoots as a collapsible tree.
+// Handlers look the root up by index, so paths need no quoting.
+window._contextRootTree = function (root, r) {
+  var files = root.files || [];
+  var sent = files.filter(function (f) { return !f.skip && !f.excluded && f.change !== "deleted"; }).length;
+  var name = root.label || root.root.split("/").pop();
+  var h =
+    '<details class="ctx-root"' + (_ctxCollapsed[root.root] ? "" : " open") +
+    ' ontoggle="_ctxCollapsed[_ctxRoots[' + r + '].root]=!this.open">' +
+    '<summary class="row row-center ctx-root-head"><span class="row-fill">' + name +
+    ' <span class="ctx-root-path">' + root.root + "</span></span>" +
+    '<span class="ctx-root-count">' + (root.missing ? "missing" : sent + "/" + files.length) + "</span>" +
+    '<button class="row-end ctx-rm" title="Remove from context" onclick="event.preventDefault();' +
+    "_removeContextRoot(_ctxRoots[" + r + '].root)">\u00d7</button></summary>';
+  if (!files.length && !root.missing) {
+    h += '<div style="color:#666">none</div>';
+  }
+  for (var i = 0; i < files.length; i++) {
+    var f = files[i];
+    var toggle = "_toggleContextFile(_ctxRoots[" + r + "].root,'" + f.path.replace(/\x27/g, "\\'") + "',";
+    // Only over-budget files can be sent by unchecking others.
+    var fixed = (f.skip && f.skip !== "over budget") || f.change === "deleted";
+    h +=
+      '<div class="row row-center ctx-file-entry"><input type="checkbox" class="row-ctrl ctx-file-cb" ' +
+      (f.excluded || fixed ? "" : "checked") +
+      (fixed ? " disabled" : "") +
+      ' onchange="' + toggle + '!this.checked);_refreshContext()"><span class="row-fill"' +
+      (f.excluded || f.skip ? ' style="color:#555"' : "") +
+      ">" +
+      (f.change ? '<span class="ctx-change ' + f.change + '" title="' + f.change +
+        ' since the last send">' + f.change.charAt(0).toUpperCase() + "</span>" : "") +
+      f.path +
+      "</span>" +
+      (f.skip ? '<span class="ctx-skip">' + f.skip + "</span>" : "") +
+      (f.outline ? '<span class="ctx-outline" title="sent as signatures and doc comments">outline</span>' : "") +
+      _contextModeSelect(f, r) +
+      (f.score ? '<span class="ctx-score' + (f.skip || f.excluded ? "" : " sent") +
+        '" title="relevance">' + f.score.toFixed(1) + "</span>" : "") +
+      '<button class="row-end ctx-rm" onclick="' + toggle + 'true);_refreshContext()">\u00d7</button></div>';
+  }
+  return h + "</details>";
+};
+// _contextModeSelect lets a file be sent in full or as its outline. Auto
+// outlines only files over the per-file limit. Ignored, hidden and binary
+// files have no mode.
+window._contextModeSelect = function (f, r) {
+  var sendable = !f.skip || f.skip === "over budget" || f.skip === "too large";
+  if (!sendable || f.change === "deleted") return "";
+  var h =
+    '<select class="ctx-mode" title="how the file is sent" onchange="_setContextMode(_ctxRoots[' + r + "].root,'" +
+    f.path.replace(/\x27/g, "\\'") +
+    '\',this.value);_refreshContext()">';
+  [["", "auto"], ["full", "full"], ["outline", "outline"]].forEach(function (m) {
+    h += '<option value="' + m[0] + '"' + ((f.mode || "") === m[0] ? " selected" : "") + ">" + m[1] + "</option>";
+  });
+  return h + "</select>";
+};
 window._runCombined = function () {
   var code = document.getElementById("sandbox-editor").value;
   var tests = document.getElementById("sandbox-tests").value;
@@ -296,12 +341,82 @@ window.switchTab = function (name) {
       document.getElementById("log-output").scrollHeight;
   }
 };
+window._branchViews = {};
+window._switchBranchView = function (from, to) {
+  var c = document.getElementById("chat-content");
+  var t = document.getElementById("trace-content");
+  window._branchViews[from] = { chat: c.innerHTML, trace: t.innerHTML };
+  var v = window._branchViews[to] || { chat: "", trace: "" };
+  delete window._branchViews[to];
+  c.innerHTML = v.chat;
+  t.innerHTML = v.trace;
+  _injectSandboxButtons();
+  _updateDeleteBtn();
+};
+window._compareTab = function (col) {
+  document.querySelectorAll(".compare-tab,.compare-panel").forEach(function (el) {
+    el.classList.toggle("active", el.dataset.col === String(col));
+  });
+};
+window._regenModels = [];
+window._regenMenu = function (e, seq) {
+  e.stopPropagation();
+  if (!window._regenModels.length) {
+    _regenerate(seq, "");
+    return;
+  }
+  var old = document.querySelector(".regen-menu");
+  if (old) old.remove();
+  var m = document.createElement("div");
+  m.className = "regen-menu";
+  var names = [""].concat(window._regenModels);
+  names.forEach(function (name) {
+    var d = document.createElement("div");
+    d.textContent = name || "same model";
+    d.onclick = function () {
+      m.remove();
+      _regenerate(seq, name);
+    };
+    m.appendChild(d);
+  });
+  e.currentTarget.parentNode.appendChild(m);
+  setTimeout(function () {
+    document.addEventListener("click", function h() {
+      m.remove();
+      document.removeEventListener("click", h);
+    });
+  }, 0);
+};
+window._editTurn = function (btn, seq) {
+  var block = btn.closest(".response-block");
+  if (block.querySelector(".edit-turn")) return;
+  _turnInput(seq).then(function (text) {
+    var box = document.createElement("div");
+    box.className = "edit-turn";
+    box.innerHTML =
+      '<textarea rows="3" onfocus="_setFocus(true)" onblur="_setFocus(false)"></textarea>' +
+      '<div class="row-end"><button class="edit-cancel">cancel</button><button class="edit-send">resend</button></div>';
+    var ta = box.querySelector("textarea");
+    ta.value = text;
+    box.querySelector(".edit-cancel").onclick = function () {
+      box.remove();
+    };
+    box.querySelector(".edit-send").onclick = function () {
+      _editResend(seq, ta.value);
+      box.remove();
+    };
+    block.insertBefore(box, block.firstChild);
+    _autoSize(ta);
+    ta.focus();
+  });
+};
 window._injectSandboxButtons = function () {
   var wraps = document.querySelectorAll("#chat-content .highlight[data-lang]");
   for (var i = 0; i < wraps.length; i++) {
     var wrap = wraps[i];
     var lang = wrap.getAttribute("data-lang");
     if (!window._sandboxLangs[lang]) continue;
+    if (wrap.closest(".artifact")) continue;
     if (wrap.querySelector(".sandbox-btn")) continue;
     var pre = wrap.querySelector("pre");
     if (!pre) continue;
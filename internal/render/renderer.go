@@ -7,6 +7,7 @@ import (
 	"github.com/charmbracelet/glamour"
 
 	"second-nature/internal/model"
+	"second-nature/internal/usage"
 )
 
 func HotkeyFooter() string {
@@ -17,10 +18,39 @@ func HotkeyFooter() string {
 	return "\033[2m " + strings.Join(parts, " · ") + " \033[0m"
 }
 
+// toolLabel is the one-line form of a tool call: name and clipped arguments.
+func toolLabel(ev model.ToolEvent) string {
+	args := ev.Input
+	if len(args) > 80 {
+		args = args[:80] + "…"
+	}
+	return "⚙ " + ev.Name + " " + args
+}
+
+// compactionLabel describes a history compaction for both renderers.
+func compactionLabel(c model.Compaction) string {
+	return fmt.Sprintf("── history compacted at %s: %d earlier turn(s) summarized, ~%dk → ~%dk tokens ──",
+		c.Time.Format("15:04:05"), c.Turns, c.BeforeTokens/1000, c.AfterTokens/1000)
+}
+
+// answerMarkdown renders a structured answer as markdown; anything else is
+// returned unchanged.
+func answerMarkdown(text string) string {
+	if a, err := model.ParseAnswer(text); err == nil {
+		return a.Markdown()
+	}
+	return text
+}
+
 type TerminalRenderer struct {
-	streamBuf strings.Builder
-	history   strings.Builder // accumulated rendered output
-	status    string
+	streamBuf   strings.Builder
+	reasoning   strings.Builder
+	history     strings.Builder // accumulated rendered output
+	status      string
+	usage       string
+	interrupted bool
+	models      []string       // compare columns
+	branches    map[int]string // saved history of inactive branches
 }
 
 func (t *TerminalRenderer) renderMarkdown(markdown string) string {
@@ -45,6 +75,9 @@ func (t *TerminalRenderer) printFooter() {
 	if t.status != "" {
 		fmt.Printf("\033[33m %s \033[0m\n", t.status)
 	}
+	if t.usage != "" {
+		fmt.Printf("\033[2m session: %s \033[0m\n", t.usage)
+	}
 	fmt.Println(HotkeyFooter())
 }
 
@@ -65,6 +98,8 @@ func (t *TerminalRenderer) SetStatus(status string) {
 
 func (t *TerminalRenderer) StreamStart() {
 	t.streamBuf.Reset()
+	t.reasoning.Reset()
+	t.SetStatus("streaming — " + model.KeyLabels[model.HotkeyStop])
 }
 
 func (t *TerminalRenderer) StreamDelta(delta string) {
@@ -72,13 +107,67 @@ func (t *TerminalRenderer) StreamDelta(delta string) {
 }
 
 func (t *TerminalRenderer) StreamDone() {
-	t.Render(t.streamBuf.String())
+	t.Render(t.takeStream())
+}
+
+// StreamAnswerDone prints a structured answer instead of its raw JSON.
+func (t *TerminalRenderer) StreamAnswerDone(a model.Answer) {
+	t.takeStream()
+	t.Render(a.Markdown())
+}
+
+// StreamReset discards the partial answer when a dropped stream restarts.
+func (t *TerminalRenderer) StreamReset() {
+	t.streamBuf.Reset()
+	t.reasoning.Reset()
+}
+
+// ReasoningDelta buffers thinking text; it is printed as a dimmed block
+// above the answer when the stream finishes.
+func (t *TerminalRenderer) ReasoningDelta(delta string) {
+	if t.reasoning.Len() == 0 {
+		t.SetStatus("thinking — " + model.KeyLabels[model.HotkeyStop])
+	}
+	t.reasoning.WriteString(delta)
+}
+
+func (t *TerminalRenderer) StreamInterrupted() {
+	t.interrupted = true
+}
+
+// takeStream returns the buffered answer, tagged if it was interrupted,
+// and clears the streaming status line.
+func (t *TerminalRenderer) takeStream() string {
+	t.status = ""
+	if t.reasoning.Len() > 0 {
+		t.history.WriteString("\033[2m▸ reasoning\n" + strings.TrimSpace(t.reasoning.String()) + "\033[0m\n")
+		t.reasoning.Reset()
+	}
+	md := t.streamBuf.String()
+	if t.interrupted {
+		md += "\n\n*[interrupted]*"
+		t.interrupted = false
+	}
+	return md
 }
 
 func (t *TerminalRenderer) AppendStreamStart() {
 	t.streamBuf.Reset()
+	t.reasoning.Reset()
 	sep := strings.Repeat("─", 60)
 	t.history.WriteString("\n" + sep + "\n▼ follow-up\n" + sep + "\n")
+	t.SetStatus("streaming — " + model.KeyLabels[model.HotkeyStop])
+}
+
+// ReplaceStreamStart begins a regenerated answer. The terminal cannot
+// rewrite what it already printed, so it appends the new answer under a
+// header instead.
+func (t *TerminalRenderer) ReplaceStreamStart(seq int) {
+	t.streamBuf.Reset()
+	t.reasoning.Reset()
+	sep := strings.Repeat("─", 60)
+	t.history.WriteString("\n" + sep + "\n▼ regenerated\n" + sep + "\n")
+	t.SetStatus("streaming — " + model.KeyLabels[model.HotkeyStop])
 }
 
 func (t *TerminalRenderer) AppendStreamDelta(delta string) {
@@ -86,7 +175,7 @@ func (t *TerminalRenderer) AppendStreamDelta(delta string) {
 }
 
 func (t *TerminalRenderer) AppendStreamDone() {
-	t.Render(t.streamBuf.String())
+	t.Render(t.takeStream())
 }
 
 func (t *TerminalRenderer) AppendTranscriptChunk(source, text string, id int) {
@@ -117,6 +206,73 @@ func (t *TerminalRenderer) AddObserveTrace(trace model.Trace) {}
 
 func (t *TerminalRenderer) RemoveObserveTrace(traceID int) {}
 
+// ToolActivity logs finished tool calls as dimmed lines above the answer.
+func (t *TerminalRenderer) ToolActivity(ev model.ToolEvent) {
+	if !ev.Done {
+		t.SetStatus(toolLabel(ev))
+		return
+	}
+	result, _, _ := strings.Cut(strings.TrimSpace(ev.Output), "\n")
+	if len(result) > 100 {
+		result = result[:100] + "…"
+	}
+	t.history.WriteString(fmt.Sprintf("\033[2m%s → %s\033[0m\n", toolLabel(ev), result))
+}
+
+// UpdateUsage shows the running session total on the next repaint.
+func (t *TerminalRenderer) UpdateUsage(traceID int, trace, session model.Usage) {
+	t.usage = usage.Format(session)
+}
+
+func (t *TerminalRenderer) AddCompactionMarker(c model.Compaction) {
+	t.history.WriteString(fmt.Sprintf("\033[2m%s\033[0m\n\n", compactionLabel(c)))
+	t.repaint()
+}
+
+// CompareStart announces a compare run. Answers are not streamed; each is
+// printed under its model name as it finishes.
+func (t *TerminalRenderer) CompareStart(models []string) {
+	t.models = models
+	t.SetStatus(fmt.Sprintf("comparing %d models — %s", len(models), model.KeyLabels[model.HotkeyStop]))
+}
+
+func (t *TerminalRenderer) CompareDelta(col int, delta string) {}
+
+func (t *TerminalRenderer) CompareReset(col int) {}
+
+func (t *TerminalRenderer) CompareDone(col int, answer, errMsg string) {
+	if col >= len(t.models) {
+		return
+	}
+	md := answerMarkdown(answer)
+	if errMsg != "" {
+		md = "*error: " + errMsg + "*"
+	}
+	t.Render(fmt.Sprintf("### [%d] %s\n\n%s", col+1, t.models[col], md))
+}
+
+func (t *TerminalRenderer) CompareChosen(col int) {
+	if col < len(t.models) {
+		t.history.WriteString(fmt.Sprintf("\033[2m── continuing with [%d] %s ──\033[0m\n\n", col+1, t.models[col]))
+		t.repaint()
+	}
+}
+
+func (t *TerminalRenderer) SetBranches(branches []model.BranchInfo) {}
+
+// SwitchBranch saves the printed history under from and brings back to's.
+func (t *TerminalRenderer) SwitchBranch(from, to int) {
+	if t.branches == nil {
+		t.branches = map[int]string{}
+	}
+	t.branches[from] = t.history.String()
+	t.history.Reset()
+	t.history.WriteString(t.branches[to])
+	delete(t.branches, to)
+	t.history.WriteString(fmt.Sprintf("\033[2m── branch %d ──\033[0m\n\n", to))
+	t.repaint()
+}
+
 func (t *TerminalRenderer) ClearContextData() {}
 
 func (t *TerminalRenderer) Clear() {
@@ -161,12 +317,36 @@ func (m *MultiRenderer) StreamDone() {
 	}
 }
 
+func (m *MultiRenderer) StreamAnswerDone(a model.Answer) {
+	for _, r := range m.Renderers {
+		r.StreamAnswerDone(a)
+	}
+}
+
+func (m *MultiRenderer) StreamInterrupted() {
+	for _, r := range m.Renderers {
+		r.StreamInterrupted()
+	}
+}
+
+func (m *MultiRenderer) StreamReset() {
+	for _, r := range m.Renderers {
+		r.StreamReset()
+	}
+}
+
 func (m *MultiRenderer) AppendStreamStart() {
 	for _, r := range m.Renderers {
 		r.AppendStreamStart()
 	}
 }
 
+func (m *MultiRenderer) ReplaceStreamStart(seq int) {
+	for _, r := range m.Renderers {
+		r.ReplaceStreamStart(seq)
+	}
+}
+
 func (m *MultiRenderer) AppendStreamDelta(delta string) {
 	for _, r := range m.Renderers {
 		r.AppendStreamDelta(delta)
@@ -257,6 +437,72 @@ func (m *MultiRenderer) RemoveObserveTrace(traceID int) {
 	}
 }
 
+func (m *MultiRenderer) ReasoningDelta(delta string) {
+	for _, r := range m.Renderers {
+		r.ReasoningDelta(delta)
+	}
+}
+
+func (m *MultiRenderer) ToolActivity(ev model.ToolEvent) {
+	for _, r := range m.Renderers {
+		r.ToolActivity(ev)
+	}
+}
+
+func (m *MultiRenderer) UpdateUsage(traceID int, trace, session model.Usage) {
+	for _, r := range m.Renderers {
+		r.UpdateUsage(traceID, trace, session)
+	}
+}
+
+func (m *MultiRenderer) AddCompactionMarker(c model.Compaction) {
+	for _, r := range m.Renderers {
+		r.AddCompactionMarker(c)
+	}
+}
+
+func (m *MultiRenderer) CompareStart(models []string) {
+	for _, r := range m.Renderers {
+		r.CompareStart(models)
+	}
+}
+
+func (m *MultiRenderer) CompareDelta(col int, delta string) {
+	for _, r := range m.Renderers {
+		r.CompareDelta(col, delta)
+	}
+}
+
+func (m *MultiRenderer) CompareReset(col int) {
+	for _, r := range m.Renderers {
+		r.CompareReset(col)
+	}
+}
+
+func (m *MultiRenderer) CompareDone(col int, answer, errMsg string) {
+	for _, r := range m.Renderers {
+		r.CompareDone(col, answer, errMsg)
+	}
+}
+
+func (m *MultiRenderer) CompareChosen(col int) {
+	for _, r := range m.Renderers {
+		r.CompareChosen(col)
+	}
+}
+
+func (m *MultiRenderer) SetBranches(branches []model.BranchInfo) {
+	for _, r := range m.Renderers {
+		r.SetBranches(branches)
+	}
+}
+
+func (m *MultiRenderer) SwitchBranch(from, to int) {
+	for _, r := range m.Renderers {
+		r.SwitchBranch(from, to)
+	}
+}
+
 func (m *MultiRenderer) ClearContextData() {
 	for _, r := range m.Renderers {
 		r.ClearContextData()
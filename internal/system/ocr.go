@@ -0,0 +1,156 @@
+package system
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"second-nature/internal/applog"
+)
+
+// ocrLimit is how many screenshots' text is kept, and so how many of the
+// newest screenshots one call reads.
+const ocrLimit = 32
+
+// ocrTimeout bounds one tesseract run, so a stuck one cannot hold up a
+// call or the reads queued behind it.
+const ocrTimeout = 5 * time.Second
+
+var ocrTexts = &textCache{text: make(map[[32]byte]string), reading: make(map[[32]byte]bool)}
+
+// ocrRun lets one tesseract run at a time.
+var ocrRun sync.Mutex
+
+// textCache maps the SHA-256 of a screenshot to its text, evicting the
+// oldest beyond ocrLimit. Failed reads are cached as "" so a bad image is
+// not retried on every call.
+type textCache struct {
+	mu      sync.Mutex
+	text    map[[32]byte]string
+	order   [][32]byte
+	reading map[[32]byte]bool // queued for a background read
+}
+
+func (c *textCache) get(sum [32]byte) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	text, ok := c.text[sum]
+	return text, ok
+}
+
+func (c *textCache) put(sum [32]byte, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.reading, sum)
+	if _, ok := c.text[sum]; !ok {
+		c.order = append(c.order, sum)
+	}
+	c.text[sum] = text
+	if len(c.order) > ocrLimit {
+		delete(c.text, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// claim marks sum as queued for reading, unless it already is.
+func (c *textCache) claim(sum [32]byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reading[sum] {
+		return false
+	}
+	c.reading[sum] = true
+	return true
+}
+
+// ScreenText returns the text tesseract reads off the screenshots, or ""
+// when tesseract is not installed. Each image is read once while it is
+// among the ocrLimit most recently read; once ctx is done the images not
+// read yet are left out.
+func ScreenText(ctx context.Context, images [][]byte) string {
+	images = newestImages(images)
+	if len(images) == 0 || !haveTesseract() {
+		return ""
+	}
+	texts := make([]string, 0, len(images))
+	for _, img := range images {
+		texts = append(texts, ocr(ctx, img))
+	}
+	return strings.Join(texts, "\n")
+}
+
+// CachedScreenText is ScreenText for the UI, which must not wait for
+// tesseract: images not read yet are left out and read in the background,
+// and done, if set, runs once they are so the caller can ask again.
+func CachedScreenText(images [][]byte, done func()) string {
+	images = newestImages(images)
+	if len(images) == 0 || !haveTesseract() {
+		return ""
+	}
+	var texts []string
+	var unread [][]byte
+	for _, img := range images {
+		sum := sha256.Sum256(img)
+		text, ok := ocrTexts.get(sum)
+		if ok {
+			texts = append(texts, text)
+		}
+		if !ok && ocrTexts.claim(sum) {
+			unread = append(unread, img)
+		}
+	}
+	if len(unread) > 0 {
+		go func() {
+			for _, img := range unread {
+				ocr(context.Background(), img)
+			}
+			if done != nil {
+				done()
+			}
+		}()
+	}
+	return strings.Join(texts, "\n")
+}
+
+func newestImages(images [][]byte) [][]byte {
+	return images[max(len(images)-ocrLimit, 0):]
+}
+
+func haveTesseract() bool {
+	_, err := exec.LookPath("tesseract")
+	return err == nil
+}
+
+// ocr reads one image. A run cut short by ctx is not cached, so the image
+// is read again next time; one that fails or times out is.
+func ocr(ctx context.Context, img []byte) string {
+	sum := sha256.Sum256(img)
+	if text, ok := ocrTexts.get(sum); ok {
+		return text
+	}
+	ocrRun.Lock()
+	defer ocrRun.Unlock()
+	if text, ok := ocrTexts.get(sum); ok {
+		return text
+	}
+	// Page segmentation mode 11 finds sparse text, which suits screenshots
+	// of editors and terminals better than the default page layout.
+	run, cancel := context.WithTimeout(ctx, ocrTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(run, "tesseract", "stdin", "stdout", "--psm", "11")
+	cmd.Stdin = bytes.NewReader(img)
+	cmd.WaitDelay = time.Second // do not wait on a killed run's open pipes
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return ""
+	}
+	if err != nil {
+		applog.AppLog.Warn("ocr: tesseract failed: %v", err)
+	}
+	ocrTexts.put(sum, string(out))
+	return string(out)
+}
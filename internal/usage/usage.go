@@ -0,0 +1,146 @@
+package usage
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"second-nature/internal/applog"
+	"second-nature/internal/model"
+)
+
+// DefaultPrices are list prices in USD per million tokens, matched against
+// model names by longest prefix. Override or extend via AppConfig.Prices.
+// The model.ProviderLocal entry prices every model a local server runs,
+// see PriceAs.
+var DefaultPrices = map[string]model.Price{
+	"claude-opus-4-6":        {Input: 5, Output: 25, CachedRead: 0.5, CacheWrite: 6.25},
+	"claude-opus-4-5":        {Input: 5, Output: 25, CachedRead: 0.5, CacheWrite: 6.25},
+	"claude-opus-4-1":        {Input: 15, Output: 75, CachedRead: 1.5, CacheWrite: 18.75},
+	"claude-opus-4-20250514": {Input: 15, Output: 75, CachedRead: 1.5, CacheWrite: 18.75},
+	"claude-opus-4-0":        {Input: 15, Output: 75, CachedRead: 1.5, CacheWrite: 18.75},
+	"claude-sonnet-4":        {Input: 3, Output: 15, CachedRead: 0.3, CacheWrite: 3.75},
+	"claude-haiku-4-5":       {Input: 1, Output: 5, CachedRead: 0.1, CacheWrite: 1.25},
+	"gpt-5":                  {Input: 1.25, Output: 10, CachedRead: 0.125},
+	"gpt-5-mini":             {Input: 0.25, Output: 2, CachedRead: 0.025},
+	"gpt-5-nano":             {Input: 0.05, Output: 0.4, CachedRead: 0.005},
+	"gemini-2.5-pro":         {Input: 1.25, Output: 10, CachedRead: 0.31},
+	"gemini-2.5-flash":       {Input: 0.3, Output: 2.5, CachedRead: 0.03},
+	"gemini-2.5-flash-lite":  {Input: 0.1, Output: 0.4, CachedRead: 0.01},
+	model.ProviderLocal:      {},
+}
+
+// Meter accumulates usage and cost for the session and enforces the
+// optional spending cap.
+type Meter struct {
+	mu      sync.Mutex
+	prices  map[string]model.Price
+	cap     float64
+	session model.Usage
+	warned  map[string]bool
+	aliases map[string]string // model name -> price key, see PriceAs
+}
+
+var Session = NewMeter()
+
+func NewMeter() *Meter {
+	return &Meter{prices: DefaultPrices, warned: make(map[string]bool), aliases: make(map[string]string)}
+}
+
+// PriceAs prices modelName under key when no entry matches the name
+// itself. The local provider uses it so whatever model the server runs is
+// free by default.
+func (m *Meter) PriceAs(modelName, key string) {
+	m.mu.Lock()
+	m.aliases[modelName] = key
+	m.mu.Unlock()
+}
+
+// Configure layers overrides on top of DefaultPrices and sets the spending
+// cap in USD (0 disables it).
+func (m *Meter) Configure(overrides map[string]model.Price, spendingCap float64) {
+	prices := make(map[string]model.Price, len(DefaultPrices)+len(overrides))
+	for k, v := range DefaultPrices {
+		prices[k] = v
+	}
+	for k, v := range overrides {
+		prices[k] = v
+	}
+	m.mu.Lock()
+	m.prices = prices
+	m.cap = spendingCap
+	m.mu.Unlock()
+}
+
+// Allow returns model.ErrSpendingCap once the session total reaches the cap.
+func (m *Meter) Allow() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cap <= 0 || m.session.Cost < m.cap {
+		return nil
+	}
+	return fmt.Errorf("%w ($%.2f of $%.2f)", model.ErrSpendingCap, m.session.Cost, m.cap)
+}
+
+// Record prices one call's usage, adds it to the session total and returns
+// the usage with Cost filled in.
+func (m *Meter) Record(modelName string, u model.Usage) model.Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	price, ok := m.priceFor(modelName)
+	if !ok && !m.warned[modelName] {
+		m.warned[modelName] = true
+		applog.AppLog.Warn("usage: no price for model %q, cost counted as $0", modelName)
+	}
+	u.Calls = 1
+	u.Cost = float64(u.InputTokens)*price.Input/1e6 +
+		float64(u.OutputTokens)*price.Output/1e6 +
+		float64(u.CachedTokens)*price.CachedRead/1e6 +
+		float64(u.CacheWriteTokens)*price.CacheWrite/1e6
+	m.session = m.session.Add(u)
+	applog.AppLog.Info("usage: %s in=%d out=%d cached=%d $%.4f (session $%.4f)",
+		modelName, u.InputTokens, u.OutputTokens, u.CachedTokens, u.Cost, m.session.Cost)
+	return u
+}
+
+// Total returns the session usage so far.
+func (m *Meter) Total() model.Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.session
+}
+
+// Reset zeroes the session total.
+func (m *Meter) Reset() {
+	m.mu.Lock()
+	m.session = model.Usage{}
+	m.mu.Unlock()
+}
+
+// priceFor returns the price whose key is the longest prefix of modelName,
+// falling back to the key it was aliased to with PriceAs.
+func (m *Meter) priceFor(modelName string) (model.Price, bool) {
+	best := ""
+	for k := range m.prices {
+		if strings.HasPrefix(modelName, k) && len(k) > len(best) {
+			best = k
+		}
+	}
+	if best == "" {
+		best = m.aliases[modelName]
+	}
+	price, ok := m.prices[best]
+	return price, ok && best != ""
+}
+
+// Format renders usage as a compact one-line summary.
+func Format(u model.Usage) string {
+	return fmt.Sprintf("%s in / %s out / %s cached · $%.4f", count(u.InputTokens), count(u.OutputTokens), count(u.CachedTokens), u.Cost)
+}
+
+func count(n int64) string {
+	if n >= 1000 {
+		return fmt.Sprintf("%.1fk", float64(n)/1000)
+	}
+	return fmt.Sprintf("%d", n)
+}
@@ -0,0 +1,51 @@
+package usage
+
+import (
+	"testing"
+
+	"second-nature/internal/model"
+)
+
+func TestRecordPrices(t *testing.T) {
+	m := NewMeter()
+	m.Configure(map[string]model.Price{"my-finetune": {Input: 2, Output: 4}}, 0)
+	m.PriceAs("qwen2.5-coder:32b", model.ProviderLocal)
+	m.PriceAs("my-finetune-q4", model.ProviderLocal)
+	u := model.Usage{InputTokens: 1e6, OutputTokens: 1e6, CachedTokens: 1e6}
+	tests := []struct {
+		model string
+		cost  float64
+	}{
+		{"claude-sonnet-4-5-20250929", 3 + 15 + 0.3},
+		{"claude-opus-4-6", 5 + 25 + 0.5},
+		{"claude-opus-4-5-20251101", 5 + 25 + 0.5},
+		{"claude-opus-4-20250514", 15 + 75 + 1.5},
+		{"claude-opus-4-0", 15 + 75 + 1.5},
+		{"claude-opus-4-20251231", 0}, // a later snapshot is not priced as Opus 4
+		{"gpt-5-mini-2025-08-07", 0.25 + 2 + 0.025},
+		{"gemini-2.5-flash-lite", 0.1 + 0.4 + 0.01},
+		{"qwen2.5-coder:32b", 0},  // local, by provider kind
+		{"my-finetune-q4", 2 + 4}, // a name match beats the kind
+		{"some-unknown-model", 0}, // unpriced, warned once
+	}
+	for _, tt := range tests {
+		if got := m.Record(tt.model, u).Cost; got < tt.cost-1e-9 || got > tt.cost+1e-9 {
+			t.Errorf("%s: cost = %v, want %v", tt.model, got, tt.cost)
+		}
+	}
+	if !m.warned["some-unknown-model"] || !m.warned["claude-opus-4-20251231"] || m.warned["qwen2.5-coder:32b"] {
+		t.Errorf("warned = %v, want only the unpriced models", m.warned)
+	}
+}
+
+func TestSpendingCap(t *testing.T) {
+	m := NewMeter()
+	m.Configure(nil, 1)
+	if err := m.Allow(); err != nil {
+		t.Fatalf("Allow before spending: %v", err)
+	}
+	m.Record("gpt-5", model.Usage{OutputTokens: 1e5})
+	if err := m.Allow(); err == nil {
+		t.Errorf("Allow after $%.2f of a $1 cap: want ErrSpendingCap", m.Total().Cost)
+	}
+}